@@ -0,0 +1,420 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/progress"
+	"github.com/breathbath/dumper/retry"
+	"github.com/breathbath/dumper/throttle"
+	"github.com/breathbath/go_utils/v3/pkg/env"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const AzureUploader = "azure"
+
+const (
+	apiVersion         = "2020-10-02"
+	defaultBlockSizeMb = 16
+	bytesInMb          = 1024 * 1024
+	blockIDFormat      = "block-%08d"
+)
+
+type UploadConfig struct {
+	Endpoint         string        `json:"endpoint"`
+	Account          string        `json:"account"`
+	Key              string        `json:"key"`
+	KeyPath          string        `json:"keyPath"`
+	SASToken         string        `json:"sasToken"`
+	Container        string        `json:"container"`
+	RemoteFolder     string        `json:"folder"`
+	AccessTier       string        `json:"accessTier"`
+	BlockSizeMb      int           `json:"blockSizeMb"`
+	UploadTimeoutRaw string        `json:"timeout"`
+	UploadTimeout    time.Duration `json:"-"`
+	RateLimit        string        `json:"rateLimit"`
+}
+
+func (mc *UploadConfig) Validate() error {
+	fields := []*validation.FieldRules{
+		validation.Field(&mc.Account, validation.Required),
+		validation.Field(&mc.Container, validation.Required),
+		validation.Field(&mc.Key, validation.By(func(value interface{}) error {
+			if fmt.Sprint(value) == "" && mc.KeyPath == "" && mc.SASToken == "" {
+				return errors.New("either key, key path or sas token should be provided")
+			}
+
+			return nil
+		})),
+		validation.Field(&mc.AccessTier, validation.In("Hot", "Cool", "Cold", "Archive")),
+		validation.Field(&mc.BlockSizeMb, validation.Min(1)),
+		validation.Field(&mc.RateLimit, validation.By(func(value interface{}) error {
+			_, err := throttle.ParseSchedule(fmt.Sprint(value))
+			return err
+		})),
+		validation.Field(&mc.UploadTimeoutRaw, config.DurationRule),
+	}
+
+	return validation.ValidateStruct(mc, fields...)
+}
+
+func NewConfigFromEnvs() *UploadConfig {
+	cfg := &UploadConfig{}
+	cfg.Endpoint = env.ReadEnv("AZURE_ENDPOINT", "")
+	cfg.Account = env.ReadEnv("AZURE_ACCOUNT", "")
+	cfg.Key = env.ReadEnv("AZURE_KEY", "")
+	cfg.KeyPath = env.ReadEnv("AZURE_KEY_PATH", "")
+	cfg.SASToken = env.ReadEnv("AZURE_SAS_TOKEN", "")
+	cfg.Container = env.ReadEnv("AZURE_CONTAINER", "")
+	cfg.RemoteFolder = env.ReadEnv("AZURE_FOLDER", "")
+	cfg.AccessTier = env.ReadEnv("AZURE_ACCESS_TIER", "")
+
+	cfg.BlockSizeMb = defaultBlockSizeMb
+	blockSizeRaw := env.ReadEnv("AZURE_BLOCK_SIZE_MB", "")
+	if blockSizeRaw != "" {
+		blockSize, err := strconv.Atoi(blockSizeRaw)
+		if err == nil {
+			cfg.BlockSizeMb = blockSize
+		}
+	}
+
+	cfg.RateLimit = env.ReadEnv("AZURE_RATE_LIMIT", "")
+	cfg.UploadTimeoutRaw, cfg.UploadTimeout = config.ReadDurationEnv("AZURE_UPLOADER_TIMEOUT", 0)
+
+	return cfg
+}
+
+func NewConfigFromSettings(settings []byte) (*UploadConfig, error) {
+	cfg := &UploadConfig{
+		BlockSizeMb: defaultBlockSizeMb,
+	}
+	err := config.ParseUploaderSettings(
+		AzureUploader, settings, cfg,
+		&cfg.Endpoint, &cfg.Account, &cfg.Key, &cfg.KeyPath, &cfg.SASToken, &cfg.Container, &cfg.RemoteFolder,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.UploadTimeout = config.ParseDuration(cfg.UploadTimeoutRaw, 0)
+
+	return cfg, nil
+}
+
+type ResponseErr struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type blockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+type Service struct {
+	cfg     *UploadConfig
+	limiter *throttle.Limiter
+}
+
+func NewService(cfg *UploadConfig) *Service {
+	if cfg.BlockSizeMb == 0 {
+		cfg.BlockSizeMb = defaultBlockSizeMb
+	}
+
+	return &Service{
+		cfg:     cfg,
+		limiter: throttle.NewLimiterFromSpec(AzureUploader, cfg.RateLimit),
+	}
+}
+
+// see https://learn.microsoft.com/en-us/rest/api/storageservices/put-block-list for details
+func (s *Service) Upload(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return s.UploadStream(remotePath, file)
+}
+
+// UploadStream reads data of unknown size in blocks which are committed with a block list at the end
+func (s *Service) UploadStream(name string, r io.Reader) error {
+	if err := s.cfg.Validate(); err != nil {
+		return retry.Permanent(err)
+	}
+
+	key, err := s.readKey()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	if s.cfg.UploadTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), s.cfg.UploadTimeout)
+		defer cancel()
+	}
+
+	blobName := join(s.cfg.RemoteFolder, name)
+
+	io2.OutputInfo("", "Will upload %s to azure container %q, blob %q", name, s.cfg.Container, blobName)
+
+	pr := progress.NewReader(name, r, -1)
+	defer pr.Close()
+
+	err = s.upload(ctx, key, blobName, throttle.NewReader(ctx, pr, s.limiter))
+	if err != nil {
+		return err
+	}
+
+	io2.OutputInfo("", "successfully uploaded %s to azure container %q, blob %q", name, s.cfg.Container, blobName)
+
+	return nil
+}
+
+func (s *Service) upload(ctx context.Context, key []byte, blobName string, r io.Reader) error {
+	blockSize := s.cfg.BlockSizeMb * bytesInMb
+	blockIDs := []string{}
+
+	for blockNumber := 1; ; blockNumber++ {
+		block, err := readPart(r, blockSize)
+		if err != nil {
+			return err
+		}
+
+		if len(block) == 0 {
+			break
+		}
+
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(blockIDFormat, blockNumber)))
+		query := url.Values{"comp": {"block"}, "blockid": {blockID}}
+
+		err = s.put(ctx, key, blobName, query, block, nil)
+		if err != nil {
+			return err
+		}
+		blockIDs = append(blockIDs, blockID)
+		io2.OutputInfo("", "uploaded block %d (%d bytes) of %q", blockNumber, len(block), blobName)
+
+		if len(block) < blockSize {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(blockList{Latest: blockIDs})
+	if err != nil {
+		return err
+	}
+	body = append([]byte(xml.Header), body...)
+
+	headers := map[string]string{
+		"Content-Type":           "application/xml",
+		"x-ms-blob-content-type": "application/octet-stream",
+	}
+	if s.cfg.AccessTier != "" {
+		headers["x-ms-access-tier"] = s.cfg.AccessTier
+	}
+
+	return s.put(ctx, key, blobName, url.Values{"comp": {"blocklist"}}, body, headers)
+}
+
+func (s *Service) put(
+	ctx context.Context,
+	key []byte,
+	blobName string,
+	query url.Values,
+	body []byte,
+	headers map[string]string,
+) error {
+	u, err := s.buildURL(blobName, query)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("x-ms-version", apiVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	if len(key) > 0 {
+		req.Header.Set("Authorization", s.sign(req, key))
+	}
+
+	cl := &http.Client{}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call azure blob api %s: %v", req.Method, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		io2.OutputWarning("", "failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return s.parseError(resp.StatusCode, respBody)
+	}
+
+	return nil
+}
+
+func (s *Service) buildURL(blobName string, query url.Values) (*url.URL, error) {
+	endpoint := s.cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", s.cfg.Account)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = join(u.Path, "/"+s.cfg.Container+"/"+blobName)
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+
+	sasValues, err := url.ParseQuery(strings.TrimPrefix(s.cfg.SASToken, "?"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sas token: %v", err)
+	}
+	for k, vals := range query {
+		sasValues[k] = vals
+	}
+	u.RawQuery = sasValues.Encode()
+
+	return u, nil
+}
+
+// see https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key for details
+func (s *Service) sign(req *http.Request, key []byte) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	msHeaderNames := []string{}
+	for name := range req.Header {
+		lowerName := strings.ToLower(name)
+		if strings.HasPrefix(lowerName, "x-ms-") {
+			msHeaderNames = append(msHeaderNames, lowerName)
+		}
+	}
+	sort.Strings(msHeaderNames)
+
+	canonicalHeaders := ""
+	for _, name := range msHeaderNames {
+		canonicalHeaders += name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n"
+	}
+
+	canonicalResource := "/" + s.cfg.Account + req.URL.EscapedPath()
+	queryValues := req.URL.Query()
+	queryNames := make([]string, 0, len(queryValues))
+	for name := range queryValues {
+		queryNames = append(queryNames, name)
+	}
+	sort.Strings(queryNames)
+	for _, name := range queryNames {
+		values := queryValues[name]
+		sort.Strings(values)
+		canonicalResource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + canonicalHeaders + canonicalResource
+
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(stringToSign))
+
+	return fmt.Sprintf("SharedKey %s:%s", s.cfg.Account, base64.StdEncoding.EncodeToString(h.Sum(nil)))
+}
+
+func (s *Service) readKey() ([]byte, error) {
+	keyStr := s.cfg.Key
+	if s.cfg.KeyPath != "" {
+		keyBytes, err := os.ReadFile(s.cfg.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read azure key from %s: %v", s.cfg.KeyPath, err)
+		}
+		keyStr = strings.TrimSpace(string(keyBytes))
+	}
+
+	// sas token is used for authorization
+	if keyStr == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(keyStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode azure key: %v", err)
+	}
+
+	return key, nil
+}
+
+func (s *Service) parseError(statusCode int, respBody []byte) error {
+	errResp := new(ResponseErr)
+	err := xml.Unmarshal(respBody, errResp)
+	if err != nil || errResp.Code == "" {
+		return retry.ClassifyStatus(statusCode, fmt.Errorf("wrong response code %d from azure: %s", statusCode, string(respBody)))
+	}
+
+	return retry.ClassifyStatus(
+		statusCode,
+		fmt.Errorf("wrong response code %d from azure, message %s[%s]", statusCode, errResp.Message, errResp.Code),
+	)
+}
+
+func readPart(r io.Reader, partSize int) ([]byte, error) {
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
+
+func join(path0, path1 string) string {
+	if path0 == "" {
+		return strings.TrimPrefix(path1, "/")
+	}
+
+	return strings.TrimSuffix(path0, "/") + "/" + strings.TrimPrefix(path1, "/")
+}
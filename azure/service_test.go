@@ -0,0 +1,303 @@
+package azure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/breathbath/dumper/retry"
+)
+
+const (
+	testAccount   = "myaccount"
+	testContainer = "backups"
+)
+
+var testKey = []byte("secret account key")
+
+func TestSignSharedKey(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		url                  string
+		headers              map[string]string
+		contentLength        int64
+		expectedStringToSign string
+	}{
+		{
+			name: "put block",
+			url:  "https://myaccount.blob.core.windows.net/backups/dumps/db%20one.sql.gz?comp=block&blockid=YmxvY2stMDAwMDAwMDE%3D",
+			headers: map[string]string{
+				"x-ms-date":    "Fri, 26 Jun 2015 23:39:12 GMT",
+				"x-ms-version": apiVersion,
+			},
+			contentLength: 5,
+			expectedStringToSign: "PUT\n\n\n5\n\n\n\n\n\n\n\n\n" +
+				"x-ms-date:Fri, 26 Jun 2015 23:39:12 GMT\nx-ms-version:2020-10-02\n" +
+				"/myaccount/backups/dumps/db%20one.sql.gz\nblockid:YmxvY2stMDAwMDAwMDE=\ncomp:block",
+		},
+		{
+			name: "put block list",
+			url:  "https://myaccount.blob.core.windows.net/backups/db.sql.gz?comp=blocklist",
+			headers: map[string]string{
+				"Content-Type":           "application/xml",
+				"x-ms-version":           apiVersion,
+				"x-ms-date":              "Fri, 26 Jun 2015 23:39:12 GMT",
+				"x-ms-blob-content-type": "application/octet-stream",
+				"x-ms-access-tier":       "Cool",
+			},
+			contentLength: 120,
+			expectedStringToSign: "PUT\n\n\n120\n\napplication/xml\n\n\n\n\n\n\n" +
+				"x-ms-access-tier:Cool\nx-ms-blob-content-type:application/octet-stream\n" +
+				"x-ms-date:Fri, 26 Jun 2015 23:39:12 GMT\nx-ms-version:2020-10-02\n" +
+				"/myaccount/backups/db.sql.gz\ncomp:blocklist",
+		},
+		{
+			name: "empty body",
+			url:  "https://myaccount.blob.core.windows.net/backups/db.sql.gz?comp=blocklist",
+			headers: map[string]string{
+				"x-ms-date":    "Fri, 26 Jun 2015 23:39:12 GMT",
+				"x-ms-version": apiVersion,
+			},
+			contentLength: 0,
+			expectedStringToSign: "PUT\n\n\n\n\n\n\n\n\n\n\n\n" +
+				"x-ms-date:Fri, 26 Jun 2015 23:39:12 GMT\nx-ms-version:2020-10-02\n" +
+				"/myaccount/backups/db.sql.gz\ncomp:blocklist",
+		},
+	}
+
+	s := NewService(&UploadConfig{Account: testAccount})
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, testCase.url, http.NoBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.ContentLength = testCase.contentLength
+			for name, value := range testCase.headers {
+				req.Header.Set(name, value)
+			}
+
+			h := hmac.New(sha256.New, testKey)
+			_, _ = h.Write([]byte(testCase.expectedStringToSign))
+			expectedAuth := "SharedKey myaccount:" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+			if auth := s.sign(req, testKey); auth != expectedAuth {
+				t.Errorf("expected authorization header %q, got %q", expectedAuth, auth)
+			}
+		})
+	}
+}
+
+// fakeAzure is a blob service which verifies shared key signatures and keeps blobs in memory
+type fakeAzure struct {
+	t        *testing.T
+	mu       sync.Mutex
+	signer   *Service
+	blocks   map[string][]byte
+	blobs    map[string][]byte
+	tiers    map[string]string
+	requests []string
+	// failBlock makes the upload of the block with this number fail with failStatus
+	failBlock  int
+	failStatus int
+}
+
+func newFakeAzure(t *testing.T) *fakeAzure {
+	return &fakeAzure{
+		t:      t,
+		signer: NewService(&UploadConfig{Account: testAccount}),
+		blocks: map[string][]byte{},
+		blobs:  map[string][]byte{},
+		tiers:  map[string]string{},
+	}
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	r.ContentLength = int64(len(body))
+	if expectedAuth := f.signer.sign(r, testKey); auth != expectedAuth {
+		f.t.Errorf("signature mismatch for %s %s:\nexpected %s\ngot %s", r.Method, r.URL, expectedAuth, auth)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Error><Code>AuthenticationFailed</Code><Message>signature mismatch</Message></Error>`)
+		return
+	}
+
+	blobName := strings.TrimPrefix(r.URL.Path, "/"+testContainer+"/")
+	query := r.URL.Query()
+	f.requests = append(f.requests, r.Method+" "+query.Get("comp"))
+
+	switch query.Get("comp") {
+	case "block":
+		if len(f.requests) == f.failBlock {
+			w.WriteHeader(f.failStatus)
+			fmt.Fprint(w, `<Error><Code>Failure</Code><Message>block failed</Message></Error>`)
+			return
+		}
+		f.blocks[query.Get("blockid")] = body
+	case "blocklist":
+		list := new(blockList)
+		err = xml.Unmarshal(body, list)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		content := []byte{}
+		for _, blockID := range list.Latest {
+			block, ok := f.blocks[blockID]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `<Error><Code>InvalidBlockList</Code><Message>unknown block</Message></Error>`)
+				return
+			}
+			content = append(content, block...)
+		}
+		f.blobs[blobName] = content
+		f.tiers[blobName] = r.Header.Get("x-ms-access-tier")
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func newTestService(t *testing.T, f *fakeAzure) *Service {
+	t.Helper()
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	return NewService(&UploadConfig{
+		Endpoint:     srv.URL,
+		Account:      testAccount,
+		Key:          base64.StdEncoding.EncodeToString(testKey),
+		Container:    testContainer,
+		RemoteFolder: "dumps",
+		AccessTier:   "Cool",
+		BlockSizeMb:  1,
+	})
+}
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	return content
+}
+
+func TestUploadStreamCommitsBlocks(t *testing.T) {
+	const blockSize = bytesInMb
+
+	testCases := []struct {
+		name             string
+		size             int
+		expectedRequests []string
+	}{
+		{
+			name:             "empty stream",
+			size:             0,
+			expectedRequests: []string{"PUT blocklist"},
+		},
+		{
+			name:             "single block",
+			size:             1024,
+			expectedRequests: []string{"PUT block", "PUT blocklist"},
+		},
+		{
+			name:             "several blocks",
+			size:             2*blockSize + 10,
+			expectedRequests: []string{"PUT block", "PUT block", "PUT block", "PUT blocklist"},
+		},
+		{
+			name:             "whole blocks",
+			size:             2 * blockSize,
+			expectedRequests: []string{"PUT block", "PUT block", "PUT blocklist"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			f := newFakeAzure(t)
+			s := newTestService(t, f)
+			content := testContent(testCase.size)
+
+			err := s.UploadStream("daily/db one.sql.gz", bytes.NewReader(content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if strings.Join(f.requests, ", ") != strings.Join(testCase.expectedRequests, ", ") {
+				t.Errorf("expected requests %v, got %v", testCase.expectedRequests, f.requests)
+			}
+
+			uploaded, ok := f.blobs["dumps/daily/db one.sql.gz"]
+			if !ok {
+				t.Fatal("blob is not found")
+			}
+			if !bytes.Equal(uploaded, content) {
+				t.Errorf("uploaded content of %d bytes differs from the local content of %d bytes", len(uploaded), len(content))
+			}
+			if tier := f.tiers["dumps/daily/db one.sql.gz"]; tier != "Cool" {
+				t.Errorf("expected the Cool access tier, got %q", tier)
+			}
+		})
+	}
+}
+
+func TestUploadStreamDoesNotCommitFailedBlocks(t *testing.T) {
+	testCases := []struct {
+		name        string
+		failStatus  int
+		isPermanent bool
+	}{
+		{name: "access denied", failStatus: http.StatusForbidden, isPermanent: true},
+		{name: "server error", failStatus: http.StatusServiceUnavailable, isPermanent: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			f := newFakeAzure(t)
+			f.failBlock = 2
+			f.failStatus = testCase.failStatus
+			s := newTestService(t, f)
+
+			err := s.UploadStream("db.sql.gz", bytes.NewReader(testContent(3*bytesInMb)))
+			if err == nil || !strings.Contains(err.Error(), "block failed[Failure]") {
+				t.Fatalf("expected the error from azure, got %v", err)
+			}
+			if retry.IsPermanent(err) != testCase.isPermanent {
+				t.Errorf("expected permanent %v, got error %v", testCase.isPermanent, err)
+			}
+
+			expectedRequests := []string{"PUT block", "PUT block"}
+			if strings.Join(f.requests, ", ") != strings.Join(expectedRequests, ", ") {
+				t.Errorf("expected requests %v, got %v", expectedRequests, f.requests)
+			}
+			if len(f.blobs) != 0 {
+				t.Errorf("expected no committed blobs, got %d", len(f.blobs))
+			}
+		})
+	}
+}
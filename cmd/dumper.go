@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/breathbath/dumper/config"
 	"github.com/breathbath/dumper/exec"
-	"github.com/breathbath/dumper/yand"
+	"github.com/breathbath/dumper/outbox"
+	"github.com/breathbath/dumper/throttle"
 	"github.com/breathbath/go_utils/v3/pkg/env"
 	"github.com/breathbath/go_utils/v3/pkg/errs"
 	"github.com/breathbath/go_utils/v3/pkg/io"
@@ -25,37 +28,75 @@ var dumpCmd = &cobra.Command{
 		cmd.SilenceErrors = true
 		io.OutputInfo("", "Starting dump executor")
 
-		confs, err := config.ParseConfig()
+		configFile, err := config.ParseConfig()
+		if err != nil {
+			return err
+		}
+
+		err = exec.ValidateRetentionScopes(configFile.Jobs)
 		if err != nil {
 			return err
 		}
 
+		err = throttle.ValidateIORate()
+		if err != nil {
+			return err
+		}
+
+		uploaders, err := buildUploaders(configFile.Uploaders)
+		if err != nil {
+			return err
+		}
+
+		ob, err := buildOutbox()
+		if err != nil {
+			return err
+		}
+		exec.FlushOutbox(ob, uploaders)
+
 		c := cron.New()
 
 		ers := errs.NewErrorContainer()
 
-		for _, conf := range confs {
+		for _, conf := range configFile.Jobs {
 			if conf.Period == "" {
 				io.OutputWarning("", "config '%s' has empty execution period, will skip it", conf.Name)
 				continue
 			}
 
-			cfg := yand.NewConfigFromEnvs()
-			yandexUploader := yand.NewService(cfg)
-
-			uploaders := map[string]exec.Uploader{
-				yand.YandexUploader: yandexUploader,
-			}
 			router := exec.Router{
 				Executors: map[string]exec.Executor{
 					"mysql": exec.MysqlDumpExecutor{
-						Uploaders: uploaders,
+						Uploaders:    uploaders,
+						UploadHelper: exec.UploadHelper{Outbox: ob},
 					},
 					"tar": exec.TarExecutor{
+						Uploaders:    uploaders,
+						UploadHelper: exec.UploadHelper{Outbox: ob},
+					},
+					"postgres": exec.PostgresDumpExecutor{
+						Uploaders:    uploaders,
+						UploadHelper: exec.UploadHelper{Outbox: ob},
+					},
+					"mongodb": exec.MongoDumpExecutor{
+						Uploaders:    uploaders,
+						UploadHelper: exec.UploadHelper{Outbox: ob},
+					},
+					"redis": exec.RedisDumpExecutor{
+						Uploaders:    uploaders,
+						UploadHelper: exec.UploadHelper{Outbox: ob},
+					},
+					"sqlite": exec.SqliteExecutor{
+						Uploaders:    uploaders,
+						UploadHelper: exec.UploadHelper{Outbox: ob},
+					},
+					"sync": exec.SyncExecutor{
 						Uploaders: uploaders,
 					},
 				},
 				GeneralConfig: conf,
+				Outbox:        ob,
+				Uploaders:     uploaders,
 			}
 			if env.ReadEnvBool("RUN_ON_STARTUP", false) {
 				io.OutputInfo("", "Will run '%s'", conf.Name)
@@ -83,3 +124,18 @@ var dumpCmd = &cobra.Command{
 		return nil
 	},
 }
+
+// buildOutbox creates the outbox configured with OUTBOX_PATH, it's disabled if the path is empty
+func buildOutbox() (*outbox.Outbox, error) {
+	cfg := outbox.NewConfigFromEnvs()
+	if cfg.Path == "" {
+		return nil, nil
+	}
+
+	ob, err := outbox.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot init outbox: %v", err)
+	}
+
+	return ob, nil
+}
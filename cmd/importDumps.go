@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/breathbath/dumper/config"
 	"github.com/breathbath/dumper/exec"
@@ -19,37 +20,35 @@ func initImportDumps() {
 var importDumpsCmd = &cobra.Command{
 	Use:   "import_dumps",
 	Short: "Import dumps",
+	Long:  "Import the latest dumps of import_dumps (mysql), import_pg_dumps (postgres) and import_mongo_dumps (mongodb) jobs",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		io.OutputInfo("", "Db conn names will be used to import: %v", *connNamesToImport)
 		cmd.SilenceUsage = true
 		cmd.SilenceErrors = true
 		io.OutputInfo("", "Starting importing dumps")
 
-		confs, err := config.ParseConfig()
+		configFile, err := config.ParseConfig()
 		if err != nil {
 			return err
 		}
 
-		importer := exec.MysqlImportExecutor{}
-		importConf := new(exec.ImportConfig)
 		var lastErr error
-		for _, conf := range confs {
-			if conf.Kind != "import_dumps" {
+		for _, conf := range configFile.Jobs {
+			var err error
+			switch conf.Kind {
+			case "import_dumps":
+				err = importMysqlDumps(conf)
+			case "import_pg_dumps":
+				err = importPgDumps(conf)
+			case "import_mongo_dumps":
+				err = importMongoDumps(conf)
+			default:
 				continue
 			}
 
-			err := json.Unmarshal([]byte(*conf.Context), importConf)
 			if err != nil {
 				lastErr = err
-				io.OutputError(err, "", "Failed to parse config: %v", err)
-				continue
-			}
-
-			err = importer.Execute(importConf, *connNamesToImport)
-			if err != nil {
-				lastErr = err
-				io.OutputError(err, "", "Failed to execute importer: %v", err)
-				continue
+				io.OutputError(err, "", "Failed to import dumps of '%s': %v", conf.Name, err)
 			}
 		}
 
@@ -60,3 +59,33 @@ var importDumpsCmd = &cobra.Command{
 		return lastErr
 	},
 }
+
+func importMysqlDumps(conf *config.Config) error {
+	importConf := new(exec.ImportConfig)
+	err := json.Unmarshal([]byte(*conf.Context), importConf)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	return exec.MysqlImportExecutor{}.Execute(importConf, *connNamesToImport)
+}
+
+func importPgDumps(conf *config.Config) error {
+	importConf := new(exec.PgImportConfig)
+	err := json.Unmarshal([]byte(*conf.Context), importConf)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	return exec.PostgresImportExecutor{}.Execute(importConf, *connNamesToImport)
+}
+
+func importMongoDumps(conf *config.Config) error {
+	importConf := new(exec.MongoImportConfig)
+	err := json.Unmarshal([]byte(*conf.Context), importConf)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	return exec.MongoImportExecutor{}.Execute(importConf, *connNamesToImport)
+}
@@ -16,6 +16,7 @@ func Execute() error {
 	initImportDumps()
 	initVersion()
 	initDumper()
+	initSync()
 	if err := rootCmd.Execute(); err != nil {
 		return err
 	}
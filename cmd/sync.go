@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/exec"
+	"github.com/breathbath/go_utils/v3/pkg/io"
+	"github.com/spf13/cobra"
+)
+
+var syncConfig = &exec.SyncConfig{}
+
+func initSync() {
+	syncCmd.Flags().StringVar(&syncConfig.From, "from", "", "name of the uploader to copy files from")
+	syncCmd.Flags().StringVar(&syncConfig.To, "to", "", "name of the uploader to copy missing files to")
+	syncCmd.Flags().StringVar(&syncConfig.Prefix, "prefix", "", "copy only files which names start with the prefix")
+	syncCmd.Flags().StringVar(&syncConfig.TmpPath, "tmp", "", "folder for temp files if the target doesn't support streams")
+	syncCmd.Flags().BoolVar(&syncConfig.DryRun, "dry-run", false, "only log files which would be copied")
+	rootCmd.AddCommand(syncCmd)
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Copy files missing in one upload destination from another one",
+	Long: "Copy files which are missing or differ in the target uploader from the source one, " +
+		"e.g. `./dumper sync --from yandex-main --to s3-archive`, uploaders are configured in CONFIG_PATH file and envs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+
+		configFile, err := config.ParseConfig()
+		if err != nil {
+			return err
+		}
+
+		uploaders, err := buildUploaders(configFile.Uploaders)
+		if err != nil {
+			return err
+		}
+
+		syncExecutor := exec.SyncExecutor{
+			Uploaders: uploaders,
+		}
+
+		err = syncExecutor.ValidateConfig(syncConfig)
+		if err != nil {
+			return err
+		}
+
+		io.OutputInfo("", "Starting sync from %s to %s", syncConfig.From, syncConfig.To)
+
+		result := exec.NewJobResult("sync")
+		err = syncExecutor.Sync(syncConfig, result)
+		result.Finish(err)
+		result.Log()
+
+		return err
+	},
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/breathbath/dumper/azure"
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/exec"
+	"github.com/breathbath/dumper/gcs"
+	"github.com/breathbath/dumper/localfs"
+	"github.com/breathbath/dumper/s3"
+	"github.com/breathbath/dumper/sftp"
+	"github.com/breathbath/dumper/webdav"
+	"github.com/breathbath/dumper/yand"
+	"github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+type uploaderFactory func(settings []byte) (exec.Uploader, error)
+
+var uploaderFactories = map[string]uploaderFactory{
+	yand.YandexUploader: func(settings []byte) (exec.Uploader, error) {
+		cfg, err := yand.NewConfigFromSettings(settings)
+		if err != nil {
+			return nil, err
+		}
+		return yand.NewService(cfg), nil
+	},
+	s3.S3Uploader: func(settings []byte) (exec.Uploader, error) {
+		cfg, err := s3.NewConfigFromSettings(settings)
+		if err != nil {
+			return nil, err
+		}
+		return s3.NewService(cfg), nil
+	},
+	sftp.SftpUploader: func(settings []byte) (exec.Uploader, error) {
+		cfg, err := sftp.NewConfigFromSettings(settings)
+		if err != nil {
+			return nil, err
+		}
+		return sftp.NewService(cfg), nil
+	},
+	webdav.WebdavUploader: func(settings []byte) (exec.Uploader, error) {
+		cfg, err := webdav.NewConfigFromSettings(settings)
+		if err != nil {
+			return nil, err
+		}
+		return webdav.NewService(cfg), nil
+	},
+	localfs.FilesystemUploader: func(settings []byte) (exec.Uploader, error) {
+		cfg, err := localfs.NewConfigFromSettings(settings)
+		if err != nil {
+			return nil, err
+		}
+		return localfs.NewService(cfg), nil
+	},
+	gcs.GcsUploader: func(settings []byte) (exec.Uploader, error) {
+		cfg, err := gcs.NewConfigFromSettings(settings)
+		if err != nil {
+			return nil, err
+		}
+		return gcs.NewService(cfg), nil
+	},
+	azure.AzureUploader: func(settings []byte) (exec.Uploader, error) {
+		cfg, err := azure.NewConfigFromSettings(settings)
+		if err != nil {
+			return nil, err
+		}
+		return azure.NewService(cfg), nil
+	},
+}
+
+// buildUploaders registers uploaders configured with env variables under their type names
+// and named uploader instances from the config file, the latter can override the former
+func buildUploaders(uploaderConfigs []*config.Uploader) (map[string]exec.Uploader, error) {
+	uploaders := map[string]exec.Uploader{
+		yand.YandexUploader:        yand.NewService(yand.NewConfigFromEnvs()),
+		s3.S3Uploader:              s3.NewService(s3.NewConfigFromEnvs()),
+		sftp.SftpUploader:          sftp.NewService(sftp.NewConfigFromEnvs()),
+		webdav.WebdavUploader:      webdav.NewService(webdav.NewConfigFromEnvs()),
+		localfs.FilesystemUploader: localfs.NewService(localfs.NewConfigFromEnvs()),
+		gcs.GcsUploader:            gcs.NewService(gcs.NewConfigFromEnvs()),
+		azure.AzureUploader:        azure.NewService(azure.NewConfigFromEnvs()),
+	}
+
+	namedUploaders := map[string]bool{}
+	for _, uploaderConfig := range uploaderConfigs {
+		if uploaderConfig.Name == "" {
+			return nil, fmt.Errorf("empty name for uploader of type '%s'", uploaderConfig.Type)
+		}
+
+		if namedUploaders[uploaderConfig.Name] {
+			return nil, fmt.Errorf("duplicate uploader name '%s'", uploaderConfig.Name)
+		}
+
+		factory, ok := uploaderFactories[uploaderConfig.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown type '%s' of uploader '%s'", uploaderConfig.Type, uploaderConfig.Name)
+		}
+
+		settings := []byte("{}")
+		if uploaderConfig.Settings != nil {
+			settings = *uploaderConfig.Settings
+		}
+
+		uploader, err := factory(settings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid settings of uploader '%s': %v", uploaderConfig.Name, err)
+		}
+
+		io.OutputInfo("", "Registered uploader '%s' of type '%s'", uploaderConfig.Name, uploaderConfig.Type)
+		uploaders[uploaderConfig.Name] = uploader
+		namedUploaders[uploaderConfig.Name] = true
+	}
+
+	return uploaders, nil
+}
@@ -9,8 +9,8 @@ import (
 	"github.com/breathbath/go_utils/v3/pkg/fs"
 )
 
-func ParseConfig() ([]*Config, error) {
-	var conf []*Config
+func ParseConfig() (*File, error) {
+	conf := new(File)
 
 	configPath, err := env.ReadEnvOrError("CONFIG_PATH")
 	if err != nil {
@@ -26,7 +26,7 @@ func ParseConfig() ([]*Config, error) {
 		return conf, err
 	}
 
-	err = json.Unmarshal(yamlFile, &conf)
+	err = json.Unmarshal(yamlFile, conf)
 	if err != nil {
 		return conf, fmt.Errorf("cannot parse config file '%s': %v", configPath, err)
 	}
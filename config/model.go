@@ -1,6 +1,9 @@
 package config
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+)
 
 type Config struct {
 	Name    string           `json:"name"`
@@ -8,3 +11,28 @@ type Config struct {
 	Context *json.RawMessage `json:"context"`
 	Period  string           `json:"period,omitempty"`
 }
+
+// Uploader is a named uploader instance, jobs refer to it by name in their upload section
+type Uploader struct {
+	Name     string           `json:"name"`
+	Type     string           `json:"type"`
+	Settings *json.RawMessage `json:"settings"`
+}
+
+type File struct {
+	Uploaders []*Uploader `json:"uploaders,omitempty"`
+	Jobs      []*Config   `json:"jobs"`
+}
+
+// UnmarshalJSON supports the legacy format where the whole file is a list of jobs
+func (f *File) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		return json.Unmarshal(data, &f.Jobs)
+	}
+
+	type fileAlias File
+	alias := (*fileAlias)(f)
+
+	return json.Unmarshal(data, alias)
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/breathbath/dumper/cli"
+	"github.com/breathbath/go_utils/v3/pkg/env"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// DurationRule accepts durations like 30s or 2h in uploader settings, empty values are allowed
+var DurationRule = validation.By(func(value interface{}) error {
+	valStr := fmt.Sprint(value)
+	if valStr == "" {
+		return nil
+	}
+
+	_, err := time.ParseDuration(valStr)
+
+	return err
+})
+
+// ParseDuration converts a raw duration checked by DurationRule, empty or invalid values give the fallback
+func ParseDuration(raw string, fallback time.Duration) time.Duration {
+	if raw == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return fallback
+	}
+
+	return duration
+}
+
+// ReadDurationEnv reads a raw duration from the env variable and returns it together with its parsed value
+func ReadDurationEnv(name string, fallback time.Duration) (raw string, duration time.Duration) {
+	raw = env.ReadEnv(name, "")
+
+	return raw, ParseDuration(raw, fallback)
+}
+
+// ParseUploaderSettings reads json settings of an uploader into cfg, resolves env placeholders like ${S3_BUCKET}
+// in the given fields and validates the result
+func ParseUploaderSettings(uploaderType string, settings []byte, cfg validation.Validatable, placeholders ...*string) error {
+	err := json.Unmarshal(settings, cfg)
+	if err != nil {
+		return fmt.Errorf("cannot parse %s uploader settings: %v", uploaderType, err)
+	}
+
+	for _, value := range placeholders {
+		*value = cli.GetEnvOrValue(*value)
+	}
+
+	return cfg.Validate()
+}
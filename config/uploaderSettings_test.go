@@ -0,0 +1,93 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+type testSettings struct {
+	Folder     string `json:"folder"`
+	TimeoutRaw string `json:"timeout"`
+}
+
+func (ts *testSettings) Validate() error {
+	return validation.ValidateStruct(ts,
+		validation.Field(&ts.Folder, validation.Required),
+		validation.Field(&ts.TimeoutRaw, DurationRule),
+	)
+}
+
+func TestParseUploaderSettings(t *testing.T) {
+	t.Setenv("TEST_UPLOAD_FOLDER", "/backups")
+
+	testCases := []struct {
+		name           string
+		settings       string
+		expectedFolder string
+		expectedErr    string
+	}{
+		{
+			name:           "placeholders are resolved before validation",
+			settings:       `{"folder": "${TEST_UPLOAD_FOLDER}/daily", "timeout": "2h"}`,
+			expectedFolder: "/backups/daily",
+		},
+		{
+			name:        "unknown env resolves to an empty value",
+			settings:    `{"folder": "${TEST_UNKNOWN_FOLDER}"}`,
+			expectedErr: "folder: cannot be blank",
+		},
+		{
+			name:        "invalid timeout",
+			settings:    `{"folder": "/backups", "timeout": "2 hours"}`,
+			expectedErr: "timeout",
+		},
+		{
+			name:        "invalid json",
+			settings:    `{"folder": 1}`,
+			expectedErr: "cannot parse test uploader settings",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg := &testSettings{}
+			err := ParseUploaderSettings("test", []byte(testCase.settings), cfg, &cfg.Folder)
+			if testCase.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", testCase.expectedErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Folder != testCase.expectedFolder {
+				t.Errorf("expected folder %q, got %q", testCase.expectedFolder, cfg.Folder)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	const fallback = time.Minute
+
+	testCases := []struct {
+		raw      string
+		expected time.Duration
+	}{
+		{raw: "", expected: fallback},
+		{raw: "90s", expected: 90 * time.Second},
+		{raw: "1h30m", expected: 90 * time.Minute},
+		{raw: "later", expected: fallback},
+	}
+
+	for _, testCase := range testCases {
+		if actual := ParseDuration(testCase.raw, fallback); actual != testCase.expected {
+			t.Errorf("%q: expected %v, got %v", testCase.raw, testCase.expected, actual)
+		}
+	}
+}
@@ -2,11 +2,12 @@ package db
 
 import (
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/breathbath/dumper/cli"
 	"github.com/breathbath/go_utils/v3/pkg/errs"
-	"github.com/breathbath/go_utils/v3/pkg/io"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
 	validation "github.com/go-ozzo/ozzo-validation"
 )
 
@@ -34,7 +35,7 @@ func (dc *ConnConfig) Validate() error {
 }
 
 func ImportDumpFromFileToDB(dbConn *ConnConfig, filePath string) (err error) {
-	io.OutputInfo("", "Will import '%s' to db '%s'", dbConn.DBName, filePath)
+	io2.OutputInfo("", "Will import '%s' to db '%s'", dbConn.DBName, filePath)
 
 	cmd := fmt.Sprintf(
 		`set -o pipefail && cat %s | mysql -u${DB_USER} -p${DB_PASS} -P${DB_PORT} -h${DB_HOST} ${DB_NAME}`,
@@ -57,7 +58,7 @@ func ImportDumpFromFileToDB(dbConn *ConnConfig, filePath string) (err error) {
 }
 
 func ExecMysql(dbConn *ConnConfig, sql string, useDBName bool) (err error) {
-	io.OutputInfo("", "Will execute '%s' to db '%s'", sql, dbConn.DBName)
+	io2.OutputInfo("", "Will execute '%s' to db '%s'", sql, dbConn.DBName)
 
 	dbName := "${DB_NAME}"
 	if !useDBName {
@@ -90,7 +91,7 @@ func SanitizeTargetDB(dbConn *ConnConfig, scriptsToRun []string) error {
 		return nil
 	}
 
-	io.OutputInfo("", "Will sanitize db '%s'", dbConn.DBName)
+	io2.OutputInfo("", "Will sanitize db '%s'", dbConn.DBName)
 
 	ers := errs.NewErrorContainer()
 	for _, q := range scriptsToRun {
@@ -119,6 +120,15 @@ func SanitizeTargetDB(dbConn *ConnConfig, scriptsToRun []string) error {
 }
 
 func ExecMysqlDump(cfg *ConnConfig, pipeOutput, mysqldumpVersion string, dump *Dump) error {
+	return ExecMysqlDumpTo(cfg, pipeOutput, mysqldumpVersion, dump, cli.NewStdSuccessWriter())
+}
+
+// ExecMysqlDumpTo runs mysqldump and writes its output, which is not redirected by pipeOutput, to w
+func ExecMysqlDumpTo(cfg *ConnConfig, pipeOutput, mysqldumpVersion string, dump *Dump, w io.Writer) error {
+	if dump == nil {
+		dump = &Dump{}
+	}
+
 	envs := []string{
 		"MUSER=" + cfg.User,
 		"MPORT=" + cfg.Port,
@@ -167,7 +177,7 @@ func ExecMysqlDump(cfg *ConnConfig, pipeOutput, mysqldumpVersion string, dump *D
 	)
 
 	cmdExec := cli.CmdExec{
-		SuccessWriter: cli.NewStdSuccessWriter(),
+		SuccessWriter: w,
 		ErrorWriter:   cli.NewStdErrorWriter(),
 		Envs:          envs,
 	}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/breathbath/dumper/cli"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const (
+	// PgFormatCustom is the compressed pg_dump archive which is restored with pg_restore
+	PgFormatCustom = "custom"
+	// PgFormatPlain is an sql script which is restored with psql
+	PgFormatPlain = "plain"
+)
+
+// PgDump selects objects for pg_dump, schemas and tables are patterns in pg_dump format e.g. public or audit_*
+type PgDump struct {
+	// Name is added to the file name to distinguish several dumps of the same db
+	Name             string   `json:"name,omitempty"`
+	Schemas          []string `json:"schemas,omitempty"`
+	ExcludeSchemas   []string `json:"excludeSchemas,omitempty"`
+	Tables           []string `json:"tables,omitempty"`
+	ExcludeTables    []string `json:"excludeTables,omitempty"`
+	ExcludeTableData []string `json:"excludeTableData,omitempty"`
+	// Flags are extra pg_dump args, each of them is quoted and passed as a single arg e.g. --no-owner
+	Flags []string `json:"flags,omitempty"`
+}
+
+func (pd *PgDump) Validate() error {
+	return validation.ValidateStruct(pd,
+		validation.Field(&pd.Name, validation.By(func(value interface{}) error {
+			if strings.ContainsAny(fmt.Sprint(value), `/\`) {
+				return fmt.Errorf("should not contain slashes")
+			}
+
+			return nil
+		})),
+	)
+}
+
+// PgEnvs passes connection settings to postgres tools, so the password never appears in logged commands
+func PgEnvs(cfg *ConnConfig) []string {
+	envs := []string{
+		"PGUSER=" + cfg.User,
+		"PGPASSWORD=" + cfg.Password,
+		"PGDATABASE=" + cfg.DBName,
+	}
+	if cfg.Host != "" {
+		envs = append(envs, "PGHOST="+cfg.Host)
+	}
+	if cfg.Port != "" {
+		envs = append(envs, "PGPORT="+cfg.Port)
+	}
+
+	return envs
+}
+
+// ExecPgDumpTo runs pg_dump and writes its output, which is not redirected by pipeOutput, to w
+func ExecPgDumpTo(cfg *ConnConfig, pgDumpBin, format string, dump *PgDump, pipeOutput string, w io.Writer) error {
+	if dump == nil {
+		dump = &PgDump{}
+	}
+
+	args := []string{"--no-password", "--format=" + format}
+	for _, schema := range dump.Schemas {
+		args = append(args, "--schema="+shellQuote(schema))
+	}
+	for _, schema := range dump.ExcludeSchemas {
+		args = append(args, "--exclude-schema="+shellQuote(schema))
+	}
+	for _, table := range dump.Tables {
+		args = append(args, "--table="+shellQuote(table))
+	}
+	for _, table := range dump.ExcludeTables {
+		args = append(args, "--exclude-table="+shellQuote(table))
+	}
+	for _, table := range dump.ExcludeTableData {
+		args = append(args, "--exclude-table-data="+shellQuote(table))
+	}
+	args = append(args, shellQuoteAll(dump.Flags)...)
+
+	cmd := fmt.Sprintf(`set -o pipefail && %s %s %s`, pgDumpBin, strings.Join(args, " "), pipeOutput)
+
+	cmdExec := cli.CmdExec{
+		SuccessWriter: w,
+		ErrorWriter:   cli.NewStdErrorWriter(),
+		Envs:          PgEnvs(cfg),
+	}
+
+	return cmdExec.Execute(cmd)
+}
+
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// shellQuoteAll quotes each arg, so every value is passed as a single argument
+func shellQuoteAll(args []string) []string {
+	res := make([]string, 0, len(args))
+	for _, arg := range args {
+		res = append(res, shellQuote(arg))
+	}
+
+	return res
+}
+
+// PgRestore configures restoring of postgres dumps
+type PgRestore struct {
+	// Jobs is the number of parallel pg_restore jobs, plain dumps are always restored by a single psql process
+	Jobs int `json:"jobs,omitempty"`
+	// Clean drops db objects before recreating them, missing objects are ignored
+	Clean bool `json:"clean,omitempty"`
+	// NoOwner skips restoring of object owners, so objects belong to the user of the connection or to Role
+	NoOwner      bool   `json:"noOwner,omitempty"`
+	NoPrivileges bool   `json:"noPrivileges,omitempty"`
+	Role         string `json:"role,omitempty"`
+	// Flags are extra pg_restore or psql args, each of them is quoted and passed as a single arg
+	Flags []string `json:"flags,omitempty"`
+}
+
+func (pr *PgRestore) Validate() error {
+	return validation.ValidateStruct(pr,
+		validation.Field(&pr.Jobs, validation.Min(0)),
+	)
+}
+
+// ExecPgRestore restores a custom format dump with pg_restore
+func ExecPgRestore(cfg *ConnConfig, pgRestoreBin string, restore *PgRestore, filePath string) error {
+	args := []string{"--no-password", `--dbname="${PGDATABASE}"`, "--exit-on-error"}
+	if restore.Jobs > 1 {
+		args = append(args, fmt.Sprintf("--jobs=%d", restore.Jobs))
+	}
+	if restore.Clean {
+		args = append(args, "--clean", "--if-exists")
+	}
+	args = append(args, restore.commonArgs()...)
+	args = append(args, shellQuote(filePath))
+
+	cmdExec := cli.CmdExec{
+		SuccessWriter: cli.NewStdSuccessWriter(),
+		ErrorWriter:   cli.NewStdErrorWriter(),
+		Envs:          PgEnvs(cfg),
+	}
+
+	return cmdExec.Execute("%s %s", pgRestoreBin, strings.Join(args, " "))
+}
+
+// ExecPsqlFile runs a plain dump with psql in a single transaction, so a failed import changes nothing
+func ExecPsqlFile(cfg *ConnConfig, psqlBin string, restore *PgRestore, filePath string) error {
+	args := []string{"--no-password", "--set=ON_ERROR_STOP=1", "--single-transaction", "--quiet"}
+	if restore.Role != "" {
+		args = append(args, "--command="+shellQuote("SET ROLE "+quoteIdent(restore.Role)))
+	}
+	args = append(args, shellQuoteAll(restore.Flags)...)
+	args = append(args, "--file="+shellQuote(filePath))
+
+	cmdExec := cli.CmdExec{
+		SuccessWriter: cli.NewStdSuccessWriter(),
+		ErrorWriter:   cli.NewStdErrorWriter(),
+		Envs:          PgEnvs(cfg),
+	}
+
+	return cmdExec.Execute("%s %s", psqlBin, strings.Join(args, " "))
+}
+
+func (pr *PgRestore) commonArgs() []string {
+	args := []string{}
+	if pr.NoOwner {
+		args = append(args, "--no-owner")
+	}
+	if pr.NoPrivileges {
+		args = append(args, "--no-privileges")
+	}
+	if pr.Role != "" {
+		args = append(args, "--role="+shellQuote(pr.Role))
+	}
+
+	return append(args, shellQuoteAll(pr.Flags)...)
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubPgDump puts a pg_dump on PATH which prints each argument on a separate line followed by the postgres envs
+func stubPgDump(t *testing.T) {
+	t.Helper()
+
+	binDir := t.TempDir()
+	script := `#!/bin/bash
+for arg in "$@"; do echo "arg:$arg"; done
+echo "env:PGUSER=$PGUSER"
+echo "env:PGPASSWORD=$PGPASSWORD"
+echo "env:PGDATABASE=$PGDATABASE"
+echo "env:PGHOST=$PGHOST"
+echo "env:PGPORT=$PGPORT"
+`
+	err := os.WriteFile(filepath.Join(binDir, "pg_dump"), []byte(script), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func parseStubOutput(output string) (args, envs []string) {
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		switch {
+		case strings.HasPrefix(line, "arg:"):
+			args = append(args, strings.TrimPrefix(line, "arg:"))
+		case strings.HasPrefix(line, "env:"):
+			envs = append(envs, strings.TrimPrefix(line, "env:"))
+		}
+	}
+
+	return args, envs
+}
+
+func TestExecPgDumpToArgs(t *testing.T) {
+	const password = "pa$$ 'word"
+
+	cfg := &ConnConfig{User: "backup", Password: password, Host: "db.local", Port: "5433", DBName: "shop"}
+
+	testCases := []struct {
+		name         string
+		format       string
+		dump         *PgDump
+		expectedArgs []string
+	}{
+		{
+			name:         "whole db",
+			format:       PgFormatCustom,
+			dump:         nil,
+			expectedArgs: []string{"--no-password", "--format=custom"},
+		},
+		{
+			name:   "selected objects",
+			format: PgFormatPlain,
+			dump: &PgDump{
+				Schemas:          []string{"public"},
+				ExcludeSchemas:   []string{"audit_*"},
+				Tables:           []string{"public.user's", `public."Order Items"`},
+				ExcludeTables:    []string{"public.tmp_*", "public.$HOME"},
+				ExcludeTableData: []string{"public.sessions"},
+				Flags:            []string{"--no-owner", "--lock-wait-timeout=10s", "$HOME; echo injected"},
+			},
+			expectedArgs: []string{
+				"--no-password",
+				"--format=plain",
+				"--schema=public",
+				"--exclude-schema=audit_*",
+				"--table=public.user's",
+				`--table=public."Order Items"`,
+				"--exclude-table=public.tmp_*",
+				"--exclude-table=public.$HOME",
+				"--exclude-table-data=public.sessions",
+				"--no-owner",
+				"--lock-wait-timeout=10s",
+				"$HOME; echo injected",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			stubPgDump(t)
+			// patterns shouldn't be expanded by the shell even if matching files exist
+			workDir := t.TempDir()
+			for _, name := range []string{"audit_log", "public.tmp_1"} {
+				err := os.WriteFile(filepath.Join(workDir, name), nil, 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			chdir(t, workDir)
+
+			output := &bytes.Buffer{}
+			err := ExecPgDumpTo(cfg, "pg_dump", testCase.format, testCase.dump, "", output)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			args, envs := parseStubOutput(output.String())
+			if !reflect.DeepEqual(args, testCase.expectedArgs) {
+				t.Errorf("expected args\n%q\ngot\n%q", testCase.expectedArgs, args)
+			}
+
+			for _, arg := range args {
+				if strings.Contains(arg, password) {
+					t.Errorf("password is passed as an argument %q", arg)
+				}
+			}
+
+			expectedEnvs := []string{
+				"PGUSER=backup",
+				"PGPASSWORD=" + password,
+				"PGDATABASE=shop",
+				"PGHOST=db.local",
+				"PGPORT=5433",
+			}
+			if !reflect.DeepEqual(envs, expectedEnvs) {
+				t.Errorf("expected envs %q, got %q", expectedEnvs, envs)
+			}
+		})
+	}
+}
+
+func TestExecPgDumpToPipeOutput(t *testing.T) {
+	stubPgDump(t)
+
+	outputPath := filepath.Join(t.TempDir(), "dump.sql.gz")
+	cfg := &ConnConfig{User: "backup", Password: "secret", DBName: "shop"}
+
+	err := ExecPgDumpTo(cfg, "pg_dump", PgFormatPlain, nil, "| gzip -9 > "+outputPath, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		t.Fatalf("expected the redirected output: %v", err)
+	}
+	if info.Size() == 0 {
+		t.Error("expected non empty output")
+	}
+}
+
+func TestExecPgDumpToFailure(t *testing.T) {
+	binDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(binDir, "pg_dump"), []byte("#!/bin/bash\nexit 1\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := &ConnConfig{User: "backup", Password: "secret", DBName: "shop"}
+
+	// pipefail should report the failure of pg_dump even though gzip succeeds
+	err = ExecPgDumpTo(cfg, "pg_dump", PgFormatPlain, nil, "| gzip -9 > "+filepath.Join(t.TempDir(), "dump.sql.gz"), &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+}
+
+// stubRecorder puts a binary on PATH which records its arguments and the postgres password to the returned file
+func stubRecorder(t *testing.T, name string) string {
+	t.Helper()
+
+	binDir := t.TempDir()
+	outputPath := filepath.Join(binDir, name+".out")
+	script := `#!/bin/bash
+for arg in "$@"; do echo "arg:$arg"; done > ` + outputPath + `
+echo "env:PGPASSWORD=$PGPASSWORD" >> ` + outputPath + `
+`
+	err := os.WriteFile(filepath.Join(binDir, name), []byte(script), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return outputPath
+}
+
+func TestExecPgRestoreQuotesFlags(t *testing.T) {
+	cfg := &ConnConfig{User: "restore", Password: "secret", DBName: "shop"}
+	restore := &PgRestore{
+		Jobs:    2,
+		NoOwner: true,
+		Role:    "app owner",
+		Flags:   []string{"--disable-triggers", "$HOME; echo injected"},
+	}
+
+	testCases := []struct {
+		name         string
+		bin          string
+		exec         func() error
+		expectedArgs []string
+	}{
+		{
+			name: "pg_restore",
+			bin:  "pg_restore",
+			exec: func() error {
+				return ExecPgRestore(cfg, "pg_restore", restore, "/dumps/shop.dump")
+			},
+			expectedArgs: []string{
+				"--no-password",
+				"--dbname=shop",
+				"--exit-on-error",
+				"--jobs=2",
+				"--no-owner",
+				"--role=app owner",
+				"--disable-triggers",
+				"$HOME; echo injected",
+				"/dumps/shop.dump",
+			},
+		},
+		{
+			name: "psql",
+			bin:  "psql",
+			exec: func() error {
+				return ExecPsqlFile(cfg, "psql", restore, "/dumps/shop.sql")
+			},
+			expectedArgs: []string{
+				"--no-password",
+				"--set=ON_ERROR_STOP=1",
+				"--single-transaction",
+				"--quiet",
+				`--command=SET ROLE "app owner"`,
+				"--disable-triggers",
+				"$HOME; echo injected",
+				"--file=/dumps/shop.sql",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			outputPath := stubRecorder(t, testCase.bin)
+
+			err := testCase.exec()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			output, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			args, envs := parseStubOutput(string(output))
+			if !reflect.DeepEqual(args, testCase.expectedArgs) {
+				t.Errorf("expected args\n%q\ngot\n%q", testCase.expectedArgs, args)
+			}
+			if !reflect.DeepEqual(envs, []string{"PGPASSWORD=secret"}) {
+				t.Errorf("expected the password in env, got %q", envs)
+			}
+		})
+	}
+}
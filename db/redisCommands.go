@@ -0,0 +1,58 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/breathbath/dumper/cli"
+)
+
+type RedisConnConfig struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	TLS      bool   `json:"tls,omitempty"`
+	// Flags are passed to redis-cli, each of them is quoted and passed as a single arg,
+	// so an option and its value are separate items e.g. ["--cacert", "/certs/ca.crt"]
+	Flags []string `json:"flags,omitempty"`
+}
+
+func PrepareRedisConnConfig(connCfg *RedisConnConfig) {
+	connCfg.Host = cli.GetEnvOrValue(connCfg.Host)
+	connCfg.Port = cli.GetEnvOrValue(connCfg.Port)
+	connCfg.User = cli.GetEnvOrValue(connCfg.User)
+	connCfg.Password = cli.GetEnvOrValue(connCfg.Password)
+}
+
+// ExecRedisRdb fetches an rdb snapshot from the server with redis-cli --rdb, the password is given in
+// REDISCLI_AUTH, so it never appears in logged commands
+func ExecRedisRdb(cfg *RedisConnConfig, redisCliBin, rdbPath string) error {
+	args := []string{"--no-auth-warning"}
+	if cfg.Host != "" {
+		args = append(args, "-h", shellQuote(cfg.Host))
+	}
+	if cfg.Port != "" {
+		args = append(args, "-p", shellQuote(cfg.Port))
+	}
+	if cfg.User != "" {
+		args = append(args, "--user", shellQuote(cfg.User))
+	}
+	if cfg.TLS {
+		args = append(args, "--tls")
+	}
+	args = append(args, shellQuoteAll(cfg.Flags)...)
+	args = append(args, "--rdb", shellQuote(rdbPath))
+
+	envs := []string{}
+	if cfg.Password != "" {
+		envs = append(envs, "REDISCLI_AUTH="+cfg.Password)
+	}
+
+	cmdExec := cli.CmdExec{
+		SuccessWriter: cli.NewStdSuccessWriter(),
+		ErrorWriter:   cli.NewStdErrorWriter(),
+		Envs:          envs,
+	}
+
+	return cmdExec.Execute("%s %s", redisCliBin, strings.Join(args, " "))
+}
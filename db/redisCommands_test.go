@@ -0,0 +1,67 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExecRedisRdbQuotesFlags(t *testing.T) {
+	binDir := t.TempDir()
+	argsPath := filepath.Join(binDir, "args")
+	script := `#!/bin/bash
+for arg in "$@"; do echo "arg:$arg"; done > ` + argsPath + `
+echo "env:REDISCLI_AUTH=$REDISCLI_AUTH" >> ` + argsPath + `
+`
+	err := os.WriteFile(filepath.Join(binDir, "redis-cli"), []byte(script), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	const password = "pa$$ 'word"
+	cfg := &RedisConnConfig{
+		Host:     "cache.local",
+		Port:     "6380",
+		Password: password,
+		TLS:      true,
+		Flags:    []string{"--cacert", "/certs/my ca.crt", "$HOME;id"},
+	}
+
+	err = ExecRedisRdb(cfg, "redis-cli", "/tmp/dump.rdb")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, envs := parseStubOutput(string(output))
+	expectedArgs := []string{
+		"--no-auth-warning",
+		"-h", "cache.local",
+		"-p", "6380",
+		"--tls",
+		"--cacert", "/certs/my ca.crt",
+		"$HOME;id",
+		"--rdb", "/tmp/dump.rdb",
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("expected args\n%q\ngot\n%q", expectedArgs, args)
+	}
+
+	for _, arg := range args {
+		if strings.Contains(arg, password) {
+			t.Errorf("password is passed as an argument %q", arg)
+		}
+	}
+
+	expectedEnvs := []string{"REDISCLI_AUTH=" + password}
+	if !reflect.DeepEqual(envs, expectedEnvs) {
+		t.Errorf("expected envs %q, got %q", expectedEnvs, envs)
+	}
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/breathbath/dumper/cli"
+)
+
+const (
+	// SqliteMethodBackup copies the db with the online backup api
+	SqliteMethodBackup = "backup"
+	// SqliteMethodVacuum copies the db with VACUUM INTO, which also defragments the copy
+	SqliteMethodVacuum = "vacuum"
+
+	// sqliteBusyTimeoutMs is how long sqlite3 waits for locks held by writers of a live db
+	sqliteBusyTimeoutMs = 10000
+)
+
+// ExecSqliteCopy makes a consistent copy of a live db, the source is opened read only, so a missing
+// file is not created by sqlite3
+func ExecSqliteCopy(sqliteBin, method, srcPath, dstPath string) error {
+	var copyCmd string
+	switch method {
+	case SqliteMethodVacuum:
+		copyCmd = fmt.Sprintf("VACUUM INTO '%s'", strings.ReplaceAll(dstPath, "'", "''"))
+	default:
+		// arguments of dot commands in double quotes are unescaped like C strings
+		copyCmd = fmt.Sprintf(`.backup "%s"`, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(dstPath))
+	}
+
+	cmdExec := cli.CmdExec{
+		SuccessWriter: cli.NewStdSuccessWriter(),
+		ErrorWriter:   cli.NewStdErrorWriter(),
+	}
+
+	return cmdExec.Execute(
+		"%s -bail -readonly -cmd %s %s %s",
+		sqliteBin,
+		shellQuote(fmt.Sprintf(".timeout %d", sqliteBusyTimeoutMs)),
+		shellQuote(srcPath),
+		shellQuote(copyCmd),
+	)
+}
+
+// ExecSqliteIntegrityCheck runs PRAGMA integrity_check, which outputs ok for a healthy db or the list of problems
+func ExecSqliteIntegrityCheck(sqliteBin, dbPath string) error {
+	output := &bytes.Buffer{}
+	cmdExec := cli.CmdExec{
+		SuccessWriter: output,
+		ErrorWriter:   cli.NewStdErrorWriter(),
+	}
+
+	err := cmdExec.Execute("%s -bail -readonly %s %s", sqliteBin, shellQuote(dbPath), shellQuote("PRAGMA integrity_check;"))
+	if err != nil {
+		return err
+	}
+
+	res := strings.TrimSpace(output.String())
+	if res != "ok" {
+		return fmt.Errorf("integrity check of %s failed: %s", dbPath, res)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecSqliteCopyEscapesTargetPath(t *testing.T) {
+	sqliteBin, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+
+	srcPath := filepath.Join(t.TempDir(), "app.db")
+	err = exec.Command(sqliteBin, srcPath, "CREATE TABLE t(a); INSERT INTO t VALUES (1);").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{SqliteMethodBackup, SqliteMethodVacuum} {
+		t.Run(method, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), `it's a "quoted" \dir`)
+			err := os.Mkdir(dir, 0700)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dstPath := filepath.Join(dir, `app "copy".db`)
+
+			err = ExecSqliteCopy(sqliteBin, method, srcPath, dstPath)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = ExecSqliteIntegrityCheck(sqliteBin, dstPath)
+			if err != nil {
+				t.Errorf("expected a valid copy at %s: %v", dstPath, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package exec
+
+import (
+	stdio "io"
+	"os"
+	"path/filepath"
+
+	"github.com/breathbath/dumper/cli"
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retention"
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	"github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+// artifact is a single dump or archive produced by a shell command, it's either written to the output folder
+// and uploaded from there or streamed directly to the uploaders
+type artifact struct {
+	FileName       string
+	TmpPath        string
+	OutputPath     string
+	Stream         bool
+	Upload         UploaderCfgs
+	LocalRetention *retention.Policy
+	Vars           pathtpl.Vars
+	// Produce runs a command which writes the artifact to its stdout followed by pipeOutput, which throttles
+	// and redirects it to the temp file, in stream mode the output isn't redirected and should be written to w
+	Produce func(pipeOutput string, w stdio.Writer) error
+}
+
+func (uh UploadHelper) deliverArtifact(a *artifact, registeredUploaders map[string]Uploader, result *JobResult) error {
+	if a.Stream {
+		io.OutputInfo("", "Will stream %s", a.FileName)
+
+		return uh.uploadStream(a.FileName, a.Vars, a.Upload, registeredUploaders, result, func(w stdio.Writer) error {
+			return a.Produce(throttledPipe(), w)
+		})
+	}
+
+	tmpPath := a.TmpPath
+	if tmpPath == "" {
+		tmpPath = os.TempDir()
+	}
+
+	tempFilePath := filepath.Join(tmpPath, a.FileName)
+	outputFilePath := filepath.Join(a.OutputPath, a.FileName)
+
+	io.OutputInfo("", "Will write %s to %s", a.FileName, tempFilePath)
+
+	err := a.Produce(throttledPipe()+" > "+tempFilePath, cli.NewStdSuccessWriter())
+	if err != nil {
+		fs.RmFile(tempFilePath)
+		return err
+	}
+
+	err = os.Rename(tempFilePath, outputFilePath)
+	if err != nil {
+		fs.RmFile(tempFilePath)
+		return err
+	}
+	io.OutputInfo("", "Moved %s to %s", tempFilePath, outputFilePath)
+
+	err = uh.uploadIfNeeded(outputFilePath, a.Vars, a.Upload, registeredUploaders, result)
+	if err != nil {
+		return err
+	}
+
+	applyLocalRetention(outputFilePath, a.LocalRetention)
+
+	return nil
+}
+
+// fileProducer writes a local file as an artifact, optionally compressing it
+func fileProducer(filePath string, isGzipped bool) func(pipeOutput string, w stdio.Writer) error {
+	return func(pipeOutput string, w stdio.Writer) error {
+		readCmd := "cat"
+		if isGzipped {
+			readCmd = "gzip -9 -c"
+		}
+
+		cmdExec := cli.CmdExec{
+			SuccessWriter: w,
+			ErrorWriter:   cli.NewStdErrorWriter(),
+		}
+
+		return cmdExec.Execute(`set -o pipefail && %s %s %s`, readCmd, filePath, pipeOutput)
+	}
+}
@@ -4,5 +4,6 @@ import "github.com/breathbath/dumper/config"
 
 type Executor interface {
 	GetValidConfig(generalConfig *config.Config) (interface{}, error)
-	Execute(generalConfig *config.Config, execConfig interface{}) error
+	// Execute runs the job, stats of the run are collected in the result
+	Execute(generalConfig *config.Config, execConfig interface{}, result *JobResult) error
 }
@@ -0,0 +1,179 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/cli"
+	"github.com/breathbath/dumper/retention"
+	"github.com/breathbath/dumper/volume"
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	"github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+var dumpTimestampRgx = regexp.MustCompile(`^\d{2}\.\d{2}\.\d{4}\.\d{2}\.\d{2}\.\d{2}\.\d{3}`)
+
+// findLatestDump returns the name of the file in the folder with the newest timestamp prefix,
+// an empty name means that no dumps are found
+func findLatestDump(folder string) (string, error) {
+	latestFileName := ""
+	lastFileTimestamp := time.Time{}
+
+	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		timestampStr := dumpTimestampRgx.FindString(info.Name())
+		if timestampStr == "" {
+			return nil
+		}
+
+		fileTime, e := time.Parse(retention.TimestampLayout, timestampStr)
+		if e != nil {
+			io.OutputWarning("", "Cannot parse %q as time str: %v", timestampStr, e)
+			return nil
+		}
+
+		if fileTime.After(lastFileTimestamp) {
+			lastFileTimestamp = fileTime
+			latestFileName = info.Name()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("dump dir read failure %v", err)
+	}
+
+	return latestFileName, nil
+}
+
+// findLatestDumps returns the names of the newest files of each series in the folder sorted by series,
+// the series is the file name without the timestamp, so each db and each named dump of a db is selected separately
+func findLatestDumps(folder string) ([]string, error) {
+	latestFileNames := map[string]string{}
+	latestTimes := map[string]time.Time{}
+
+	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !dumpTimestampRgx.MatchString(info.Name()) {
+			return nil
+		}
+
+		series, createdAt, ok := retention.ParseName(info.Name())
+		if !ok {
+			io.OutputWarning("", "Cannot parse timestamp of %q", info.Name())
+			return nil
+		}
+
+		if createdAt.After(latestTimes[series]) {
+			latestTimes[series] = createdAt
+			latestFileNames[series] = info.Name()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dump dir read failure %v", err)
+	}
+
+	seriesNames := make([]string, 0, len(latestFileNames))
+	for series := range latestFileNames {
+		seriesNames = append(seriesNames, series)
+	}
+	sort.Strings(seriesNames)
+
+	fileNames := make([]string, 0, len(seriesNames))
+	for _, series := range seriesNames {
+		fileNames = append(fileNames, latestFileNames[series])
+	}
+
+	return fileNames, nil
+}
+
+// prepareDumpFile joins volumes of the dump and extracts gzipped dumps to the temp folder,
+// the returned clean func removes the created temp files
+func prepareDumpFile(folder, tempFolderPath, fileName string, isGzipped bool) (filePath string, cl Clean, err error) {
+	if tempFolderPath == "" {
+		tempFolderPath = os.TempDir()
+	}
+
+	tempFiles := []string{}
+	cl = func() {
+		for _, tempFile := range tempFiles {
+			fs.RmFile(tempFile)
+		}
+	}
+
+	filePath = filepath.Join(folder, fileName)
+	if baseName, isVolume := volume.BaseName(fileName); isVolume {
+		filePath, err = joinVolumes(folder, tempFolderPath, baseName)
+		if err != nil {
+			return "", cl, err
+		}
+		tempFiles = append(tempFiles, filePath)
+		fileName = baseName
+	}
+
+	if !isGzipped {
+		return filePath, cl, nil
+	}
+
+	extractedFilePath := filepath.Join(tempFolderPath, strings.TrimSuffix(fileName, GzExt))
+	if extractedFilePath == filePath {
+		extractedFilePath += ".extracted"
+	}
+
+	cmdExec := cli.CmdExec{
+		SuccessWriter: cli.NewStdSuccessWriter(),
+		ErrorWriter:   cli.NewStdErrorWriter(),
+	}
+	tempFiles = append(tempFiles, extractedFilePath)
+	err = cmdExec.Execute(`gzip -d -c %s > %s`, filePath, extractedFilePath)
+	if err != nil {
+		return "", cl, err
+	}
+
+	io.OutputInfo("", "Extracted %s to %s", filePath, extractedFilePath)
+
+	return extractedFilePath, cl, nil
+}
+
+func joinVolumes(folder, tempFolderPath, baseName string) (string, error) {
+	manifestPath := filepath.Join(folder, volume.ManifestName(baseName))
+	if !fs.FileExists(manifestPath) {
+		return "", fmt.Errorf("manifest %s is not found, volumes of %s might be not completely synced yet", manifestPath, baseName)
+	}
+
+	joinedFilePath := filepath.Join(tempFolderPath, baseName)
+	err := volume.Join(manifestPath, joinedFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	return joinedFilePath, nil
+}
+
+// connSelected tells if the connection should be imported, an empty list selects all connections
+func connSelected(connName string, connNamesToImport []string) bool {
+	if len(connNamesToImport) == 0 {
+		return true
+	}
+
+	for _, connNameFromList := range connNamesToImport {
+		if connNameFromList == connName {
+			return true
+		}
+	}
+
+	return false
+}
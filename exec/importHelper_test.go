@@ -0,0 +1,52 @@
+package exec
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindLatestDumps(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"15.03.2022.10.00.00.000_shop_core.dump",
+		"14.03.2022.10.00.00.000_shop_core.dump",
+		"14.03.2022.10.00.00.000_shop_audit.sql.gz.001",
+		"14.03.2022.10.00.00.000_shop_audit.sql.gz.002",
+		"14.03.2022.10.00.00.000_shop_audit.sql.gz.manifest.json",
+		"13.03.2022.10.00.00.000_shop_audit.sql.gz",
+		"notes.txt",
+	}
+	for _, name := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	actual, err := findLatestDumps(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// any volume of a dump can be selected, prepareDumpFile joins them by the base name
+	if len(actual) != 2 {
+		t.Fatalf("expected the newest dump of each series, got %v", actual)
+	}
+	if actual[1] != "15.03.2022.10.00.00.000_shop_core.dump" {
+		t.Errorf("expected the newest core dump, got %q", actual[1])
+	}
+	if !strings.HasPrefix(actual[0], "14.03.2022.10.00.00.000_shop_audit.sql.gz") {
+		t.Errorf("expected the newest audit dump, got %q", actual[0])
+	}
+
+	empty, err := findLatestDumps(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(empty, []string{}) {
+		t.Errorf("expected no dumps, got %v", empty)
+	}
+}
@@ -0,0 +1,201 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	stdio "io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/breathbath/dumper/cli"
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/db"
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retention"
+	"github.com/breathbath/go_utils/v3/pkg/errs"
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const (
+	MongoArchiveExt = ".archive"
+	// mongoAllDBs replaces the db name in file names of dumps without db selection
+	mongoAllDBs = "all"
+)
+
+type MongoConfig struct {
+	SourceDB     *db.MongoConnConfig `json:"sourceDb"`
+	MongodumpBin string              `json:"mongodumpBin,omitempty"`
+	OutputPath   string              `json:"outputPath"`
+	TmpPath      string              `json:"tmpPath"`
+	Dumps        []*db.MongoDump     `json:"dumps,omitempty"`
+	// IsGzipped compresses archives with mongodump --gzip, they're restored with mongorestore --gzip
+	IsGzipped bool         `json:"isGzipped,omitempty"`
+	Upload    UploaderCfgs `json:"upload"`
+	// LocalRetention deletes old dumps of this job from OutputPath
+	LocalRetention *retention.Policy `json:"localRetention,omitempty"`
+	// Stream pipes dumps directly to the uploaders without storing them locally
+	Stream bool `json:"stream,omitempty"`
+}
+
+func (mc *MongoConfig) Validate() error {
+	return validation.ValidateStruct(mc,
+		validation.Field(&mc.SourceDB, validation.Required),
+		validation.Field(&mc.SourceDB),
+		validation.Field(&mc.OutputPath, validation.Required),
+		validation.Field(&mc.Dumps, validation.By(func(value interface{}) error {
+			names := map[string]bool{}
+			for _, dump := range mc.Dumps {
+				if dump == nil {
+					continue
+				}
+				key := dump.DB + "_" + dump.Name
+				if names[key] {
+					return fmt.Errorf("several dumps of db '%s' have the same name '%s'", dump.DB, dump.Name)
+				}
+				names[key] = true
+			}
+
+			return nil
+		})),
+	)
+}
+
+type MongoDumpExecutor struct {
+	Uploaders map[string]Uploader
+	UploadHelper
+}
+
+func (mde MongoDumpExecutor) GetValidConfig(generalConfig *config.Config) (interface{}, error) {
+	mongoConf := new(MongoConfig)
+	err := json.Unmarshal(*generalConfig.Context, mongoConf)
+	if err != nil {
+		return nil, fmt.Errorf("config parsing failed: %v", err)
+	}
+
+	if mongoConf.MongodumpBin == "" {
+		mongoConf.MongodumpBin = "mongodump"
+	}
+
+	_, err = exec.LookPath(mongoConf.MongodumpBin)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mongoConf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = mde.validateConfig(mongoConf.Upload, mde.Uploaders)
+	if err != nil {
+		return nil, err
+	}
+
+	if mongoConf.Stream {
+		err = mde.validateStreamConfig(mongoConf.Upload, mde.Uploaders)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if mongoConf.LocalRetention != nil {
+		err = mongoConf.LocalRetention.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid local retention policy: %v", err)
+		}
+	}
+
+	return mongoConf, nil
+}
+
+// Execute creates a separate archive for each dump
+func (mde MongoDumpExecutor) Execute(generalConfig *config.Config, execConfig interface{}, result *JobResult) error {
+	mongoConfig, ok := execConfig.(*MongoConfig)
+	if !ok {
+		return fmt.Errorf("wrong config format for mongodb dumper")
+	}
+
+	err := mde.prepareConfig(mongoConfig)
+	if err != nil {
+		return err
+	}
+
+	if !mongoConfig.Stream {
+		err = fs.MkDir(mongoConfig.OutputPath)
+		if err != nil {
+			return fmt.Errorf("cannot create directory %s: %v", mongoConfig.OutputPath, err)
+		}
+	}
+
+	dumps := mongoConfig.Dumps
+	if len(dumps) == 0 {
+		dumps = []*db.MongoDump{{}}
+	}
+
+	ers := errs.NewErrorContainer()
+	for _, dump := range dumps {
+		dump := dump
+		a := &artifact{
+			FileName:       mde.generateFileName(mongoConfig, dump),
+			TmpPath:        mongoConfig.TmpPath,
+			OutputPath:     mongoConfig.OutputPath,
+			Stream:         mongoConfig.Stream,
+			Upload:         mongoConfig.Upload,
+			LocalRetention: mongoConfig.LocalRetention,
+			Vars:           pathtpl.Vars{Job: generalConfig.Name, Kind: generalConfig.Kind, DB: mde.dbName(dump)},
+			Produce: func(pipeOutput string, w stdio.Writer) error {
+				return db.ExecMongoDumpTo(mongoConfig.SourceDB, mongoConfig.MongodumpBin, mongoConfig.IsGzipped, dump, pipeOutput, w)
+			},
+		}
+
+		ers.AddError(mde.deliverArtifact(a, mde.Uploaders, result))
+	}
+
+	return ers.Result(" ")
+}
+
+func (mde MongoDumpExecutor) prepareConfig(cfg *MongoConfig) error {
+	db.PrepareMongoConnConfig(cfg.SourceDB)
+
+	cfg.OutputPath = cli.GetEnvOrValue(cfg.OutputPath)
+
+	var err error
+	if !filepath.IsAbs(cfg.OutputPath) {
+		cfg.OutputPath, err = filepath.Abs(cfg.OutputPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.TmpPath == "" {
+		cfg.TmpPath = os.TempDir()
+	}
+
+	return nil
+}
+
+func (mde MongoDumpExecutor) dbName(dump *db.MongoDump) string {
+	if dump.DB == "" {
+		return mongoAllDBs
+	}
+
+	return dump.DB
+}
+
+// generateFileName gives names like 02.01.2006.15.04.05.000_db_name.archive.gz
+func (mde MongoDumpExecutor) generateFileName(cfg *MongoConfig, dump *db.MongoDump) string {
+	fileName := fmt.Sprintf("%s_%s", time.Now().UTC().Format(retention.TimestampLayout), mde.dbName(dump))
+	if dump.Name != "" {
+		fileName += "_" + dump.Name
+	}
+
+	fileName += MongoArchiveExt
+	if cfg.IsGzipped {
+		fileName += GzExt
+	}
+
+	return fileName
+}
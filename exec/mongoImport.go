@@ -0,0 +1,108 @@
+package exec
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/breathbath/dumper/db"
+	"github.com/breathbath/dumper/volume"
+	"github.com/breathbath/go_utils/v3/pkg/errs"
+	"github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// MongoImportConfig restores the latest archive of each series from the folder with mongorestore, a series
+// is the archive name without the timestamp, gzipped archives are passed as is since mongorestore reads them itself
+type MongoImportConfig struct {
+	Conns           map[string]*db.MongoConnConfig `json:"dbConn"`
+	DumpsFolderName string                         `json:"dumpsFolderName"`
+	TempFolderPath  string                         `json:"tempFolderPath,omitempty"`
+	MongorestoreBin string                         `json:"mongorestoreBin,omitempty"`
+	db.MongoRestore
+}
+
+func (mic *MongoImportConfig) Validate() error {
+	const maxConnsCount = 10
+	const minConnsCount = 1
+
+	err := validation.ValidateStruct(mic,
+		validation.Field(&mic.Conns, validation.Length(minConnsCount, maxConnsCount)),
+		validation.Field(&mic.DumpsFolderName, validation.Required),
+	)
+	if err != nil {
+		return err
+	}
+
+	return mic.MongoRestore.Validate()
+}
+
+type MongoImportExecutor struct {
+}
+
+func (mie MongoImportExecutor) Execute(conf *MongoImportConfig, connNamesToImport []string) error {
+	err := conf.Validate()
+	if err != nil {
+		return err
+	}
+
+	if conf.MongorestoreBin == "" {
+		conf.MongorestoreBin = "mongorestore"
+	}
+
+	fileNames, err := findLatestDumps(conf.DumpsFolderName)
+	if err != nil {
+		return err
+	}
+
+	if len(fileNames) == 0 {
+		io.OutputWarning("", "Didn't find any dump file")
+		return nil
+	}
+
+	ers := errs.NewErrorContainer()
+	for _, fileName := range fileNames {
+		err = mie.importDump(conf, connNamesToImport, fileName)
+		if err != nil {
+			ers.AddError(err)
+		}
+	}
+
+	return ers.Result(" ")
+}
+
+func (mie MongoImportExecutor) importDump(conf *MongoImportConfig, connNamesToImport []string, fileName string) error {
+	io.OutputInfo("", "Selected file '%s' to import", filepath.Join(conf.DumpsFolderName, fileName))
+
+	baseName, _ := volume.BaseName(fileName)
+	isGzipped := strings.HasSuffix(baseName, GzExt)
+
+	filePath, cl, err := prepareDumpFile(conf.DumpsFolderName, conf.TempFolderPath, fileName, false)
+	defer cl()
+	if err != nil {
+		return err
+	}
+
+	ers := errs.NewErrorContainer()
+	for connName, connConf := range conf.Conns {
+		if !connSelected(connName, connNamesToImport) {
+			continue
+		}
+		db.PrepareMongoConnConfig(connConf)
+
+		err = connConf.Validate()
+		if err != nil {
+			ers.AddError(fmt.Errorf("invalid conn '%s': %v", connName, err))
+			continue
+		}
+
+		io.OutputInfo("", "Will import '%s' to conn '%s'", filePath, connName)
+
+		err = db.ExecMongoRestore(connConf, conf.MongorestoreBin, &conf.MongoRestore, filePath, isGzipped)
+		if err != nil {
+			ers.AddError(err)
+		}
+	}
+
+	return ers.Result(" ")
+}
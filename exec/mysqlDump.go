@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	stdio "io"
 	"os"
 	"path/filepath"
 	"time"
@@ -11,6 +12,8 @@ import (
 	"github.com/breathbath/dumper/cli"
 	"github.com/breathbath/dumper/config"
 	"github.com/breathbath/dumper/db"
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retention"
 	"github.com/breathbath/go_utils/v3/pkg/env"
 	"github.com/breathbath/go_utils/v3/pkg/errs"
 	"github.com/breathbath/go_utils/v3/pkg/fs"
@@ -34,7 +37,11 @@ type MysqlConfig struct {
 	IsGzipped        bool           `json:"isGzipped,omitempty"`
 	CleanTargetDB    bool           `json:"cleanTargetDb,omitempty"`
 	TmpPath          string         `json:"tmpPath"`
-	Upload           *UploaderCfg   `json:"upload"`
+	Upload           UploaderCfgs   `json:"upload"`
+	// LocalRetention deletes old dumps of this job from OutputPath
+	LocalRetention *retention.Policy `json:"localRetention,omitempty"`
+	// Stream pipes the dump directly to the uploaders without storing it locally
+	Stream bool `json:"stream,omitempty"`
 }
 
 func (mc *MysqlConfig) Validate() error {
@@ -46,6 +53,15 @@ func (mc *MysqlConfig) Validate() error {
 	if mc.TargetDB != nil && mc.TargetDB.DBName != "" {
 		fields = append(fields, validation.Field(&mc.TargetDB))
 	}
+	if mc.Stream {
+		fields = append(fields, validation.Field(&mc.BeforeDump, validation.By(func(value interface{}) error {
+			if len(mc.BeforeDump) > 0 && mc.TargetDB != nil && mc.TargetDB.DBName != "" {
+				return errors.New("stream mode is not supported for sanitized dumps, since they need a local copy")
+			}
+
+			return nil
+		})))
+	}
 
 	return validation.ValidateStruct(mc, fields...)
 }
@@ -72,10 +88,24 @@ func (mde MysqlDumpExecutor) GetValidConfig(generalConfig *config.Config) (inter
 		return nil, err
 	}
 
+	if dbConf.Stream {
+		err = mde.validateStreamConfig(dbConf.Upload, mde.Uploaders)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dbConf.LocalRetention != nil {
+		err = dbConf.LocalRetention.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid local retention policy: %v", err)
+		}
+	}
+
 	return dbConf, nil
 }
 
-func (mde MysqlDumpExecutor) Execute(generalConfig *config.Config, execConfig interface{}) error {
+func (mde MysqlDumpExecutor) Execute(generalConfig *config.Config, execConfig interface{}, result *JobResult) error {
 	var err error
 
 	dbConfig, ok := execConfig.(*MysqlConfig)
@@ -107,31 +137,44 @@ func (mde MysqlDumpExecutor) Execute(generalConfig *config.Config, execConfig in
 
 	if dbConfig.TargetDB != nil && dbConfig.TargetDB.DBName != "" && len(dbConfig.BeforeDump) > 0 {
 		var targetFilePath string
-		targetFilePath, err = mde.dumpPrepared(dbConfig)
+		var dumpedAt time.Time
+		targetFilePath, dumpedAt, err = mde.dumpPrepared(dbConfig)
 		if err != nil {
 			return err
 		}
 
-		err = mde.uploadIfNeeded(targetFilePath, dbConfig.Upload, mde.Uploaders)
+		vars := pathtpl.Vars{Job: generalConfig.Name, Kind: generalConfig.Kind, DB: dbConfig.TargetDB.DBName, Time: dumpedAt}
+		err = mde.uploadIfNeeded(targetFilePath, vars, dbConfig.Upload, mde.Uploaders, result)
 		if err != nil {
 			return err
 		}
 
+		applyLocalRetention(targetFilePath, dbConfig.LocalRetention)
+
 		return nil
 	}
 
 	mde.validateBeforeDumpConfig(dbConfig)
 
-	targetFilePath, err := mde.dumpByConfig(dbConfig, dbConfig.SourceDB)
+	// the same time is used in the file name and in the path template, so they don't differ around midnight
+	now := time.Now().UTC()
+	vars := pathtpl.Vars{Job: generalConfig.Name, Kind: generalConfig.Kind, DB: dbConfig.SourceDB.DBName, Time: now}
+	if dbConfig.Stream {
+		return mde.streamDump(dbConfig, dbConfig.SourceDB, vars, result)
+	}
+
+	targetFilePath, err := mde.dumpByConfig(dbConfig, dbConfig.SourceDB, now)
 	if err != nil {
 		return err
 	}
 
-	err = mde.uploadIfNeeded(targetFilePath, dbConfig.Upload, mde.Uploaders)
+	err = mde.uploadIfNeeded(targetFilePath, vars, dbConfig.Upload, mde.Uploaders, result)
 	if err != nil {
 		return err
 	}
 
+	applyLocalRetention(targetFilePath, dbConfig.LocalRetention)
+
 	return nil
 }
 
@@ -145,37 +188,74 @@ func (mde MysqlDumpExecutor) validateBeforeDumpConfig(dbConfig *MysqlConfig) {
 	}
 }
 
-func (mde MysqlDumpExecutor) dumpPrepared(dbConfig *MysqlConfig) (targetFilePath string, err error) {
-	filePath, err := mde.dumpByConfig(dbConfig, dbConfig.SourceDB)
+// dumpPrepared dumps the sanitized target db and returns the time used in its file name
+func (mde MysqlDumpExecutor) dumpPrepared(dbConfig *MysqlConfig) (targetFilePath string, dumpedAt time.Time, err error) {
+	filePath, err := mde.dumpByConfig(dbConfig, dbConfig.SourceDB, time.Now().UTC())
 	if err != nil {
-		return "", err
+		return "", dumpedAt, err
 	}
 
 	err = db.ImportDumpFromFileToDB(dbConfig.TargetDB, filePath)
 	if err != nil {
-		return "", err
+		return "", dumpedAt, err
 	}
 
 	err = db.SanitizeTargetDB(dbConfig.TargetDB, dbConfig.BeforeDump)
 	if err != nil {
-		return "", err
+		return "", dumpedAt, err
 	}
 
-	targetFilePath, err = mde.dumpByConfig(dbConfig, dbConfig.TargetDB)
+	dumpedAt = time.Now().UTC()
+	targetFilePath, err = mde.dumpByConfig(dbConfig, dbConfig.TargetDB, dumpedAt)
 
 	if err != nil {
-		return targetFilePath, err
+		return targetFilePath, dumpedAt, err
+	}
+
+	return targetFilePath, dumpedAt, nil
+}
+
+// streamDump pipes the output of mysqldump through gzip directly to the uploaders,
+// several dumps are concatenated, gzip members are concatenated as well, which gunzip handles as a single file
+func (mde MysqlDumpExecutor) streamDump(
+	dbConfig *MysqlConfig,
+	dbConn *db.ConnConfig,
+	vars pathtpl.Vars,
+	result *JobResult,
+) error {
+	fileName := fmt.Sprintf("%s_%s.sql", vars.Time.Format(retention.TimestampLayout), dbConn.DBName)
+	pipedOutput := ""
+	if dbConfig.IsGzipped {
+		fileName += GzExt
+		pipedOutput = "| gzip -9"
 	}
 
-	return targetFilePath, nil
+	dumps := dbConfig.Dumps
+	if len(dumps) == 0 {
+		dumps = []*db.Dump{{}}
+	}
+
+	io.OutputInfo("", "Will stream dump of db '%s' as %s", dbConn.DBName, fileName)
+
+	return mde.uploadStream(fileName, vars, dbConfig.Upload, mde.Uploaders, result, func(w stdio.Writer) error {
+		for _, dump := range dumps {
+			err := db.ExecMysqlDumpTo(dbConn, pipedOutput, dbConfig.MysqlDumpVersion, dump, w)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
 
-func (mde MysqlDumpExecutor) dumpByConfig(dbConfig *MysqlConfig, dbConn *db.ConnConfig) (dumpFilePath string, err error) {
+func (mde MysqlDumpExecutor) dumpByConfig(dbConfig *MysqlConfig, dbConn *db.ConnConfig, now time.Time) (dumpFilePath string, err error) {
 	var cl Clean
 	if len(dbConfig.Dumps) > 1 {
 		dumpFilePath, cl, err = mde.exportDumpsToFile(
 			dbConfig,
 			dbConn,
+			now,
 		)
 		if cl != nil {
 			defer cl()
@@ -191,6 +271,7 @@ func (mde MysqlDumpExecutor) dumpByConfig(dbConfig *MysqlConfig, dbConn *db.Conn
 		dbConfig,
 		dump,
 		dbConn,
+		now,
 	)
 
 	return dumpFilePath, err
@@ -255,8 +336,11 @@ func (mde MysqlDumpExecutor) generateDumpFilesPath(prefix, dbName, outputPath st
 	return fs.JoinPath(outputPath, fileName)
 }
 
-func (mde MysqlDumpExecutor) generateFullPaths(tempDirPath, outputDirPath, dbName string) (tempFilePath, outputFilePath string) {
-	prefix := time.Now().UTC().Format("02.01.2006.15.04.05.000")
+func (mde MysqlDumpExecutor) generateFullPaths(
+	tempDirPath, outputDirPath, dbName string,
+	now time.Time,
+) (tempFilePath, outputFilePath string) {
+	prefix := now.Format(retention.TimestampLayout)
 	if tempDirPath == "" {
 		tempDirPath = os.TempDir()
 	}
@@ -267,18 +351,22 @@ func (mde MysqlDumpExecutor) generateFullPaths(tempDirPath, outputDirPath, dbNam
 	return
 }
 
-func (mde MysqlDumpExecutor) exportDumpsToFile(dbConf *MysqlConfig, dbConn *db.ConnConfig) (filePath string, cl Clean, err error) {
+func (mde MysqlDumpExecutor) exportDumpsToFile(
+	dbConf *MysqlConfig,
+	dbConn *db.ConnConfig,
+	now time.Time,
+) (filePath string, cl Clean, err error) {
 	if dbConf.OutputPath == "" {
 		return "", nil, errors.New("output dir path should not be empty")
 	}
 
 	io.OutputInfo("", "Will execute dump scripts for db '%s'", dbConn.DBName)
 
-	tempFilePath, outputFilePath := mde.generateFullPaths(dbConf.TmpPath, dbConf.OutputPath, dbConn.DBName)
+	tempFilePath, outputFilePath := mde.generateFullPaths(dbConf.TmpPath, dbConf.OutputPath, dbConn.DBName, now)
 
 	ers := errs.NewErrorContainer()
 	for _, dump := range dbConf.Dumps {
-		pipedOutput := fmt.Sprintf(">> %s", tempFilePath)
+		pipedOutput := fmt.Sprintf("%s >> %s", throttledPipe(), tempFilePath)
 		err = db.ExecMysqlDump(dbConn, pipedOutput, dbConf.MysqlDumpVersion, dump)
 		ers.AddError(err)
 	}
@@ -304,7 +392,7 @@ func (mde MysqlDumpExecutor) exportDumpsToFile(dbConf *MysqlConfig, dbConn *db.C
 	}
 
 	outputFilePath += GzExt
-	cmd := fmt.Sprintf("cat %s | gzip -9 > %s", tempFilePath, outputFilePath)
+	cmd := fmt.Sprintf("set -o pipefail && cat %s | gzip -9%s > %s", tempFilePath, throttledPipe(), outputFilePath)
 	cmdExec := cli.CmdExec{
 		SuccessWriter: cli.NewStdSuccessWriter(),
 		ErrorWriter:   cli.NewStdErrorWriter(),
@@ -315,20 +403,25 @@ func (mde MysqlDumpExecutor) exportDumpsToFile(dbConf *MysqlConfig, dbConn *db.C
 	return outputFilePath, rmTempFilePath, err
 }
 
-func (mde MysqlDumpExecutor) exportDumpToFile(dbConf *MysqlConfig, dump *db.Dump, dbConn *db.ConnConfig) (filePath string, err error) {
+func (mde MysqlDumpExecutor) exportDumpToFile(
+	dbConf *MysqlConfig,
+	dump *db.Dump,
+	dbConn *db.ConnConfig,
+	now time.Time,
+) (filePath string, err error) {
 	if dbConf.OutputPath == "" {
 		return "", errors.New("output dir path should not be empty")
 	}
 
 	io.OutputInfo("", "Will execute dump scripts for db '%s'", dbConn.DBName)
 
-	tempFilePath, outputFilePath := mde.generateFullPaths(dbConf.TmpPath, dbConf.OutputPath, dbConf.SourceDB.DBName)
+	tempFilePath, outputFilePath := mde.generateFullPaths(dbConf.TmpPath, dbConf.OutputPath, dbConf.SourceDB.DBName, now)
 
-	pipedOutput := ""
+	pipedOutput := fmt.Sprintf("%s > %s", throttledPipe(), tempFilePath)
 	if dbConf.IsGzipped {
 		tempFilePath += GzExt
 		outputFilePath += GzExt
-		pipedOutput = fmt.Sprintf("| gzip -9 > %s", tempFilePath)
+		pipedOutput = fmt.Sprintf("| gzip -9%s > %s", throttledPipe(), tempFilePath)
 	}
 
 	err = db.ExecMysqlDump(dbConn, pipedOutput, dbConf.MysqlDumpVersion, dump)
@@ -0,0 +1,19 @@
+package exec
+
+import (
+	"fmt"
+
+	"github.com/breathbath/dumper/outbox"
+)
+
+// FlushOutbox retries pending uploads of the outbox with the registered uploaders
+func FlushOutbox(ob *outbox.Outbox, registeredUploaders map[string]Uploader) {
+	ob.Flush(func(item *outbox.Item) error {
+		uploader, ok := registeredUploaders[item.Uploader]
+		if !ok {
+			return fmt.Errorf("unknown uploader name %s", item.Uploader)
+		}
+
+		return uploader.Upload(item.Path, item.RemotePath)
+	})
+}
@@ -0,0 +1,219 @@
+package exec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdio "io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/breathbath/dumper/cli"
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/db"
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retention"
+	"github.com/breathbath/go_utils/v3/pkg/errs"
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const (
+	PgCustomExt = ".dump"
+	SQLExt      = ".sql"
+)
+
+type PostgresConfig struct {
+	SourceDB *db.ConnConfig `json:"sourceDb"`
+	// Format is either custom (default) or plain
+	Format     string       `json:"format,omitempty"`
+	PgDumpBin  string       `json:"pgDumpBin,omitempty"`
+	OutputPath string       `json:"outputPath"`
+	TmpPath    string       `json:"tmpPath"`
+	Dumps      []*db.PgDump `json:"dumps,omitempty"`
+	// IsGzipped compresses plain dumps, custom dumps are compressed by pg_dump itself
+	IsGzipped bool         `json:"isGzipped,omitempty"`
+	Upload    UploaderCfgs `json:"upload"`
+	// LocalRetention deletes old dumps of this job from OutputPath
+	LocalRetention *retention.Policy `json:"localRetention,omitempty"`
+	// Stream pipes dumps directly to the uploaders without storing them locally
+	Stream bool `json:"stream,omitempty"`
+}
+
+func (pc *PostgresConfig) Validate() error {
+	return validation.ValidateStruct(pc,
+		validation.Field(&pc.SourceDB, validation.Required),
+		validation.Field(&pc.SourceDB),
+		validation.Field(&pc.OutputPath, validation.Required),
+		validation.Field(&pc.Format, validation.In(db.PgFormatCustom, db.PgFormatPlain)),
+		validation.Field(&pc.IsGzipped, validation.By(func(value interface{}) error {
+			if pc.IsGzipped && pc.Format == db.PgFormatCustom {
+				return errors.New("custom format is already compressed by pg_dump")
+			}
+
+			return nil
+		})),
+		validation.Field(&pc.Dumps, validation.By(func(value interface{}) error {
+			names := map[string]bool{}
+			for _, dump := range pc.Dumps {
+				if dump == nil {
+					continue
+				}
+				if names[dump.Name] {
+					return fmt.Errorf("several dumps have the same name '%s'", dump.Name)
+				}
+				names[dump.Name] = true
+			}
+
+			return nil
+		})),
+	)
+}
+
+type PostgresDumpExecutor struct {
+	Uploaders map[string]Uploader
+	UploadHelper
+}
+
+func (pde PostgresDumpExecutor) GetValidConfig(generalConfig *config.Config) (interface{}, error) {
+	pgConf := new(PostgresConfig)
+	err := json.Unmarshal(*generalConfig.Context, pgConf)
+	if err != nil {
+		return nil, fmt.Errorf("config parsing failed: %v", err)
+	}
+
+	if pgConf.Format == "" {
+		pgConf.Format = db.PgFormatCustom
+	}
+
+	if pgConf.PgDumpBin == "" {
+		pgConf.PgDumpBin = "pg_dump"
+	}
+
+	_, err = exec.LookPath(pgConf.PgDumpBin)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pgConf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = pde.validateConfig(pgConf.Upload, pde.Uploaders)
+	if err != nil {
+		return nil, err
+	}
+
+	if pgConf.Stream {
+		err = pde.validateStreamConfig(pgConf.Upload, pde.Uploaders)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if pgConf.LocalRetention != nil {
+		err = pgConf.LocalRetention.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid local retention policy: %v", err)
+		}
+	}
+
+	return pgConf, nil
+}
+
+// Execute creates a separate file for each dump, since custom format archives can't be concatenated
+func (pde PostgresDumpExecutor) Execute(generalConfig *config.Config, execConfig interface{}, result *JobResult) error {
+	pgConfig, ok := execConfig.(*PostgresConfig)
+	if !ok {
+		return fmt.Errorf("wrong config format for postgres dumper")
+	}
+
+	err := pde.prepareConfig(pgConfig)
+	if err != nil {
+		return err
+	}
+
+	if !pgConfig.Stream {
+		err = fs.MkDir(pgConfig.OutputPath)
+		if err != nil {
+			return fmt.Errorf("cannot create directory %s: %v", pgConfig.OutputPath, err)
+		}
+	}
+
+	dumps := pgConfig.Dumps
+	if len(dumps) == 0 {
+		dumps = []*db.PgDump{{}}
+	}
+
+	vars := pathtpl.Vars{Job: generalConfig.Name, Kind: generalConfig.Kind, DB: pgConfig.SourceDB.DBName}
+	ers := errs.NewErrorContainer()
+	for _, dump := range dumps {
+		dump := dump
+		a := &artifact{
+			FileName:       pde.generateFileName(pgConfig, dump),
+			TmpPath:        pgConfig.TmpPath,
+			OutputPath:     pgConfig.OutputPath,
+			Stream:         pgConfig.Stream,
+			Upload:         pgConfig.Upload,
+			LocalRetention: pgConfig.LocalRetention,
+			Vars:           vars,
+			Produce: func(pipeOutput string, w stdio.Writer) error {
+				return db.ExecPgDumpTo(pgConfig.SourceDB, pgConfig.PgDumpBin, pgConfig.Format, dump, pde.gzipPipe(pgConfig)+pipeOutput, w)
+			},
+		}
+
+		ers.AddError(pde.deliverArtifact(a, pde.Uploaders, result))
+	}
+
+	return ers.Result(" ")
+}
+
+func (pde PostgresDumpExecutor) prepareConfig(cfg *PostgresConfig) error {
+	db.PrepareDBConnConfig(cfg.SourceDB)
+
+	cfg.OutputPath = cli.GetEnvOrValue(cfg.OutputPath)
+
+	var err error
+	if !filepath.IsAbs(cfg.OutputPath) {
+		cfg.OutputPath, err = filepath.Abs(cfg.OutputPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.TmpPath == "" {
+		cfg.TmpPath = os.TempDir()
+	}
+
+	return nil
+}
+
+// generateFileName gives names like 02.01.2006.15.04.05.000_db_name.dump
+func (pde PostgresDumpExecutor) generateFileName(cfg *PostgresConfig, dump *db.PgDump) string {
+	fileName := fmt.Sprintf("%s_%s", time.Now().UTC().Format(retention.TimestampLayout), cfg.SourceDB.DBName)
+	if dump.Name != "" {
+		fileName += "_" + dump.Name
+	}
+
+	if cfg.Format == db.PgFormatCustom {
+		return fileName + PgCustomExt
+	}
+
+	fileName += SQLExt
+	if cfg.IsGzipped {
+		fileName += GzExt
+	}
+
+	return fileName
+}
+
+func (pde PostgresDumpExecutor) gzipPipe(cfg *PostgresConfig) string {
+	if cfg.IsGzipped {
+		return "| gzip -9"
+	}
+
+	return ""
+}
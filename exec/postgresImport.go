@@ -0,0 +1,123 @@
+package exec
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/breathbath/dumper/db"
+	"github.com/breathbath/dumper/volume"
+	"github.com/breathbath/go_utils/v3/pkg/errs"
+	"github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// PgImportConfig restores the latest dump of each series from the folder to every selected conn, a series
+// is the dump name without the timestamp e.g. the named dumps "core" and "audit" of a db are restored both,
+// dumps with the .dump extension are restored with pg_restore, other ones are considered plain sql and run with psql,
+// gzipped plain dumps are extracted first
+type PgImportConfig struct {
+	Conns           map[string]*db.ConnConfig `json:"dbConn"`
+	DumpsFolderName string                    `json:"dumpsFolderName"`
+	TempFolderPath  string                    `json:"tempFolderPath,omitempty"`
+	PgRestoreBin    string                    `json:"pgRestoreBin,omitempty"`
+	PsqlBin         string                    `json:"psqlBin,omitempty"`
+	db.PgRestore
+}
+
+func (pic *PgImportConfig) Validate() error {
+	const maxConnsCount = 10
+	const minConnsCount = 1
+
+	err := validation.ValidateStruct(pic,
+		validation.Field(&pic.Conns, validation.Length(minConnsCount, maxConnsCount)),
+		validation.Field(&pic.DumpsFolderName, validation.Required),
+	)
+	if err != nil {
+		return err
+	}
+
+	return pic.PgRestore.Validate()
+}
+
+type PostgresImportExecutor struct {
+}
+
+func (pie PostgresImportExecutor) Execute(conf *PgImportConfig, connNamesToImport []string) error {
+	err := conf.Validate()
+	if err != nil {
+		return err
+	}
+
+	if conf.PgRestoreBin == "" {
+		conf.PgRestoreBin = "pg_restore"
+	}
+	if conf.PsqlBin == "" {
+		conf.PsqlBin = "psql"
+	}
+
+	fileNames, err := findLatestDumps(conf.DumpsFolderName)
+	if err != nil {
+		return err
+	}
+
+	if len(fileNames) == 0 {
+		io.OutputWarning("", "Didn't find any dump file")
+		return nil
+	}
+
+	ers := errs.NewErrorContainer()
+	for _, fileName := range fileNames {
+		err = pie.importDump(conf, connNamesToImport, fileName)
+		if err != nil {
+			ers.AddError(err)
+		}
+	}
+
+	return ers.Result(" ")
+}
+
+func (pie PostgresImportExecutor) importDump(conf *PgImportConfig, connNamesToImport []string, fileName string) error {
+	io.OutputInfo("", "Selected file '%s' to import", filepath.Join(conf.DumpsFolderName, fileName))
+
+	baseName, _ := volume.BaseName(fileName)
+	isGzipped := strings.HasSuffix(baseName, GzExt)
+	isCustomFormat := strings.HasSuffix(baseName, PgCustomExt)
+
+	if !isCustomFormat && (conf.Clean || conf.NoOwner || conf.NoPrivileges || conf.Jobs > 1) {
+		io.OutputWarning("", "clean, noOwner, noPrivileges and jobs are ignored for plain dumps, they're set by pg_dump flags")
+	}
+
+	filePath, cl, err := prepareDumpFile(conf.DumpsFolderName, conf.TempFolderPath, fileName, isGzipped)
+	defer cl()
+	if err != nil {
+		return err
+	}
+
+	ers := errs.NewErrorContainer()
+	for connName, dbConnConf := range conf.Conns {
+		if !connSelected(connName, connNamesToImport) {
+			continue
+		}
+		db.PrepareDBConnConfig(dbConnConf)
+
+		err = dbConnConf.Validate()
+		if err != nil {
+			ers.AddError(fmt.Errorf("invalid conn '%s': %v", connName, err))
+			continue
+		}
+
+		io.OutputInfo("", "Will import '%s' to db '%s'", filePath, dbConnConf.DBName)
+
+		if isCustomFormat {
+			err = db.ExecPgRestore(dbConnConf, conf.PgRestoreBin, &conf.PgRestore, filePath)
+		} else {
+			err = db.ExecPsqlFile(dbConnConf, conf.PsqlBin, &conf.PgRestore, filePath)
+		}
+		if err != nil {
+			ers.AddError(err)
+		}
+	}
+
+	return ers.Result(" ")
+}
@@ -0,0 +1,177 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/cli"
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/db"
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retention"
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const RdbExt = ".rdb"
+
+type RedisConfig struct {
+	SourceDB    *db.RedisConnConfig `json:"sourceDb"`
+	RedisCliBin string              `json:"redisCliBin,omitempty"`
+	// Name is used in file names instead of the host e.g. sessions
+	Name       string       `json:"name,omitempty"`
+	OutputPath string       `json:"outputPath"`
+	TmpPath    string       `json:"tmpPath"`
+	IsGzipped  bool         `json:"isGzipped,omitempty"`
+	Upload     UploaderCfgs `json:"upload"`
+	// LocalRetention deletes old snapshots of this job from OutputPath
+	LocalRetention *retention.Policy `json:"localRetention,omitempty"`
+	// Stream pipes snapshots directly to the uploaders, they're still fetched to TmpPath first
+	Stream bool `json:"stream,omitempty"`
+}
+
+func (rc *RedisConfig) Validate() error {
+	return validation.ValidateStruct(rc,
+		validation.Field(&rc.SourceDB, validation.Required),
+		validation.Field(&rc.OutputPath, validation.Required),
+		validation.Field(&rc.Name, validation.By(func(value interface{}) error {
+			if strings.ContainsAny(fmt.Sprint(value), `/\`) {
+				return fmt.Errorf("should not contain slashes")
+			}
+
+			return nil
+		})),
+	)
+}
+
+type RedisDumpExecutor struct {
+	Uploaders map[string]Uploader
+	UploadHelper
+}
+
+func (rde RedisDumpExecutor) GetValidConfig(generalConfig *config.Config) (interface{}, error) {
+	redisConf := new(RedisConfig)
+	err := json.Unmarshal(*generalConfig.Context, redisConf)
+	if err != nil {
+		return nil, fmt.Errorf("config parsing failed: %v", err)
+	}
+
+	if redisConf.RedisCliBin == "" {
+		redisConf.RedisCliBin = "redis-cli"
+	}
+
+	_, err = exec.LookPath(redisConf.RedisCliBin)
+	if err != nil {
+		return nil, err
+	}
+
+	err = redisConf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = rde.validateConfig(redisConf.Upload, rde.Uploaders)
+	if err != nil {
+		return nil, err
+	}
+
+	if redisConf.Stream {
+		err = rde.validateStreamConfig(redisConf.Upload, rde.Uploaders)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if redisConf.LocalRetention != nil {
+		err = redisConf.LocalRetention.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid local retention policy: %v", err)
+		}
+	}
+
+	return redisConf, nil
+}
+
+// Execute fetches an rdb snapshot with redis-cli --rdb, which makes the server run BGSAVE and send the result
+func (rde RedisDumpExecutor) Execute(generalConfig *config.Config, execConfig interface{}, result *JobResult) error {
+	redisConfig, ok := execConfig.(*RedisConfig)
+	if !ok {
+		return fmt.Errorf("wrong config format for redis dumper")
+	}
+
+	err := rde.prepareConfig(redisConfig)
+	if err != nil {
+		return err
+	}
+
+	if !redisConfig.Stream {
+		err = fs.MkDir(redisConfig.OutputPath)
+		if err != nil {
+			return fmt.Errorf("cannot create directory %s: %v", redisConfig.OutputPath, err)
+		}
+	}
+
+	fileName := rde.generateFileName(redisConfig)
+	snapshotPath := filepath.Join(redisConfig.TmpPath, fileName+".snapshot")
+	defer fs.RmFile(snapshotPath)
+
+	err = db.ExecRedisRdb(redisConfig.SourceDB, redisConfig.RedisCliBin, snapshotPath)
+	if err != nil {
+		return err
+	}
+
+	a := &artifact{
+		FileName:       fileName,
+		TmpPath:        redisConfig.TmpPath,
+		OutputPath:     redisConfig.OutputPath,
+		Stream:         redisConfig.Stream,
+		Upload:         redisConfig.Upload,
+		LocalRetention: redisConfig.LocalRetention,
+		Vars:           pathtpl.Vars{Job: generalConfig.Name, Kind: generalConfig.Kind, DB: redisConfig.Name},
+		Produce:        fileProducer(snapshotPath, redisConfig.IsGzipped),
+	}
+
+	return rde.deliverArtifact(a, rde.Uploaders, result)
+}
+
+func (rde RedisDumpExecutor) prepareConfig(cfg *RedisConfig) error {
+	db.PrepareRedisConnConfig(cfg.SourceDB)
+
+	if cfg.Name == "" {
+		cfg.Name = cfg.SourceDB.Host
+	}
+	if cfg.Name == "" {
+		cfg.Name = "localhost"
+	}
+
+	cfg.OutputPath = cli.GetEnvOrValue(cfg.OutputPath)
+
+	var err error
+	if !filepath.IsAbs(cfg.OutputPath) {
+		cfg.OutputPath, err = filepath.Abs(cfg.OutputPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.TmpPath == "" {
+		cfg.TmpPath = os.TempDir()
+	}
+
+	return nil
+}
+
+// generateFileName gives names like 02.01.2006.15.04.05.000_name.rdb.gz
+func (rde RedisDumpExecutor) generateFileName(cfg *RedisConfig) string {
+	fileName := fmt.Sprintf("%s_%s%s", time.Now().UTC().Format(retention.TimestampLayout), cfg.Name, RdbExt)
+	if cfg.IsGzipped {
+		fileName += GzExt
+	}
+
+	return fileName
+}
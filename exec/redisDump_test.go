@@ -0,0 +1,148 @@
+package exec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/db"
+)
+
+const testRdb = "REDIS0009 snapshot"
+
+// writeRedisCliStub creates a redis-cli which writes a fake snapshot to the --rdb path and records its args
+// and REDISCLI_AUTH, if failAfterWrite is set it fails after writing a part of the snapshot
+func writeRedisCliStub(t *testing.T, dir string, failAfterWrite bool) string {
+	t.Helper()
+
+	exitCode := 0
+	if failAfterWrite {
+		exitCode = 1
+	}
+
+	script := `#!/bin/bash
+echo "$@" > "` + dir + `/args"
+echo "$REDISCLI_AUTH" > "` + dir + `/auth"
+while [ "$#" -gt 0 ]; do
+  if [ "$1" = "--rdb" ]; then
+    printf '%s' "` + testRdb + `" > "$2"
+  fi
+  shift
+done
+exit ` + strconv.Itoa(exitCode) + `
+`
+	stubPath := filepath.Join(dir, "redis-cli")
+	err := os.WriteFile(stubPath, []byte(script), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stubPath
+}
+
+func readTestFile(t *testing.T, filePath string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+func TestRedisDumpExecute(t *testing.T) {
+	testCases := []struct {
+		name             string
+		isGzipped        bool
+		failAfterWrite   bool
+		expectedFileName *regexp.Regexp
+		expectedErr      bool
+	}{
+		{
+			name:             "gzipped snapshot",
+			isGzipped:        true,
+			expectedFileName: regexp.MustCompile(`^\d{2}\.\d{2}\.\d{4}\.\d{2}\.\d{2}\.\d{2}\.\d{3}_sessions\.rdb\.gz$`),
+		},
+		{
+			name:             "plain snapshot",
+			expectedFileName: regexp.MustCompile(`^\d{2}\.\d{2}\.\d{4}\.\d{2}\.\d{2}\.\d{2}\.\d{3}_sessions\.rdb$`),
+		},
+		{
+			name:           "failed redis-cli",
+			isGzipped:      true,
+			failAfterWrite: true,
+			expectedErr:    true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			binPath := t.TempDir()
+			tmpPath := t.TempDir()
+			outputPath := filepath.Join(t.TempDir(), "redis")
+
+			redisConfig := &RedisConfig{
+				SourceDB:    &db.RedisConnConfig{Host: "localhost", Port: "6379", Password: "secret"},
+				RedisCliBin: writeRedisCliStub(t, binPath, testCase.failAfterWrite),
+				Name:        "sessions",
+				OutputPath:  outputPath,
+				TmpPath:     tmpPath,
+				IsGzipped:   testCase.isGzipped,
+			}
+
+			err := RedisDumpExecutor{}.Execute(&config.Config{Name: "cache", Kind: "redis"}, redisConfig, NewJobResult("cache"))
+			if (err != nil) != testCase.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if args := readTestFile(t, filepath.Join(binPath, "args")); strings.Contains(args, "secret") {
+				t.Errorf("expected the password not to be passed in args, got %s", args)
+			}
+			if auth := readTestFile(t, filepath.Join(binPath, "auth")); auth != "secret" {
+				t.Errorf("expected the password in REDISCLI_AUTH, got %q", auth)
+			}
+
+			if tmpEntries, _ := os.ReadDir(tmpPath); len(tmpEntries) != 0 {
+				t.Errorf("expected the snapshot and temp files to be removed, got %d files in %s", len(tmpEntries), tmpPath)
+			}
+
+			outputEntries, _ := os.ReadDir(outputPath)
+			if testCase.expectedErr {
+				if len(outputEntries) != 0 {
+					t.Errorf("expected no dumps, got %d files", len(outputEntries))
+				}
+				return
+			}
+
+			if len(outputEntries) != 1 || !testCase.expectedFileName.MatchString(outputEntries[0].Name()) {
+				t.Fatalf("expected a single dump matching %s, got %v", testCase.expectedFileName, outputEntries)
+			}
+
+			data, err := os.ReadFile(filepath.Join(outputPath, outputEntries[0].Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if testCase.isGzipped {
+				gz, err := gzip.NewReader(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("expected gzipped output: %v", err)
+				}
+				data, err = io.ReadAll(gz)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(data) != testRdb {
+				t.Errorf("expected the snapshot %q, got %q", testRdb, string(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package exec
+
+import (
+	"time"
+
+	"github.com/breathbath/dumper/progress"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+// UploadResult describes an upload of a single file to a single destination
+type UploadResult struct {
+	File     string
+	Uploader string
+	Bytes    int64
+	// Duration includes all retries
+	Duration time.Duration
+	Err      error
+}
+
+// Throughput returns bytes per second
+func (ur *UploadResult) Throughput() float64 {
+	if ur.Duration <= 0 {
+		return 0
+	}
+
+	return float64(ur.Bytes) / ur.Duration.Seconds()
+}
+
+// JobResult collects stats of a single job run, it's filled by executors and logged by the router
+type JobResult struct {
+	Job      string
+	Started  time.Time
+	Duration time.Duration
+	Err      error
+	Uploads  []*UploadResult
+}
+
+func NewJobResult(job string) *JobResult {
+	return &JobResult{
+		Job:     job,
+		Started: time.Now(),
+		Uploads: []*UploadResult{},
+	}
+}
+
+// addUpload ignores nil results, so executors can be used without collecting stats
+func (jr *JobResult) addUpload(ur *UploadResult) {
+	if jr == nil {
+		return
+	}
+
+	jr.Uploads = append(jr.Uploads, ur)
+}
+
+// Finish records the duration and the error of the run
+func (jr *JobResult) Finish(err error) {
+	jr.Duration = time.Since(jr.Started)
+	jr.Err = err
+}
+
+// Log outputs the job summary with the throughput of every upload
+func (jr *JobResult) Log() {
+	status := "succeeded"
+	if jr.Err != nil {
+		status = "failed"
+	}
+	io2.OutputInfo("", "job '%s' %s in %s, %d uploads", jr.Job, status, jr.Duration.Round(time.Second), len(jr.Uploads))
+
+	for _, ur := range jr.Uploads {
+		if ur.Err != nil {
+			io2.OutputWarning("", "upload of %s to %s failed after %s: %v", ur.File, ur.Uploader, ur.Duration.Round(time.Second), ur.Err)
+			continue
+		}
+
+		io2.OutputInfo(
+			"",
+			"upload of %s to %s: %s in %s, %s/s",
+			ur.File,
+			ur.Uploader,
+			progress.FormatBytes(ur.Bytes),
+			ur.Duration.Round(time.Second),
+			progress.FormatBytes(int64(ur.Throughput())),
+		)
+	}
+}
@@ -0,0 +1,226 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retention"
+	"github.com/breathbath/go_utils/v3/pkg/errs"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+// uploadedFile is a successful upload to a destination
+type uploadedFile struct {
+	remotePath string
+	cfg        *UploaderCfg
+}
+
+// applyRetentionAfterUpload expires old artifacts of all destinations the file was uploaded to, nothing is deleted
+// if an upload to a required destination failed, so the new artifact is stored everywhere before old ones go away
+func (uh UploadHelper) applyRetentionAfterUpload(
+	uploaded []uploadedFile,
+	vars pathtpl.Vars,
+	registeredUploaders map[string]Uploader,
+	requiredFailed bool,
+) {
+	for _, file := range uploaded {
+		if requiredFailed {
+			if file.cfg.Retention != nil {
+				io2.OutputWarning("", "retention for %s is skipped since not all required uploads succeeded", file.cfg.Name)
+			}
+			continue
+		}
+
+		uh.applyRetentionIfNeeded(file.remotePath, vars, file.cfg, registeredUploaders[file.cfg.Name])
+	}
+}
+
+func (uh UploadHelper) applyRetentionIfNeeded(remotePath string, vars pathtpl.Vars, cfg *UploaderCfg, uploader Uploader) {
+	if cfg.Retention == nil {
+		return
+	}
+
+	// date folders of the path template change over time, so all files under its static part are considered
+	prefix := pathtpl.StaticPrefix(cfg.Path, vars)
+	err := uh.applyRemoteRetention(remotePath, prefix, cfg.Retention, uploader.(RemoteStorage))
+	if err != nil {
+		io2.OutputError(err, "", "retention for %s failed", cfg.Name)
+	}
+}
+
+// applyRemoteRetention deletes remote artifacts under the prefix of the same series as the uploaded file
+// which are expired by the policy
+func (uh UploadHelper) applyRemoteRetention(remotePath, prefix string, policy *retention.Policy, storage RemoteStorage) error {
+	series, _, ok := retention.ParseName(remotePath)
+	if !ok {
+		io2.OutputWarning("", "retention is skipped for %s since its name has no timestamp", remotePath)
+		return nil
+	}
+
+	remoteFiles, err := storage.List(prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list remote files: %v", err)
+	}
+
+	files := make([]retention.File, 0, len(remoteFiles))
+	for _, remoteFile := range remoteFiles {
+		files = append(files, retention.File{Name: remoteFile.Name, Size: remoteFile.Size})
+	}
+
+	artifacts := retention.Group(files, series)
+	expired := policy.Expired(artifacts, time.Now().UTC())
+
+	io2.OutputInfo("", "retention of %s: %d remote artifacts, %d expired", series, len(artifacts), len(expired))
+
+	for _, artifact := range expired {
+		for _, name := range artifact.Files {
+			if policy.DryRun {
+				io2.OutputInfo("", "dry run: would delete remote file %s", name)
+				continue
+			}
+
+			err = storage.Delete(name)
+			if err != nil {
+				return fmt.Errorf("failed to delete expired remote file %s: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyLocalRetention deletes files in the folder of the new file which belong to the same series and are expired by the policy,
+// it runs after a successful upload, so old files are kept while the new one isn't stored remotely, the folder
+// should belong to a single job, see ValidateRetentionScopes
+func applyLocalRetention(newFilePath string, policy *retention.Policy) {
+	if policy == nil {
+		return
+	}
+
+	outputPath := filepath.Dir(newFilePath)
+	series, _, ok := retention.ParseName(filepath.Base(newFilePath))
+	if !ok {
+		io2.OutputWarning("", "local retention is skipped for %s since its name has no timestamp", newFilePath)
+		return
+	}
+
+	entries, err := os.ReadDir(outputPath)
+	if err != nil {
+		io2.OutputError(err, "", "local retention failed, cannot read %s", outputPath)
+		return
+	}
+
+	files := make([]retention.File, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, retention.File{Name: entry.Name(), Size: info.Size()})
+	}
+
+	artifacts := retention.Group(files, series)
+	expired := policy.Expired(artifacts, time.Now().UTC())
+
+	io2.OutputInfo("", "local retention of %s in %s: %d artifacts, %d expired", series, outputPath, len(artifacts), len(expired))
+
+	for _, artifact := range expired {
+		for _, name := range artifact.Files {
+			filePath := filepath.Join(outputPath, name)
+			if policy.DryRun {
+				io2.OutputInfo("", "dry run: would delete %s", filePath)
+				continue
+			}
+
+			err = os.Remove(filePath)
+			if err != nil {
+				io2.OutputError(err, "", "failed to delete expired file %s", filePath)
+				continue
+			}
+			io2.OutputInfo("", "deleted expired file %s", filePath)
+		}
+	}
+}
+
+// retentionScope is the context of a dump job, which defines where retention looks for artifacts of the job
+type retentionScope struct {
+	OutputPath     string            `json:"outputPath"`
+	LocalRetention *retention.Policy `json:"localRetention"`
+	Upload         UploaderCfgs      `json:"upload"`
+}
+
+// ValidateRetentionScopes refuses jobs with retention, which share their output folder or a remote folder
+// with another job, since series of artifacts are matched by file names only, e.g. {timestamp}_{dbname},
+// so retention of one job could expire artifacts of another one
+func ValidateRetentionScopes(jobs []*config.Config) error {
+	owners := map[string][]string{}
+	withRetention := map[string]bool{}
+
+	addScope := func(scope, jobName string, hasRetention bool) {
+		if jobNames := owners[scope]; len(jobNames) == 0 || jobNames[len(jobNames)-1] != jobName {
+			owners[scope] = append(jobNames, jobName)
+		}
+		withRetention[scope] = withRetention[scope] || hasRetention
+	}
+
+	for _, job := range jobs {
+		if job.Context == nil {
+			continue
+		}
+
+		// invalid contexts are reported when the job runs
+		scope := new(retentionScope)
+		if err := json.Unmarshal(*job.Context, scope); err != nil {
+			continue
+		}
+
+		if scope.OutputPath != "" {
+			outputPath, err := filepath.Abs(scope.OutputPath)
+			if err != nil {
+				return err
+			}
+			addScope("output folder "+outputPath, job.Name, scope.LocalRetention != nil)
+		}
+
+		// {db} is kept as is, since db names are not known before the job runs
+		vars := pathtpl.Vars{Job: job.Name, Kind: job.Kind, DB: "{db}"}
+		for _, cfg := range scope.Upload {
+			if cfg == nil || cfg.Name == "" {
+				continue
+			}
+			prefix := pathtpl.StaticPrefix(cfg.Path, vars)
+			addScope(fmt.Sprintf("folder %q of uploader %s", prefix, cfg.Name), job.Name, cfg.Retention != nil)
+		}
+	}
+
+	scopes := make([]string, 0, len(owners))
+	for scope := range owners {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	ers := errs.NewErrorContainer()
+	for _, scope := range scopes {
+		if !withRetention[scope] || len(owners[scope]) < 2 {
+			continue
+		}
+		ers.AddError(fmt.Errorf(
+			"jobs %s share %s, which has retention, each of them should use its own folder",
+			strings.Join(owners[scope], ", "),
+			scope,
+		))
+	}
+
+	return ers.Result(" ")
+}
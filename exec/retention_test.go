@@ -0,0 +1,252 @@
+package exec
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/remote"
+	"github.com/breathbath/dumper/retention"
+)
+
+func timestampedName(age time.Duration, suffix string) string {
+	return time.Now().UTC().Add(-age).Format(retention.TimestampLayout) + suffix
+}
+
+func TestApplyLocalRetention(t *testing.T) {
+	newest := timestampedName(0, "_db.sql.gz")
+	older := timestampedName(time.Hour, "_db.sql.gz")
+	oldest := timestampedName(2*time.Hour, "_db.sql.gz")
+	oldestVolumes := []string{
+		timestampedName(3*time.Hour, "_db.sql.gz.001"),
+		timestampedName(3*time.Hour, "_db.sql.gz.manifest.json"),
+	}
+	otherSeries := timestampedName(4*time.Hour, "_other.sql.gz")
+	foreign := "notes.txt"
+
+	allFiles := append([]string{newest, older, oldest, otherSeries, foreign}, oldestVolumes...)
+
+	testCases := []struct {
+		name              string
+		policy            *retention.Policy
+		expectedRemaining []string
+	}{
+		{
+			name:              "no policy",
+			policy:            nil,
+			expectedRemaining: allFiles,
+		},
+		{
+			name:              "keep last deletes files and volumes of the same series only",
+			policy:            &retention.Policy{KeepLast: 2},
+			expectedRemaining: []string{newest, older, otherSeries, foreign},
+		},
+		{
+			name:              "dry run deletes nothing",
+			policy:            &retention.Policy{KeepLast: 1, DryRun: true},
+			expectedRemaining: allFiles,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range allFiles {
+				err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			applyLocalRetention(filepath.Join(dir, newest), testCase.policy)
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			remaining := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				remaining = append(remaining, entry.Name())
+			}
+
+			expected := append([]string{}, testCase.expectedRemaining...)
+			sort.Strings(expected)
+			sort.Strings(remaining)
+			if !reflect.DeepEqual(remaining, expected) {
+				t.Errorf("expected remaining files %v, got %v", expected, remaining)
+			}
+		})
+	}
+}
+
+// memoryStorage is a RemoteStorage which keeps file sizes in memory
+type memoryStorage struct {
+	files     map[string]int64
+	deleted   []string
+	uploadErr error
+}
+
+func (ms *memoryStorage) Upload(localPath, remotePath string) error {
+	if ms.uploadErr != nil {
+		return ms.uploadErr
+	}
+	ms.files[remotePath] = 1
+
+	return nil
+}
+
+func (ms *memoryStorage) List(prefix string) ([]*remote.File, error) {
+	res := []*remote.File{}
+	for name, size := range ms.files {
+		if strings.HasPrefix(name, prefix) {
+			res = append(res, &remote.File{Name: name, Path: name, Size: size})
+		}
+	}
+
+	return res, nil
+}
+
+func (ms *memoryStorage) Stat(name string) (*remote.File, error) {
+	size, ok := ms.files[name]
+	if !ok {
+		return nil, remote.ErrNotFound
+	}
+
+	return &remote.File{Name: name, Path: name, Size: size}, nil
+}
+
+func (ms *memoryStorage) Download(name string, w io.Writer) error {
+	return nil
+}
+
+func (ms *memoryStorage) Delete(name string) error {
+	delete(ms.files, name)
+	ms.deleted = append(ms.deleted, name)
+
+	return nil
+}
+
+func TestApplyRemoteRetention(t *testing.T) {
+	newest := "job/2022/03/" + timestampedName(0, "_db.sql.gz")
+	older := "job/2022/02/" + timestampedName(48*time.Hour, "_db.sql.gz")
+	oldest := "job/2022/01/" + timestampedName(96*time.Hour, "_db.sql.gz")
+	otherJob := "other/2022/01/" + timestampedName(96*time.Hour, "_db.sql.gz")
+	withoutTimestamp := "job/2022/01/db.sql.gz"
+
+	testCases := []struct {
+		name            string
+		remotePath      string
+		policy          *retention.Policy
+		expectedDeleted []string
+	}{
+		{
+			name:            "expired files under the prefix are deleted",
+			remotePath:      newest,
+			policy:          &retention.Policy{MaxAge: 72 * time.Hour},
+			expectedDeleted: []string{oldest},
+		},
+		{
+			name:            "dry run",
+			remotePath:      newest,
+			policy:          &retention.Policy{KeepLast: 1, DryRun: true},
+			expectedDeleted: nil,
+		},
+		{
+			name:            "uploaded file without timestamp",
+			remotePath:      withoutTimestamp,
+			policy:          &retention.Policy{KeepLast: 1},
+			expectedDeleted: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			storage := &memoryStorage{files: map[string]int64{
+				newest:           1,
+				older:            1,
+				oldest:           1,
+				otherJob:         1,
+				withoutTimestamp: 1,
+			}}
+
+			err := UploadHelper{}.applyRemoteRetention(testCase.remotePath, "job/", testCase.policy, storage)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			sort.Strings(storage.deleted)
+			if !reflect.DeepEqual(storage.deleted, testCase.expectedDeleted) {
+				t.Errorf("expected deleted files %v, got %v", testCase.expectedDeleted, storage.deleted)
+			}
+		})
+	}
+}
+
+func TestValidateRetentionScopes(t *testing.T) {
+	newJob := func(name, context string) *config.Config {
+		raw := json.RawMessage(context)
+		return &config.Config{Name: name, Kind: "mysql", Context: &raw}
+	}
+
+	testCases := []struct {
+		name        string
+		jobs        []*config.Config
+		expectedErr string
+	}{
+		{
+			name: "separate folders",
+			jobs: []*config.Config{
+				newJob("a", `{"outputPath": "/dumps/a", "localRetention": {"keepLast": 1},
+					"upload": {"name": "s3", "path": "{job}/{yyyy}", "retention": {"keepLast": 1}}}`),
+				newJob("b", `{"outputPath": "/dumps/b", "localRetention": {"keepLast": 1},
+					"upload": {"name": "s3", "path": "{job}/{yyyy}", "retention": {"keepLast": 1}}}`),
+			},
+		},
+		{
+			name: "shared folders without retention",
+			jobs: []*config.Config{
+				newJob("a", `{"outputPath": "/dumps", "upload": {"name": "s3"}}`),
+				newJob("b", `{"outputPath": "/dumps/", "upload": {"name": "s3"}}`),
+			},
+		},
+		{
+			name: "shared output folder",
+			jobs: []*config.Config{
+				newJob("a", `{"outputPath": "/dumps", "localRetention": {"keepLast": 1}}`),
+				newJob("b", `{"outputPath": "/dumps/"}`),
+			},
+			expectedErr: "jobs a, b share output folder /dumps, which has retention",
+		},
+		{
+			name: "shared remote folder",
+			jobs: []*config.Config{
+				newJob("a", `{"upload": {"name": "s3", "path": "dumps/{yyyy}"}}`),
+				newJob("b", `{"upload": [{"name": "s3", "path": "dumps/{mm}", "retention": {"keepLast": 1}}]}`),
+			},
+			expectedErr: `jobs a, b share folder "dumps/" of uploader s3, which has retention`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := ValidateRetentionScopes(testCase.jobs)
+			if testCase.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), testCase.expectedErr) {
+				t.Fatalf("expected error containing %q, got %v", testCase.expectedErr, err)
+			}
+		})
+	}
+}
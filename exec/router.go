@@ -4,12 +4,16 @@ import (
 	"fmt"
 
 	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/outbox"
 	"github.com/breathbath/go_utils/v3/pkg/io"
 )
 
 type Router struct {
 	Executors     map[string]Executor
 	GeneralConfig *config.Config
+	// Outbox is flushed with Uploaders before each run
+	Outbox    *outbox.Outbox
+	Uploaders map[string]Uploader
 }
 
 func (r Router) RunErr() error {
@@ -18,17 +22,19 @@ func (r Router) RunErr() error {
 		return fmt.Errorf("no executor registered for '%s'", r.GeneralConfig.Kind)
 	}
 
+	FlushOutbox(r.Outbox, r.Uploaders)
+
 	execConfig, err := e.GetValidConfig(r.GeneralConfig)
 	if err != nil {
 		return err
 	}
 
-	err = e.Execute(r.GeneralConfig, execConfig)
-	if err != nil {
-		return err
-	}
+	result := NewJobResult(r.GeneralConfig.Name)
+	err = e.Execute(r.GeneralConfig, execConfig, result)
+	result.Finish(err)
+	result.Log()
 
-	return nil
+	return err
 }
 
 func (r Router) Run() {
@@ -0,0 +1,222 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/cli"
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/db"
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retention"
+	"github.com/breathbath/go_utils/v3/pkg/errs"
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	"github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+type SqliteConfig struct {
+	// Paths are db files, each one is copied to a separate file named after it
+	Paths     []string `json:"paths"`
+	SqliteBin string   `json:"sqliteBin,omitempty"`
+	// Method is either backup (default) or vacuum
+	Method string `json:"method,omitempty"`
+	// IntegrityCheck runs PRAGMA integrity_check on the copy, a broken copy is not uploaded
+	IntegrityCheck bool         `json:"integrityCheck,omitempty"`
+	OutputPath     string       `json:"outputPath"`
+	TmpPath        string       `json:"tmpPath"`
+	IsGzipped      bool         `json:"isGzipped,omitempty"`
+	Upload         UploaderCfgs `json:"upload"`
+	// LocalRetention deletes old copies of this job from OutputPath
+	LocalRetention *retention.Policy `json:"localRetention,omitempty"`
+	// Stream pipes copies directly to the uploaders, they're still made in TmpPath first
+	Stream bool `json:"stream,omitempty"`
+}
+
+func (sc *SqliteConfig) Validate() error {
+	return validation.ValidateStruct(sc,
+		validation.Field(&sc.Paths, validation.Required, validation.Length(1, -1), validation.By(func(value interface{}) error {
+			names := map[string]bool{}
+			for _, p := range sc.Paths {
+				// paths are resolved the same way when the job runs, see prepareConfig
+				resolvedPath := cli.GetEnvOrValue(p)
+				if resolvedPath == "" {
+					return fmt.Errorf("path '%s' is empty", p)
+				}
+
+				name := filepath.Base(resolvedPath)
+				if names[name] {
+					return fmt.Errorf("several paths have the same file name '%s'", name)
+				}
+				names[name] = true
+			}
+
+			return nil
+		})),
+		validation.Field(&sc.Method, validation.In(db.SqliteMethodBackup, db.SqliteMethodVacuum)),
+		validation.Field(&sc.OutputPath, validation.Required),
+	)
+}
+
+type SqliteExecutor struct {
+	Uploaders map[string]Uploader
+	UploadHelper
+}
+
+func (se SqliteExecutor) GetValidConfig(generalConfig *config.Config) (interface{}, error) {
+	sqliteConf := new(SqliteConfig)
+	err := json.Unmarshal(*generalConfig.Context, sqliteConf)
+	if err != nil {
+		return nil, fmt.Errorf("config parsing failed: %v", err)
+	}
+
+	if sqliteConf.Method == "" {
+		sqliteConf.Method = db.SqliteMethodBackup
+	}
+
+	if sqliteConf.SqliteBin == "" {
+		sqliteConf.SqliteBin = "sqlite3"
+	}
+
+	_, err = exec.LookPath(sqliteConf.SqliteBin)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sqliteConf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = se.validateConfig(sqliteConf.Upload, se.Uploaders)
+	if err != nil {
+		return nil, err
+	}
+
+	if sqliteConf.Stream {
+		err = se.validateStreamConfig(sqliteConf.Upload, se.Uploaders)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if sqliteConf.LocalRetention != nil {
+		err = sqliteConf.LocalRetention.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid local retention policy: %v", err)
+		}
+	}
+
+	return sqliteConf, nil
+}
+
+// Execute copies each db with sqlite3 instead of reading the file directly, since a file which is written
+// during reading gives a torn copy
+func (se SqliteExecutor) Execute(generalConfig *config.Config, execConfig interface{}, result *JobResult) error {
+	sqliteConfig, ok := execConfig.(*SqliteConfig)
+	if !ok {
+		return fmt.Errorf("wrong config format for sqlite dumper")
+	}
+
+	err := se.prepareConfig(sqliteConfig)
+	if err != nil {
+		return err
+	}
+
+	if !sqliteConfig.Stream {
+		err = fs.MkDir(sqliteConfig.OutputPath)
+		if err != nil {
+			return fmt.Errorf("cannot create directory %s: %v", sqliteConfig.OutputPath, err)
+		}
+	}
+
+	ers := errs.NewErrorContainer()
+	for _, dbPath := range sqliteConfig.Paths {
+		ers.AddError(se.backup(generalConfig, sqliteConfig, dbPath, result))
+	}
+
+	return ers.Result(" ")
+}
+
+func (se SqliteExecutor) backup(generalConfig *config.Config, cfg *SqliteConfig, dbPath string, result *JobResult) error {
+	if !fs.FileExists(dbPath) {
+		return fmt.Errorf("sqlite db %s is not found", dbPath)
+	}
+
+	fileName := se.generateFileName(cfg, dbPath)
+	copyPath := filepath.Join(cfg.TmpPath, fileName+".snapshot")
+	// VACUUM INTO fails if the target exists
+	fs.RmFile(copyPath)
+	defer func() {
+		// a backup of a db in wal mode is in wal mode too, so reading it leaves the wal and shm files
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			fs.RmFile(copyPath + suffix)
+		}
+	}()
+
+	io.OutputInfo("", "Will copy sqlite db %s to %s with %s", dbPath, copyPath, cfg.Method)
+
+	err := db.ExecSqliteCopy(cfg.SqliteBin, cfg.Method, dbPath, copyPath)
+	if err != nil {
+		return err
+	}
+
+	if cfg.IntegrityCheck {
+		err = db.ExecSqliteIntegrityCheck(cfg.SqliteBin, copyPath)
+		if err != nil {
+			return err
+		}
+		io.OutputInfo("", "Integrity check of the copy of %s passed", dbPath)
+	}
+
+	dbName := strings.TrimSuffix(filepath.Base(dbPath), filepath.Ext(dbPath))
+	a := &artifact{
+		FileName:       fileName,
+		TmpPath:        cfg.TmpPath,
+		OutputPath:     cfg.OutputPath,
+		Stream:         cfg.Stream,
+		Upload:         cfg.Upload,
+		LocalRetention: cfg.LocalRetention,
+		Vars:           pathtpl.Vars{Job: generalConfig.Name, Kind: generalConfig.Kind, DB: dbName},
+		Produce:        fileProducer(copyPath, cfg.IsGzipped),
+	}
+
+	return se.deliverArtifact(a, se.Uploaders, result)
+}
+
+func (se SqliteExecutor) prepareConfig(cfg *SqliteConfig) error {
+	for i, p := range cfg.Paths {
+		cfg.Paths[i] = cli.GetEnvOrValue(p)
+	}
+
+	cfg.OutputPath = cli.GetEnvOrValue(cfg.OutputPath)
+
+	var err error
+	if !filepath.IsAbs(cfg.OutputPath) {
+		cfg.OutputPath, err = filepath.Abs(cfg.OutputPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.TmpPath == "" {
+		cfg.TmpPath = os.TempDir()
+	}
+
+	return nil
+}
+
+// generateFileName gives names like 02.01.2006.15.04.05.000_app.db.gz
+func (se SqliteExecutor) generateFileName(cfg *SqliteConfig, dbPath string) string {
+	fileName := fmt.Sprintf("%s_%s", time.Now().UTC().Format(retention.TimestampLayout), filepath.Base(dbPath))
+	if cfg.IsGzipped {
+		fileName += GzExt
+	}
+
+	return fileName
+}
@@ -0,0 +1,225 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/db"
+)
+
+func lookupSqlite(t *testing.T) string {
+	t.Helper()
+
+	sqliteBin, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+
+	return sqliteBin
+}
+
+func runSqlite(t *testing.T, sqliteBin, dbPath string, args ...string) string {
+	t.Helper()
+
+	output, err := exec.Command(sqliteBin, append([]string{dbPath}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 failed: %v, %s", err, output)
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+// startSqliteWriter keeps the db open with committed rows in the wal, which aren't checkpointed to the db file,
+// and with a transaction in progress, the writer stops when the test ends
+func startSqliteWriter(t *testing.T, sqliteBin, dbPath string) {
+	t.Helper()
+
+	cmd := exec.Command(sqliteBin, dbPath)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stdin.Close()
+		_ = cmd.Wait()
+	})
+
+	_, err = io.WriteString(stdin, "PRAGMA wal_autocheckpoint=0;\n"+
+		"INSERT INTO notes VALUES ('in wal');\n"+
+		"BEGIN; INSERT INTO notes VALUES ('uncommitted');\n"+
+		".print ready\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pragma prints its value first
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if scanner.Text() == "ready" {
+			return
+		}
+	}
+	t.Fatalf("sqlite3 writer didn't start: %v", scanner.Err())
+}
+
+func readGzippedFile(t *testing.T, filePath string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected gzipped output: %v", err)
+	}
+	data, err = io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestSqliteExecuteCopiesLiveDB(t *testing.T) {
+	sqliteBin := lookupSqlite(t)
+
+	for _, method := range []string{db.SqliteMethodBackup, db.SqliteMethodVacuum} {
+		t.Run(method, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "notes.db")
+			runSqlite(t, sqliteBin, dbPath, "PRAGMA journal_mode=WAL; CREATE TABLE notes(text); INSERT INTO notes VALUES ('in db');")
+			startSqliteWriter(t, sqliteBin, dbPath)
+
+			if info, err := os.Stat(dbPath + "-wal"); err != nil || info.Size() == 0 {
+				t.Fatalf("expected committed rows in the wal: %v", err)
+			}
+
+			tmpPath := t.TempDir()
+			outputPath := t.TempDir()
+			sqliteConfig := &SqliteConfig{
+				Paths:          []string{dbPath},
+				SqliteBin:      sqliteBin,
+				Method:         method,
+				IntegrityCheck: true,
+				OutputPath:     outputPath,
+				TmpPath:        tmpPath,
+				IsGzipped:      true,
+			}
+
+			err := SqliteExecutor{}.Execute(&config.Config{Name: "notes", Kind: "sqlite"}, sqliteConfig, NewJobResult("notes"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tmpEntries, _ := os.ReadDir(tmpPath); len(tmpEntries) != 0 {
+				t.Errorf("expected the copy to be removed from %s, got %v", tmpPath, tmpEntries)
+			}
+
+			outputEntries, _ := os.ReadDir(outputPath)
+			if len(outputEntries) != 1 || !strings.HasSuffix(outputEntries[0].Name(), "_notes.db.gz") {
+				t.Fatalf("expected a single copy of notes.db, got %v", outputEntries)
+			}
+
+			copyPath := filepath.Join(t.TempDir(), "notes.db")
+			err = os.WriteFile(copyPath, readGzippedFile(t, filepath.Join(outputPath, outputEntries[0].Name())), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rows := runSqlite(t, sqliteBin, copyPath, "SELECT group_concat(text, ',') FROM notes;")
+			if rows != "in db,in wal" {
+				t.Errorf("expected committed rows only, got %q", rows)
+			}
+		})
+	}
+}
+
+func TestSqliteExecuteDoesNotUploadCorruptedCopy(t *testing.T) {
+	sqliteBin := lookupSqlite(t)
+
+	// the index doesn't match its definition anymore, the backup api copies pages as is, so the copy is broken too
+	dbPath := filepath.Join(t.TempDir(), "notes.db")
+	runSqlite(
+		t,
+		sqliteBin,
+		dbPath,
+		".dbconfig defensive off",
+		"CREATE TABLE notes(title, text); CREATE INDEX notes_title ON notes(title); INSERT INTO notes VALUES ('a', 'b');"+
+			"PRAGMA writable_schema=ON; UPDATE sqlite_master SET sql='CREATE INDEX notes_title ON notes(text)' WHERE name='notes_title';",
+	)
+
+	outputPath := t.TempDir()
+	storage := &memoryStorage{files: map[string]int64{}}
+	sqliteConfig := &SqliteConfig{
+		Paths:          []string{dbPath},
+		SqliteBin:      sqliteBin,
+		Method:         db.SqliteMethodBackup,
+		IntegrityCheck: true,
+		OutputPath:     outputPath,
+		TmpPath:        t.TempDir(),
+		Upload:         UploaderCfgs{{Name: "primary"}},
+	}
+
+	executor := SqliteExecutor{Uploaders: map[string]Uploader{"primary": storage}}
+	err := executor.Execute(&config.Config{Name: "notes", Kind: "sqlite"}, sqliteConfig, NewJobResult("notes"))
+	if err == nil || !strings.Contains(err.Error(), "integrity check of") {
+		t.Fatalf("expected the integrity check to fail, got %v", err)
+	}
+
+	if len(storage.files) != 0 {
+		t.Errorf("expected nothing to be uploaded, got %v", storage.files)
+	}
+	if outputEntries, _ := os.ReadDir(outputPath); len(outputEntries) != 0 {
+		t.Errorf("expected no copies in %s, got %d files", outputPath, len(outputEntries))
+	}
+}
+
+func TestSqliteConfigValidateResolvesPaths(t *testing.T) {
+	t.Setenv("AUTH_DB", "/data/auth/app.db")
+	t.Setenv("NOTES_DB", "/data/notes/app.db")
+	t.Setenv("EMPTY_DB", "")
+
+	testCases := []struct {
+		name        string
+		paths       []string
+		expectedErr string
+	}{
+		{name: "different file names", paths: []string{"${AUTH_DB}", "/data/notes/notes.db"}},
+		{
+			name:        "same file names after resolving",
+			paths:       []string{"${AUTH_DB}", "${NOTES_DB}"},
+			expectedErr: "several paths have the same file name 'app.db'",
+		},
+		{name: "empty path after resolving", paths: []string{"${EMPTY_DB}"}, expectedErr: "path '${EMPTY_DB}' is empty"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sqliteConfig := &SqliteConfig{Paths: testCase.paths, OutputPath: "/dumps"}
+			err := sqliteConfig.Validate()
+			if testCase.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if testCase.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), testCase.expectedErr)) {
+				t.Fatalf("expected error containing %q, got %v", testCase.expectedErr, err)
+			}
+		})
+	}
+}
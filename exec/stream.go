@@ -0,0 +1,240 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/volume"
+	"github.com/breathbath/go_utils/v3/pkg/errs"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+// StreamUploader can upload data of unknown size without staging it on the local disk
+type StreamUploader interface {
+	UploadStream(name string, r io.Reader) error
+}
+
+func (uh UploadHelper) validateStreamConfig(cfgs UploaderCfgs, registeredUploaders map[string]Uploader) error {
+	hasDestinations := false
+	for _, cfg := range cfgs {
+		if cfg == nil || cfg.Name == "" {
+			continue
+		}
+		hasDestinations = true
+
+		if _, ok := registeredUploaders[cfg.Name].(StreamUploader); !ok {
+			return fmt.Errorf("uploader %s doesn't support stream mode", cfg.Name)
+		}
+
+		if cfg.Retry != nil && cfg.Retry.Attempts > 1 {
+			return fmt.Errorf("retry of uploader %s is not supported in stream mode since a stream can't be read twice", cfg.Name)
+		}
+
+		if cfg.AbortOnFailure {
+			return fmt.Errorf(
+				"abort_on_failure of uploader %s is not supported in stream mode since all destinations are uploaded at once",
+				cfg.Name,
+			)
+		}
+	}
+
+	if !hasDestinations {
+		return errors.New("stream mode requires at least one upload destination")
+	}
+
+	return nil
+}
+
+// streamWriter copies data to the pipes of all uploaders, a failed uploader is skipped,
+// so other destinations still get the data
+type streamWriter struct {
+	pipes   []*io.PipeWriter
+	failed  []bool
+	names   []string
+	written []int64
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	written := false
+	for i, pipe := range sw.pipes {
+		if sw.failed[i] {
+			continue
+		}
+
+		n, err := pipe.Write(p)
+		sw.written[i] += int64(n)
+		if err != nil {
+			io2.OutputWarning("", "stopped streaming to %s: %v", sw.names[i], err)
+			sw.failed[i] = true
+			continue
+		}
+		written = true
+	}
+
+	if !written {
+		return 0, errors.New("all stream uploads failed")
+	}
+
+	return len(p), nil
+}
+
+// uploadStream runs produce, which writes the artifact to the given writer, and uploads its output
+// to all destinations at once, the artifact is never stored locally
+func (uh UploadHelper) uploadStream(
+	name string,
+	vars pathtpl.Vars,
+	cfgs UploaderCfgs,
+	registeredUploaders map[string]Uploader,
+	result *JobResult,
+	produce func(w io.Writer) error,
+) error {
+	err := uh.validateStreamConfig(cfgs, registeredUploaders)
+	if err != nil {
+		return err
+	}
+
+	activeCfgs := make([]*UploaderCfg, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg != nil && cfg.Name != "" {
+			activeCfgs = append(activeCfgs, cfg)
+		}
+	}
+
+	sw := &streamWriter{
+		pipes:   make([]*io.PipeWriter, len(activeCfgs)),
+		failed:  make([]bool, len(activeCfgs)),
+		names:   make([]string, len(activeCfgs)),
+		written: make([]int64, len(activeCfgs)),
+	}
+	uploadErrs := make([]error, len(activeCfgs))
+	remotePaths := make([]string, len(activeCfgs))
+
+	wg := sync.WaitGroup{}
+	for i, cfg := range activeCfgs {
+		pr, pw := io.Pipe()
+		sw.pipes[i] = pw
+		sw.names[i] = cfg.Name
+		remotePaths[i] = cfg.remotePath(name, vars)
+
+		uploader := registeredUploaders[cfg.Name].(StreamUploader)
+		wg.Add(1)
+		go func(i int, pr *io.PipeReader) {
+			defer wg.Done()
+			uploadErrs[i] = uploader.UploadStream(remotePaths[i], pr)
+			// unblocks the writer if the uploader stopped reading
+			if uploadErrs[i] != nil {
+				pr.CloseWithError(uploadErrs[i])
+			} else {
+				pr.Close()
+			}
+		}(i, pr)
+	}
+
+	io2.OutputInfo("", "Will stream %s to %d destinations", name, len(activeCfgs))
+
+	started := time.Now()
+	produceErr := produce(sw)
+	for _, pw := range sw.pipes {
+		if produceErr != nil {
+			pw.CloseWithError(produceErr)
+		} else {
+			pw.Close()
+		}
+	}
+	wg.Wait()
+	duration := time.Since(started)
+
+	ers := errs.NewErrorContainer()
+	if produceErr != nil {
+		ers.AddError(fmt.Errorf("failed to produce %s: %v", name, produceErr))
+	}
+
+	uploaded := make([]uploadedFile, 0, len(activeCfgs))
+	requiredFailed := produceErr != nil
+
+	for i, cfg := range activeCfgs {
+		uploadErr := uploadErrs[i]
+		if uploadErr == nil && sw.failed[i] {
+			uploadErr = errors.New("uploader stopped reading the stream")
+		}
+
+		result.addUpload(&UploadResult{
+			File:     remotePaths[i],
+			Uploader: cfg.Name,
+			Bytes:    sw.written[i],
+			Duration: duration,
+			Err:      firstErr(uploadErr, produceErr),
+		})
+
+		if produceErr != nil {
+			// the uploader might have committed the incomplete stream or some volumes of it
+			ers.AddError(uh.deleteIncomplete(remotePaths[i], cfg.Name, registeredUploaders[cfg.Name]))
+			if uploadErr == nil {
+				// it's already reported as the produce error
+				continue
+			}
+		}
+
+		if uploadErr == nil {
+			uploaded = append(uploaded, uploadedFile{remotePath: remotePaths[i], cfg: cfg})
+			continue
+		}
+
+		if cfg.BestEffort {
+			io2.OutputWarning("", "best effort stream upload of %s to %s failed: %v", name, cfg.Name, uploadErr)
+			continue
+		}
+		requiredFailed = true
+		ers.AddError(fmt.Errorf("stream upload of %s to %s failed: %v", name, cfg.Name, uploadErr))
+		if uh.Outbox != nil {
+			io2.OutputWarning("", "stream upload of %s to %s can't be retried later since streams aren't stored locally", name, cfg.Name)
+		}
+	}
+
+	uh.applyRetentionAfterUpload(uploaded, vars, registeredUploaders, requiredFailed)
+
+	return ers.Result(" ")
+}
+
+// deleteIncomplete removes an artifact which an uploader committed although the stream was incomplete,
+// so a truncated file isn't taken for a valid backup, its volumes and manifest are removed as well
+func (uh UploadHelper) deleteIncomplete(remotePath, uploaderName string, uploader Uploader) error {
+	storage, ok := uploader.(RemoteStorage)
+	if !ok {
+		io2.OutputWarning("", "incomplete %s can't be deleted from %s since it doesn't support deletion", remotePath, uploaderName)
+		return nil
+	}
+
+	files, err := storage.List(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to list incomplete %s in %s: %v", remotePath, uploaderName, err)
+	}
+
+	for _, file := range files {
+		if baseName, _ := volume.BaseName(file.Name); baseName != remotePath {
+			continue
+		}
+
+		err = storage.Delete(file.Name)
+		if err != nil {
+			return fmt.Errorf("failed to delete incomplete %s from %s: %v", file.Name, uploaderName, err)
+		}
+		io2.OutputInfo("", "deleted incomplete %s from %s", file.Name, uploaderName)
+	}
+
+	return nil
+}
+
+func firstErr(candidates ...error) error {
+	for _, err := range candidates {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package exec
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retention"
+	"github.com/breathbath/dumper/retry"
+)
+
+// memoryStreamStorage is a memoryStorage which commits whatever it reads from a stream, even if the stream is interrupted
+type memoryStreamStorage struct {
+	*memoryStorage
+}
+
+func (ms memoryStreamStorage) UploadStream(name string, r io.Reader) error {
+	data, _ := io.ReadAll(r)
+	ms.files[name] = int64(len(data))
+
+	return nil
+}
+
+func TestUploadStreamDeletesIncompleteArtifacts(t *testing.T) {
+	name := timestampedName(0, "_db.sql.gz")
+	oldFile := timestampedName(48*time.Hour, "_db.sql.gz")
+
+	testCases := []struct {
+		name            string
+		produceErr      error
+		expectedErr     string
+		expectedFiles   []string
+		expectedDeleted []string
+	}{
+		{
+			name:            "complete stream",
+			expectedFiles:   []string{name},
+			expectedDeleted: []string{oldFile},
+		},
+		{
+			name:            "incomplete stream",
+			produceErr:      errors.New("dump failed"),
+			expectedErr:     "failed to produce " + name + ": dump failed",
+			expectedFiles:   []string{oldFile},
+			expectedDeleted: []string{name},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			storage := memoryStreamStorage{&memoryStorage{files: map[string]int64{oldFile: 1}}}
+			registeredUploaders := map[string]Uploader{"primary": storage}
+			cfgs := UploaderCfgs{{Name: "primary", Retention: &retention.Policy{KeepLast: 1}}}
+
+			err := UploadHelper{}.uploadStream(name, pathtpl.Vars{}, cfgs, registeredUploaders, NewJobResult("job"), func(w io.Writer) error {
+				_, err := w.Write([]byte("partial dump"))
+				if err != nil {
+					return err
+				}
+
+				return testCase.produceErr
+			})
+			if testCase.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if testCase.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), testCase.expectedErr)) {
+				t.Fatalf("expected error containing %q, got %v", testCase.expectedErr, err)
+			}
+
+			files := make([]string, 0, len(storage.files))
+			for file := range storage.files {
+				files = append(files, file)
+			}
+			if !reflect.DeepEqual(files, testCase.expectedFiles) {
+				t.Errorf("expected remote files %v, got %v", testCase.expectedFiles, files)
+			}
+			if !reflect.DeepEqual(storage.deleted, testCase.expectedDeleted) {
+				t.Errorf("expected deleted files %v, got %v", testCase.expectedDeleted, storage.deleted)
+			}
+		})
+	}
+}
+
+func TestValidateStreamConfig(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         *UploaderCfg
+		expectedErr string
+	}{
+		{
+			name: "single attempt",
+			cfg:  &UploaderCfg{Name: "primary", Retry: &retry.Policy{Attempts: 1}},
+		},
+		{
+			name:        "retry",
+			cfg:         &UploaderCfg{Name: "primary", Retry: &retry.Policy{Attempts: 3}},
+			expectedErr: "retry of uploader primary is not supported in stream mode",
+		},
+		{
+			name:        "abort on failure",
+			cfg:         &UploaderCfg{Name: "primary", AbortOnFailure: true},
+			expectedErr: "abort_on_failure of uploader primary is not supported in stream mode",
+		},
+		{
+			name:        "uploader without stream support",
+			cfg:         &UploaderCfg{Name: "files"},
+			expectedErr: "uploader files doesn't support stream mode",
+		},
+	}
+
+	registeredUploaders := map[string]Uploader{
+		"primary": memoryStreamStorage{&memoryStorage{files: map[string]int64{}}},
+		"files":   &memoryStorage{files: map[string]int64{}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := UploadHelper{}.validateStreamConfig(UploaderCfgs{testCase.cfg}, registeredUploaders)
+			if testCase.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if testCase.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), testCase.expectedErr)) {
+				t.Fatalf("expected error containing %q, got %v", testCase.expectedErr, err)
+			}
+		})
+	}
+}
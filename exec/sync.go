@@ -0,0 +1,314 @@
+package exec
+
+import (
+	"crypto/md5" //nolint:gosec // md5 is required to compare with the checksums of remote storages
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/remote"
+	"github.com/breathbath/dumper/retry"
+	"github.com/breathbath/dumper/volume"
+	"github.com/breathbath/go_utils/v3/pkg/errs"
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// SyncConfig describes copying of files which are missing or differ in the target storage from the source storage
+type SyncConfig struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Prefix limits synced files to the ones which names relative to the storage folder start with it
+	Prefix string `json:"prefix,omitempty"`
+	// TmpPath is used for files copied to uploaders which don't support streams
+	TmpPath string        `json:"tmpPath,omitempty"`
+	DryRun  bool          `json:"dryRun,omitempty"`
+	Retry   *retry.Policy `json:"retry,omitempty"`
+}
+
+func (sc *SyncConfig) Validate() error {
+	return validation.ValidateStruct(sc,
+		validation.Field(&sc.From, validation.Required),
+		validation.Field(&sc.To, validation.Required, validation.NotIn(sc.From).Error("should differ from the source")),
+	)
+}
+
+type SyncExecutor struct {
+	Uploaders map[string]Uploader
+}
+
+func (se SyncExecutor) GetValidConfig(generalConfig *config.Config) (interface{}, error) {
+	syncConfig := new(SyncConfig)
+	err := json.Unmarshal([]byte(*generalConfig.Context), syncConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = se.ValidateConfig(syncConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return syncConfig, nil
+}
+
+// ValidateConfig checks that both storages are registered and can list their files
+func (se SyncExecutor) ValidateConfig(syncConfig *SyncConfig) error {
+	err := syncConfig.Validate()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{syncConfig.From, syncConfig.To} {
+		uploader, ok := se.Uploaders[name]
+		if !ok {
+			return fmt.Errorf("unknown uploader name %s", name)
+		}
+
+		if _, ok := uploader.(RemoteStorage); !ok {
+			return fmt.Errorf("uploader %s doesn't support listing of remote files", name)
+		}
+	}
+
+	if syncConfig.Retry != nil {
+		err = syncConfig.Retry.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid retry policy: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (se SyncExecutor) Execute(generalConfig *config.Config, execConfig interface{}, result *JobResult) error {
+	syncConfig, ok := execConfig.(*SyncConfig)
+	if !ok {
+		return fmt.Errorf("wrong config format for sync")
+	}
+
+	return se.Sync(syncConfig, result)
+}
+
+// Sync copies files missing in the target storage, files which exist in both storages are compared
+// by size and checksums, manifests of volumes are copied last, so they never refer to missing volumes
+func (se SyncExecutor) Sync(syncConfig *SyncConfig, result *JobResult) error {
+	from := se.Uploaders[syncConfig.From].(RemoteStorage)
+	to := se.Uploaders[syncConfig.To].(RemoteStorage)
+
+	srcFiles, err := from.List(syncConfig.Prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list files of %s: %v", syncConfig.From, err)
+	}
+
+	dstFiles, err := to.List(syncConfig.Prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list files of %s: %v", syncConfig.To, err)
+	}
+
+	existing := make(map[string]*remote.File, len(dstFiles))
+	for _, dstFile := range dstFiles {
+		existing[dstFile.Name] = dstFile
+	}
+
+	toCopy := []*remote.File{}
+	for _, srcFile := range srcFiles {
+		dstFile, ok := existing[srcFile.Name]
+		if !ok {
+			if _, split := existing[volume.ManifestName(srcFile.Name)]; split {
+				// the target storage has split the file into volumes
+				continue
+			}
+			toCopy = append(toCopy, srcFile)
+			continue
+		}
+
+		if !sameContent(srcFile, dstFile) {
+			io2.OutputWarning("", "%s differs in %s and %s, will copy it again", srcFile.Name, syncConfig.From, syncConfig.To)
+			toCopy = append(toCopy, srcFile)
+		}
+	}
+
+	sort.SliceStable(toCopy, func(i, j int) bool {
+		return !strings.HasSuffix(toCopy[i].Name, volume.ManifestSuffix) &&
+			strings.HasSuffix(toCopy[j].Name, volume.ManifestSuffix)
+	})
+
+	io2.OutputInfo(
+		"",
+		"sync from %s to %s: %d files in the source, %d in the target, %d to copy",
+		syncConfig.From,
+		syncConfig.To,
+		len(srcFiles),
+		len(dstFiles),
+		len(toCopy),
+	)
+
+	ers := errs.NewErrorContainer()
+	for _, srcFile := range toCopy {
+		if syncConfig.DryRun {
+			io2.OutputInfo("", "dry run: would copy %s (%d bytes) from %s to %s", srcFile.Name, srcFile.Size, syncConfig.From, syncConfig.To)
+			continue
+		}
+
+		started := time.Now()
+		err = retry.Do(fmt.Sprintf("copy of %s to %s", srcFile.Name, syncConfig.To), syncConfig.Retry, func() error {
+			return se.copyFile(syncConfig, from, to, srcFile)
+		})
+		result.addUpload(&UploadResult{
+			File:     srcFile.Name,
+			Uploader: syncConfig.To,
+			Bytes:    srcFile.Size,
+			Duration: time.Since(started),
+			Err:      err,
+		})
+		if err != nil {
+			ers.AddError(fmt.Errorf("copy of %s from %s to %s failed: %v", srcFile.Name, syncConfig.From, syncConfig.To, err))
+		}
+	}
+
+	return ers.Result(" ")
+}
+
+// copyFile streams the file if the target supports it, otherwise the file is downloaded to a temp file first,
+// the copied data is verified against the checksums of the source and the target
+func (se SyncExecutor) copyFile(syncConfig *SyncConfig, from, to RemoteStorage, srcFile *remote.File) error {
+	sums := newContentSums()
+
+	var err error
+	if streamUploader, ok := to.(StreamUploader); ok {
+		err = se.copyStream(from, streamUploader, srcFile, sums)
+	} else {
+		err = se.copyViaTempFile(syncConfig.TmpPath, from, to, srcFile, sums)
+	}
+	if err != nil {
+		return err
+	}
+
+	copied := &remote.File{
+		Name:   srcFile.Name,
+		Size:   sums.size,
+		MD5:    hex.EncodeToString(sums.md5.Sum(nil)),
+		SHA256: hex.EncodeToString(sums.sha256.Sum(nil)),
+	}
+	if !sameContent(srcFile, copied) {
+		return fmt.Errorf("downloaded data of %s doesn't match its size or checksums in the source", srcFile.Name)
+	}
+
+	dstFile, err := to.Stat(srcFile.Name)
+	if err != nil {
+		if errors.Is(err, remote.ErrNotFound) {
+			io2.OutputWarning("", "%s isn't visible in %s after copying, it might be split into volumes", srcFile.Name, syncConfig.To)
+			return nil
+		}
+		return fmt.Errorf("failed to verify copied file: %v", err)
+	}
+
+	if !sameContent(copied, dstFile) {
+		return fmt.Errorf("%s in %s doesn't match the copied size or checksums", srcFile.Name, syncConfig.To)
+	}
+
+	io2.OutputInfo("", "copied %s (%d bytes) from %s to %s", srcFile.Name, sums.size, syncConfig.From, syncConfig.To)
+
+	return nil
+}
+
+func (se SyncExecutor) copyStream(from RemoteStorage, to StreamUploader, srcFile *remote.File, sums *contentSums) error {
+	pr, pw := io.Pipe()
+	downloadErr := make(chan error, 1)
+	go func() {
+		err := from.Download(srcFile.Name, io.MultiWriter(pw, sums))
+		pw.CloseWithError(err)
+		downloadErr <- err
+	}()
+
+	err := to.UploadStream(srcFile.Name, pr)
+	// unblocks the download if the upload stopped reading
+	pr.CloseWithError(err)
+	e := <-downloadErr
+	if err != nil {
+		return err
+	}
+
+	if e != nil {
+		return fmt.Errorf("failed to download %s: %w", srcFile.Name, e)
+	}
+
+	return nil
+}
+
+func (se SyncExecutor) copyViaTempFile(tmpPath string, from, to RemoteStorage, srcFile *remote.File, sums *contentSums) error {
+	if tmpPath != "" {
+		err := fs.MkDir(tmpPath)
+		if err != nil {
+			return fmt.Errorf("cannot create directory %s: %v", tmpPath, err)
+		}
+	}
+
+	tmpFile, err := os.CreateTemp(tmpPath, "dumper-sync-*")
+	if err != nil {
+		return err
+	}
+	defer fs.RmFile(tmpFile.Name())
+
+	err = from.Download(srcFile.Name, io.MultiWriter(tmpFile, sums))
+	if err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to download %s: %w", srcFile.Name, err)
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return to.Upload(tmpFile.Name(), srcFile.Name)
+}
+
+// sameContent compares sizes and checksums which are known for both files,
+// storages which report no common checksum are compared by size only
+func sameContent(file1, file2 *remote.File) bool {
+	if file1.Size != file2.Size {
+		return false
+	}
+
+	if file1.MD5 != "" && file2.MD5 != "" {
+		return strings.EqualFold(file1.MD5, file2.MD5)
+	}
+
+	if file1.SHA256 != "" && file2.SHA256 != "" {
+		return strings.EqualFold(file1.SHA256, file2.SHA256)
+	}
+
+	return true
+}
+
+type contentSums struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+	size   int64
+}
+
+func newContentSums() *contentSums {
+	return &contentSums{
+		md5:    md5.New(), //nolint:gosec // see the import
+		sha256: sha256.New(),
+	}
+}
+
+func (cs *contentSums) Write(p []byte) (int, error) {
+	cs.md5.Write(p)
+	cs.sha256.Write(p)
+	cs.size += int64(len(p))
+
+	return len(p), nil
+}
@@ -3,12 +3,15 @@ package exec
 import (
 	"encoding/json"
 	"fmt"
+	stdio "io"
 	"os/exec"
 	"path/filepath"
 	"time"
 
 	"github.com/breathbath/dumper/cli"
 	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retention"
 	"github.com/breathbath/go_utils/v3/pkg/errs"
 	"github.com/breathbath/go_utils/v3/pkg/fs"
 	"github.com/breathbath/go_utils/v3/pkg/io"
@@ -19,7 +22,11 @@ type TarConfig struct {
 	Paths      []string     `json:"paths"`
 	OutputPath string       `json:"outputPath"`
 	TarBin     string       `json:"gzipBin"`
-	Upload     *UploaderCfg `json:"upload"`
+	Upload     UploaderCfgs `json:"upload"`
+	// LocalRetention deletes old archives of this job from OutputPath
+	LocalRetention *retention.Policy `json:"localRetention,omitempty"`
+	// Stream pipes archives directly to the uploaders without storing them locally
+	Stream bool `json:"stream,omitempty"`
 }
 
 func (tc *TarConfig) Validate() error {
@@ -59,10 +66,24 @@ func (te TarExecutor) GetValidConfig(generalConfig *config.Config) (interface{},
 		return nil, err
 	}
 
+	if gConfig.Stream {
+		err = te.validateStreamConfig(gConfig.Upload, te.Uploaders)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if gConfig.LocalRetention != nil {
+		err = gConfig.LocalRetention.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid local retention policy: %v", err)
+		}
+	}
+
 	return gConfig, err
 }
 
-func (te TarExecutor) Execute(generalConfig *config.Config, execConfig interface{}) error {
+func (te TarExecutor) Execute(generalConfig *config.Config, execConfig interface{}, result *JobResult) error {
 	tarConfig, ok := execConfig.(*TarConfig)
 	if !ok {
 		return fmt.Errorf("wrong config format for gzip dumper")
@@ -76,12 +97,22 @@ func (te TarExecutor) Execute(generalConfig *config.Config, execConfig interface
 		}
 	}
 
-	nowSuffix := time.Now().UTC().Format("02.01.2006.15.04.05.000")
+	now := time.Now().UTC()
+	nowSuffix := now.Format(retention.TimestampLayout)
+	vars := pathtpl.Vars{Job: generalConfig.Name, Kind: generalConfig.Kind, Time: now}
 
 	ers := errs.NewErrorContainer()
 	for _, path := range tarConfig.Paths {
 		lastFolderName := filepath.Base(path)
 		fileName := fmt.Sprintf("%s_%s.tar%s", lastFolderName, nowSuffix, GzExt)
+		if tarConfig.Stream {
+			err = te.streamArchive(tarConfig, vars, path, fileName, result)
+			if err != nil {
+				ers.AddError(err)
+			}
+			continue
+		}
+
 		if tarConfig.OutputPath != "" && !fs.FileExists(tarConfig.OutputPath) {
 			err = fs.MkDir(tarConfig.OutputPath)
 			if err != nil {
@@ -98,12 +129,22 @@ func (te TarExecutor) Execute(generalConfig *config.Config, execConfig interface
 			ErrorWriter:   cli.NewStdErrorWriter(),
 		}
 
-		err = cgexec.Execute(
-			"%s -czf %s %s",
-			tarConfig.TarBin,
-			fullFileName,
-			path,
-		)
+		if pipe := throttledPipe(); pipe != "" {
+			err = cgexec.Execute(
+				"set -o pipefail && %s -czf - %s%s > %s",
+				tarConfig.TarBin,
+				path,
+				pipe,
+				fullFileName,
+			)
+		} else {
+			err = cgexec.Execute(
+				"%s -czf %s %s",
+				tarConfig.TarBin,
+				fullFileName,
+				path,
+			)
+		}
 
 		if err != nil {
 			ers.AddError(err)
@@ -112,11 +153,13 @@ func (te TarExecutor) Execute(generalConfig *config.Config, execConfig interface
 
 		io.OutputInfo("", "successfully archived %s to %s", path, fullFileName)
 
-		err = te.uploadIfNeeded(fullFileName, tarConfig.Upload, te.Uploaders)
+		err = te.uploadIfNeeded(fullFileName, vars, tarConfig.Upload, te.Uploaders, result)
 		if err != nil {
 			ers.AddError(err)
 			continue
 		}
+
+		applyLocalRetention(fullFileName, tarConfig.LocalRetention)
 	}
 
 	err = ers.Result(" ")
@@ -126,3 +169,21 @@ func (te TarExecutor) Execute(generalConfig *config.Config, execConfig interface
 
 	return nil
 }
+
+func (te TarExecutor) streamArchive(
+	tarConfig *TarConfig,
+	vars pathtpl.Vars,
+	path, fileName string,
+	result *JobResult,
+) error {
+	io.OutputInfo("", "Will stream archive of %s as %s", path, fileName)
+
+	return te.uploadStream(fileName, vars, tarConfig.Upload, te.Uploaders, result, func(w stdio.Writer) error {
+		cgexec := cli.CmdExec{
+			SuccessWriter: w,
+			ErrorWriter:   cli.NewStdErrorWriter(),
+		}
+
+		return cgexec.Execute("%s -czf - %s", tarConfig.TarBin, path)
+	})
+}
@@ -0,0 +1,18 @@
+package exec
+
+import (
+	"fmt"
+
+	"github.com/breathbath/dumper/throttle"
+)
+
+// throttledPipe returns a pv call which limits writing of dumps and archives if IO_RATE_LIMIT is set,
+// pv keeps the rate for the whole dump
+func throttledPipe() string {
+	rate := throttle.IORate()
+	if rate <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" | pv -q -L %d", rate)
+}
@@ -1,67 +1,257 @@
 package exec
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"time"
 
-	"github.com/breathbath/go_utils/v3/pkg/io"
+	"github.com/breathbath/dumper/outbox"
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/remote"
+	"github.com/breathbath/dumper/retention"
+	"github.com/breathbath/dumper/retry"
+	"github.com/breathbath/go_utils/v3/pkg/errs"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
 )
 
+// Uploader puts a local file to the remote path, which is relative to the folder configured for the uploader
 type Uploader interface {
-	Upload(path string) error
+	Upload(localPath, remotePath string) error
+}
+
+// RemoteStorage is an Uploader which can also inspect and manage already uploaded files,
+// names are relative to the folder where the storage uploads files
+type RemoteStorage interface {
+	Uploader
+	List(prefix string) ([]*remote.File, error)
+	Stat(name string) (*remote.File, error)
+	Download(name string, w io.Writer) error
+	Delete(name string) error
 }
 
 type UploaderCfg struct {
-	Name              string `json:"name"`
-	DeleteAfterUpload bool   `json:"delete_after_upload"`
+	Name string `json:"name"`
+	// DeleteAfterUpload removes the local file once it's uploaded to all required destinations,
+	// it applies to the whole job, so all destinations of a job should have the same value
+	DeleteAfterUpload bool `json:"delete_after_upload"`
+	// BestEffort destinations don't fail the job and don't block DeleteAfterUpload
+	BestEffort bool `json:"best_effort,omitempty"`
+	// AbortOnFailure skips all remaining destinations if the upload to this one fails, it's not supported in stream mode
+	AbortOnFailure bool `json:"abort_on_failure,omitempty"`
+	// Retention deletes expired remote artifacts once the upload to all required destinations succeeded
+	Retention *retention.Policy `json:"retention,omitempty"`
+	// Retry repeats uploads failed with transient errors, 3 attempts are made by default,
+	// in stream mode only a single attempt is possible
+	Retry *retry.Policy `json:"retry,omitempty"`
+	// Path is a template of the folder inside the uploader's folder, e.g. backups/{job}/{yyyy}/{mm},
+	// see pathtpl.Render for the supported placeholders
+	Path string `json:"path,omitempty"`
 }
 
-type UploadHelper struct {
+func (uc *UploaderCfg) remotePath(fileName string, vars pathtpl.Vars) string {
+	return path.Join(pathtpl.Render(uc.Path, vars), fileName)
 }
 
-func (uh UploadHelper) validateConfig(cfg *UploaderCfg, registeredUploaders map[string]Uploader) error {
-	if cfg == nil || cfg.Name == "" {
+// UploaderCfgs can be defined either as a single upload destination object or as a list of them
+type UploaderCfgs []*UploaderCfg
+
+func (ucs *UploaderCfgs) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*ucs = nil
 		return nil
 	}
 
-	if len(registeredUploaders) == 0 {
-		return fmt.Errorf("empty uploaders list for %s", cfg.Name)
+	if bytes.HasPrefix(data, []byte("[")) {
+		var cfgs []*UploaderCfg
+		err := json.Unmarshal(data, &cfgs)
+		if err != nil {
+			return err
+		}
+		*ucs = cfgs
+		return nil
 	}
 
-	if _, ok := registeredUploaders[cfg.Name]; !ok {
-		return fmt.Errorf("unknown uploader name %s", cfg.Name)
+	cfg := new(UploaderCfg)
+	err := json.Unmarshal(data, cfg)
+	if err != nil {
+		return err
 	}
+	*ucs = UploaderCfgs{cfg}
 
 	return nil
 }
 
-func (uh UploadHelper) uploadIfNeeded(filepath string, cfg *UploaderCfg, registeredUploaders map[string]Uploader) error {
-	if cfg == nil || cfg.Name == "" {
-		return nil
+type UploadHelper struct {
+	// Outbox keeps failed uploads to retry them on later runs, nil disables it
+	Outbox *outbox.Outbox
+}
+
+func (uh UploadHelper) validateConfig(cfgs UploaderCfgs, registeredUploaders map[string]Uploader) error {
+	var first *UploaderCfg
+	for _, cfg := range cfgs {
+		if cfg == nil || cfg.Name == "" {
+			continue
+		}
+
+		if first == nil {
+			first = cfg
+		} else if cfg.DeleteAfterUpload != first.DeleteAfterUpload {
+			return fmt.Errorf(
+				"delete_after_upload of uploader %s differs from %s, it should be the same for all destinations of a job",
+				cfg.Name,
+				first.Name,
+			)
+		}
+
+		if len(registeredUploaders) == 0 {
+			return fmt.Errorf("empty uploaders list for %s", cfg.Name)
+		}
+
+		uploader, ok := registeredUploaders[cfg.Name]
+		if !ok {
+			return fmt.Errorf("unknown uploader name %s", cfg.Name)
+		}
+
+		err := pathtpl.Validate(cfg.Path)
+		if err != nil {
+			return fmt.Errorf("invalid path of uploader %s: %v", cfg.Name, err)
+		}
+
+		if cfg.Retry != nil {
+			err = cfg.Retry.Validate()
+			if err != nil {
+				return fmt.Errorf("invalid retry policy for uploader %s: %v", cfg.Name, err)
+			}
+		}
+
+		if cfg.Retention == nil {
+			continue
+		}
+
+		if _, ok := uploader.(RemoteStorage); !ok {
+			return fmt.Errorf("uploader %s doesn't support retention", cfg.Name)
+		}
+
+		err = cfg.Retention.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid retention policy for uploader %s: %v", cfg.Name, err)
+		}
 	}
 
-	err := uh.validateConfig(cfg, registeredUploaders)
+	return nil
+}
+
+func (uh UploadHelper) uploadIfNeeded(
+	localPath string,
+	vars pathtpl.Vars,
+	cfgs UploaderCfgs,
+	registeredUploaders map[string]Uploader,
+	result *JobResult,
+) error {
+	err := uh.validateConfig(cfgs, registeredUploaders)
 	if err != nil {
 		return err
 	}
 
-	uploader := registeredUploaders[cfg.Name]
+	var size int64
+	if fileInfo, e := os.Stat(localPath); e == nil {
+		size = fileInfo.Size()
+	}
+
+	ers := errs.NewErrorContainer()
+	uploadedCount := 0
+	requiredFailed := false
+	deleteAfterUpload := false
+	aborted := false
+	uploaded := []uploadedFile{}
 
-	if cfg.DeleteAfterUpload {
-		defer func() {
-			e := os.Remove(filepath)
-			if e != nil {
-				io.OutputError(e, "", "Failed to delete %s", filepath)
-			} else {
-				io.OutputInfo("", "deleted %s", filepath)
+	for _, cfg := range cfgs {
+		if cfg == nil || cfg.Name == "" {
+			continue
+		}
+
+		deleteAfterUpload = cfg.DeleteAfterUpload
+
+		if aborted {
+			skipErr := fmt.Errorf("upload of %s to %s is skipped due to previous failures", localPath, cfg.Name)
+			if !cfg.BestEffort {
+				requiredFailed = true
+				ers.AddError(skipErr)
 			}
-		}()
+			uh.addToOutbox(vars, cfg, localPath, cfg.remotePath(filepath.Base(localPath), vars), skipErr)
+			continue
+		}
+
+		uploader := registeredUploaders[cfg.Name]
+		remotePath := cfg.remotePath(filepath.Base(localPath), vars)
+		started := time.Now()
+		err = retry.Do(fmt.Sprintf("upload of %s to %s", localPath, cfg.Name), cfg.Retry, func() error {
+			return uploader.Upload(localPath, remotePath)
+		})
+		result.addUpload(&UploadResult{
+			File:     remotePath,
+			Uploader: cfg.Name,
+			Bytes:    size,
+			Duration: time.Since(started),
+			Err:      err,
+		})
+		if err == nil {
+			uploadedCount++
+			uploaded = append(uploaded, uploadedFile{remotePath: remotePath, cfg: cfg})
+			continue
+		}
+
+		uh.addToOutbox(vars, cfg, localPath, remotePath, err)
+
+		if cfg.BestEffort {
+			io2.OutputWarning("", "best effort upload of %s to %s failed: %v", localPath, cfg.Name, err)
+		} else {
+			requiredFailed = true
+			ers.AddError(fmt.Errorf("upload of %s to %s failed: %v", localPath, cfg.Name, err))
+		}
+
+		if cfg.AbortOnFailure {
+			io2.OutputWarning("", "will skip remaining uploads of %s since upload to %s failed", localPath, cfg.Name)
+			aborted = true
+		}
+	}
+
+	if deleteAfterUpload {
+		uh.deleteUploadedFile(localPath, uploadedCount, requiredFailed)
 	}
 
-	err = uploader.Upload(filepath)
+	uh.applyRetentionAfterUpload(uploaded, vars, registeredUploaders, requiredFailed)
+
+	return ers.Result(" ")
+}
+
+func (uh UploadHelper) addToOutbox(vars pathtpl.Vars, cfg *UploaderCfg, localPath, remotePath string, cause error) {
+	if uh.Outbox == nil {
+		return
+	}
+
+	err := uh.Outbox.Add(vars.Job, cfg.Name, localPath, remotePath, cause)
 	if err != nil {
-		return err
+		io2.OutputError(err, "", "failed to add upload of %s to %s to the outbox", localPath, cfg.Name)
 	}
+}
 
-	return nil
+func (uh UploadHelper) deleteUploadedFile(filepath string, uploadedCount int, requiredFailed bool) {
+	if requiredFailed || uploadedCount == 0 {
+		io2.OutputWarning("", "will keep %s since it wasn't uploaded to all required destinations", filepath)
+		return
+	}
+
+	e := os.Remove(filepath)
+	if e != nil {
+		io2.OutputError(e, "", "Failed to delete %s", filepath)
+	} else {
+		io2.OutputInfo("", "deleted %s", filepath)
+	}
 }
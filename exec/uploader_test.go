@@ -0,0 +1,88 @@
+package exec
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retention"
+	"github.com/breathbath/dumper/retry"
+)
+
+func TestUploadIfNeededAppliesRetentionAfterAllRequiredUploads(t *testing.T) {
+	oldFile := timestampedName(48*time.Hour, "_db.sql.gz")
+
+	testCases := []struct {
+		name            string
+		mirrorErr       error
+		mirrorBest      bool
+		expectedDeleted []string
+		expectedKept    bool
+	}{
+		{
+			name:            "all uploads succeeded",
+			expectedDeleted: []string{oldFile},
+		},
+		{
+			name:         "required upload failed",
+			mirrorErr:    retry.Permanent(errors.New("access denied")),
+			expectedKept: true,
+		},
+		{
+			name:            "best effort upload failed",
+			mirrorErr:       retry.Permanent(errors.New("access denied")),
+			mirrorBest:      true,
+			expectedDeleted: []string{oldFile},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			localPath := filepath.Join(t.TempDir(), timestampedName(0, "_db.sql.gz"))
+			err := os.WriteFile(localPath, []byte("dump"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			primary := &memoryStorage{files: map[string]int64{oldFile: 1}}
+			mirror := &memoryStorage{files: map[string]int64{}, uploadErr: testCase.mirrorErr}
+			registeredUploaders := map[string]Uploader{"primary": primary, "mirror": mirror}
+			cfgs := UploaderCfgs{
+				{Name: "primary", DeleteAfterUpload: true, Retention: &retention.Policy{KeepLast: 1}},
+				{Name: "mirror", DeleteAfterUpload: true, BestEffort: testCase.mirrorBest},
+			}
+
+			err = UploadHelper{}.uploadIfNeeded(localPath, pathtpl.Vars{}, cfgs, registeredUploaders, NewJobResult("job"))
+			if (err != nil) != (testCase.mirrorErr != nil && !testCase.mirrorBest) {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(primary.deleted, testCase.expectedDeleted) {
+				t.Errorf("expected deleted remote files %v, got %v", testCase.expectedDeleted, primary.deleted)
+			}
+
+			_, err = os.Stat(localPath)
+			if kept := err == nil; kept != testCase.expectedKept {
+				t.Errorf("expected the local file to be kept %v, got stat error %v", testCase.expectedKept, err)
+			}
+		})
+	}
+}
+
+func TestValidateConfigRejectsDifferentDeleteAfterUpload(t *testing.T) {
+	registeredUploaders := map[string]Uploader{"primary": &memoryStorage{}, "mirror": &memoryStorage{}}
+	cfgs := UploaderCfgs{
+		{Name: "primary", DeleteAfterUpload: true},
+		{Name: "mirror"},
+	}
+
+	err := UploadHelper{}.validateConfig(cfgs, registeredUploaders)
+	if err == nil || !strings.Contains(err.Error(), "delete_after_upload of uploader mirror differs from primary") {
+		t.Fatalf("expected an error about delete_after_upload, got %v", err)
+	}
+}
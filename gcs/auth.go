@@ -0,0 +1,190 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	storageScope      = "https://www.googleapis.com/auth/devstorage.read_write"
+	defaultTokenURI   = "https://oauth2.googleapis.com/token"
+	jwtGrantType      = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	tokenLifetime     = time.Hour
+	tokenRefreshAhead = time.Minute
+)
+
+type serviceAccount struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// tokenSource exchanges a signed service account JWT for an OAuth access token,
+// see https://developers.google.com/identity/protocols/oauth2/service-account#httprest for details
+type tokenSource struct {
+	account *serviceAccount
+	key     *rsa.PrivateKey
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func newTokenSource(credentialsJSON []byte) (*tokenSource, error) {
+	account := new(serviceAccount)
+	err := json.Unmarshal(credentialsJSON, account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse gcs credentials: %v", err)
+	}
+
+	if account.Type != "service_account" {
+		return nil, fmt.Errorf("unsupported gcs credentials type %q, only service_account is supported", account.Type)
+	}
+
+	if account.TokenURI == "" {
+		account.TokenURI = defaultTokenURI
+	}
+
+	key, err := parsePrivateKey(account.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenSource{
+		account: account,
+		key:     key,
+	}, nil
+}
+
+func (ts *tokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && time.Now().Add(tokenRefreshAhead).Before(ts.expiresAt) {
+		return ts.token, nil
+	}
+
+	assertion, err := ts.buildJWT(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {jwtGrantType},
+		"assertion":  {assertion},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	cl := &http.Client{}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call google token api: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body %v", err)
+	}
+
+	tokenResp := new(tokenResponse)
+	err = json.NewDecoder(bytes.NewBuffer(bodyBytes)).Decode(tokenResp)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode token response %q: %v", string(bodyBytes), err)
+	}
+
+	if resp.StatusCode != http.StatusOK || tokenResp.AccessToken == "" {
+		return "", fmt.Errorf(
+			"failed to retrieve access token: wrong response code %d from google, message %s[%s]",
+			resp.StatusCode,
+			tokenResp.Description,
+			tokenResp.Error,
+		)
+	}
+
+	ts.token = tokenResp.AccessToken
+	ts.expiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+
+	return ts.token, nil
+}
+
+func (ts *tokenSource) buildJWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   ts.account.ClientEmail,
+		"scope": storageScope,
+		"aud":   ts.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(tokenLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign jwt: %v", err)
+	}
+
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+func parsePrivateKey(keyPEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("failed to decode gcs private key: no PEM data found")
+	}
+
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		pkcs1Key, pkcs1Err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if pkcs1Err != nil {
+			return nil, fmt.Errorf("failed to parse gcs private key: %v", err)
+		}
+		return pkcs1Key, nil
+	}
+
+	rsaKey, ok := parsedKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("gcs private key is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
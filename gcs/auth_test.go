@@ -0,0 +1,209 @@
+package gcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testClientEmail = "dumper@project.iam.gserviceaccount.com"
+
+func newTestCredentials(t *testing.T, tokenURI string) (credentialsJSON []byte, key *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialsJSON, err = json.Marshal(serviceAccount{
+		Type:        "service_account",
+		ClientEmail: testClientEmail,
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})),
+		TokenURI:    tokenURI,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credentialsJSON, key
+}
+
+// verifyJWT checks the RS256 signature of the assertion with the public key and returns its claims
+func verifyJWT(assertion string, publicKey *rsa.PublicKey) (map[string]interface{}, error) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("expected 3 jwt parts, got %d", len(parts))
+	}
+
+	enc := base64.RawURLEncoding
+	header := map[string]string{}
+	headerBytes, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(headerBytes, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header["alg"] != "RS256" || header["typ"] != "JWT" {
+		return nil, fmt.Errorf("unexpected jwt header %v", header)
+	}
+
+	signature, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt signature: %v", err)
+	}
+
+	claims := map[string]interface{}{}
+	claimsBytes, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(claimsBytes, &claims)
+
+	return claims, err
+}
+
+func TestTokenSourceExchangesSignedJWT(t *testing.T) {
+	var tokenURI string
+	var key *rsa.PrivateKey
+	tokenRequests := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+
+		if r.FormValue("grant_type") != jwtGrantType {
+			t.Errorf("unexpected grant type %q", r.FormValue("grant_type"))
+		}
+
+		claims, err := verifyJWT(r.FormValue("assertion"), &key.PublicKey)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": "invalid_grant", "error_description": %q}`, err.Error())
+			return
+		}
+
+		iat, _ := claims["iat"].(float64)
+		exp, _ := claims["exp"].(float64)
+		if claims["iss"] != testClientEmail || claims["scope"] != storageScope || claims["aud"] != tokenURI ||
+			time.Duration(exp-iat)*time.Second != tokenLifetime {
+			t.Errorf("unexpected jwt claims %v", claims)
+		}
+
+		fmt.Fprint(w, `{"access_token": "token-1", "expires_in": 3600}`)
+	}))
+	defer srv.Close()
+
+	tokenURI = srv.URL + "/token"
+	credentialsJSON, key := newTestCredentials(t, tokenURI)
+
+	ts, err := newTokenSource(credentialsJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := ts.Token(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if token != "token-1" {
+			t.Errorf("expected token-1, got %q", token)
+		}
+	}
+
+	if tokenRequests != 1 {
+		t.Errorf("expected the token to be cached, got %d token requests", tokenRequests)
+	}
+}
+
+func TestTokenSourceReportsRejectedJWT(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "Invalid JWT Signature."}`)
+	}))
+	defer srv.Close()
+
+	credentialsJSON, _ := newTestCredentials(t, srv.URL)
+	ts, err := newTokenSource(credentialsJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ts.Token(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Invalid JWT Signature.[invalid_grant]") {
+		t.Fatalf("expected the error from google, got %v", err)
+	}
+}
+
+func TestNewTokenSourceRejectsInvalidCredentials(t *testing.T) {
+	testCases := []struct {
+		name        string
+		credentials string
+		expectedErr string
+	}{
+		{
+			name:        "user credentials",
+			credentials: `{"type": "authorized_user"}`,
+			expectedErr: "only service_account is supported",
+		},
+		{
+			name:        "no private key",
+			credentials: `{"type": "service_account", "private_key": "key"}`,
+			expectedErr: "no PEM data found",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := newTokenSource([]byte(testCase.credentials))
+			if err == nil || !strings.Contains(err.Error(), testCase.expectedErr) {
+				t.Fatalf("expected error containing %q, got %v", testCase.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestUploadStreamAuthorizesWithServiceAccount(t *testing.T) {
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token": "token-1", "expires_in": 3600}`)
+	}))
+	defer tokenSrv.Close()
+
+	credentialsJSON, _ := newTestCredentials(t, tokenSrv.URL)
+	f := newFakeGCS(t, testBucket)
+	f.auth = "Bearer token-1"
+
+	s := NewService(&UploadConfig{Endpoint: f.srv.URL, Bucket: testBucket, CredentialsJSON: string(credentialsJSON)})
+
+	err := s.UploadStream("db.sql.gz", strings.NewReader("dump"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(f.objects["db.sql.gz"]) != "dump" {
+		t.Errorf("expected the uploaded object, got %v", f.objects)
+	}
+}
@@ -0,0 +1,436 @@
+package gcs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/progress"
+	"github.com/breathbath/dumper/retry"
+	"github.com/breathbath/dumper/throttle"
+	"github.com/breathbath/go_utils/v3/pkg/env"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const GcsUploader = "gcs"
+
+const (
+	defaultEndpoint        = "https://storage.googleapis.com"
+	defaultChunkSizeMb     = 16
+	bytesInMb              = 1024 * 1024
+	statusResumeIncomplete = 308
+)
+
+type UploadConfig struct {
+	Endpoint         string        `json:"endpoint"`
+	Bucket           string        `json:"bucket"`
+	CredentialsPath  string        `json:"credentialsPath"`
+	CredentialsJSON  string        `json:"credentialsJson"`
+	AccessToken      string        `json:"accessToken"`
+	RemoteFolder     string        `json:"folder"`
+	StorageClass     string        `json:"storageClass"`
+	ChunkSizeMb      int           `json:"chunkSizeMb"`
+	UploadTimeoutRaw string        `json:"timeout"`
+	UploadTimeout    time.Duration `json:"-"`
+	RateLimit        string        `json:"rateLimit"`
+}
+
+func (mc *UploadConfig) Validate() error {
+	fields := []*validation.FieldRules{
+		validation.Field(&mc.Bucket, validation.Required),
+		validation.Field(&mc.ChunkSizeMb, validation.Min(1)),
+		validation.Field(&mc.CredentialsPath, validation.By(func(value interface{}) error {
+			if fmt.Sprint(value) != "" && mc.CredentialsJSON != "" {
+				return errors.New("either credentials path or credentials json should be provided, not both")
+			}
+
+			return nil
+		})),
+		validation.Field(&mc.RateLimit, validation.By(func(value interface{}) error {
+			_, err := throttle.ParseSchedule(fmt.Sprint(value))
+			return err
+		})),
+		validation.Field(&mc.UploadTimeoutRaw, config.DurationRule),
+	}
+
+	return validation.ValidateStruct(mc, fields...)
+}
+
+func NewConfigFromEnvs() *UploadConfig {
+	cfg := &UploadConfig{}
+	cfg.Endpoint = env.ReadEnv("GCS_ENDPOINT", "")
+	cfg.Bucket = env.ReadEnv("GCS_BUCKET", "")
+	cfg.CredentialsPath = env.ReadEnv("GCS_CREDENTIALS_PATH", "")
+	cfg.CredentialsJSON = env.ReadEnv("GCS_CREDENTIALS_JSON", "")
+	cfg.AccessToken = env.ReadEnv("GCS_ACCESS_TOKEN", "")
+	cfg.RemoteFolder = env.ReadEnv("GCS_FOLDER", "")
+	cfg.StorageClass = env.ReadEnv("GCS_STORAGE_CLASS", "")
+
+	cfg.ChunkSizeMb = defaultChunkSizeMb
+	chunkSizeRaw := env.ReadEnv("GCS_CHUNK_SIZE_MB", "")
+	if chunkSizeRaw != "" {
+		chunkSize, err := strconv.Atoi(chunkSizeRaw)
+		if err == nil {
+			cfg.ChunkSizeMb = chunkSize
+		}
+	}
+
+	cfg.RateLimit = env.ReadEnv("GCS_RATE_LIMIT", "")
+	cfg.UploadTimeoutRaw, cfg.UploadTimeout = config.ReadDurationEnv("GCS_UPLOADER_TIMEOUT", 0)
+
+	return cfg
+}
+
+func NewConfigFromSettings(settings []byte) (*UploadConfig, error) {
+	cfg := &UploadConfig{
+		ChunkSizeMb: defaultChunkSizeMb,
+	}
+	err := config.ParseUploaderSettings(
+		GcsUploader, settings, cfg,
+		&cfg.Endpoint, &cfg.Bucket, &cfg.CredentialsPath, &cfg.CredentialsJSON, &cfg.AccessToken, &cfg.RemoteFolder,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.UploadTimeout = config.ParseDuration(cfg.UploadTimeoutRaw, 0)
+
+	return cfg, nil
+}
+
+type ResponseErr struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type Service struct {
+	cfg     *UploadConfig
+	limiter *throttle.Limiter
+
+	mu          sync.Mutex
+	tokenSource *tokenSource
+}
+
+func NewService(cfg *UploadConfig) *Service {
+	if cfg.ChunkSizeMb == 0 {
+		cfg.ChunkSizeMb = defaultChunkSizeMb
+	}
+
+	return &Service{
+		cfg:     cfg,
+		limiter: throttle.NewLimiterFromSpec(GcsUploader, cfg.RateLimit),
+	}
+}
+
+// see https://cloud.google.com/storage/docs/performing-resumable-uploads for details
+func (s *Service) Upload(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return s.UploadStream(remotePath, file)
+}
+
+// UploadStream sends data of unknown size in chunks of a resumable upload session
+func (s *Service) UploadStream(name string, r io.Reader) error {
+	if err := s.cfg.Validate(); err != nil {
+		return retry.Permanent(err)
+	}
+
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	if s.cfg.UploadTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), s.cfg.UploadTimeout)
+		defer cancel()
+	}
+
+	objectName := join(s.cfg.RemoteFolder, name)
+
+	io2.OutputInfo("", "Will upload %s to gcs bucket %q, object %q", name, s.cfg.Bucket, objectName)
+
+	pr := progress.NewReader(name, r, -1)
+	defer pr.Close()
+
+	err := s.upload(ctx, objectName, throttle.NewReader(ctx, pr, s.limiter))
+	if err != nil {
+		return err
+	}
+
+	io2.OutputInfo("", "successfully uploaded %s to gcs bucket %q, object %q", name, s.cfg.Bucket, objectName)
+
+	return nil
+}
+
+func (s *Service) upload(ctx context.Context, objectName string, r io.Reader) error {
+	sessionURI, err := s.startResumableUpload(ctx, objectName)
+	if err != nil {
+		return err
+	}
+
+	chunkSize := s.cfg.ChunkSizeMb * bytesInMb
+	br := bufio.NewReader(r)
+	buf := make([]byte, 0, chunkSize)
+	var offset int64
+
+	for {
+		n, err := io.ReadFull(br, buf[len(buf):chunkSize])
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return err
+		}
+		buf = buf[:len(buf)+n]
+
+		_, err = br.Peek(1)
+		isLast := errors.Is(err, io.EOF)
+		if err != nil && !isLast {
+			return err
+		}
+
+		committed, done, err := s.putChunk(ctx, sessionURI, buf, offset, isLast)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		io2.OutputInfo("", "uploaded %d bytes of %q", committed, objectName)
+
+		// the server might persist less than it was sent, the rest should be resent with the next chunk
+		sent := committed - offset
+		if sent < 0 || sent > int64(len(buf)) {
+			return fmt.Errorf("unexpected committed offset %d for chunk starting at %d", committed, offset)
+		}
+		buf = append(buf[:0], buf[sent:]...)
+		offset = committed
+	}
+}
+
+func (s *Service) startResumableUpload(ctx context.Context, objectName string) (string, error) {
+	u, err := url.Parse(s.endpoint())
+	if err != nil {
+		return "", err
+	}
+	escapedPath := u.EscapedPath()
+	u.Path = join(u.Path, "/upload/storage/v1/b/"+s.cfg.Bucket+"/o")
+	u.RawPath = join(escapedPath, "/upload/storage/v1/b/"+url.PathEscape(s.cfg.Bucket)+"/o")
+	u.RawQuery = url.Values{
+		"uploadType": {"resumable"},
+		"name":       {objectName},
+	}.Encode()
+
+	metadata := map[string]string{"name": objectName}
+	if s.cfg.StorageClass != "" {
+		metadata["storageClass"] = s.cfg.StorageClass
+	}
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", "application/octet-stream")
+
+	resp, respBody, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", s.parseError("failed to start resumable upload", resp.StatusCode, respBody)
+	}
+
+	sessionURI := resp.Header.Get("Location")
+	if sessionURI == "" {
+		return "", errors.New("failed to start resumable upload: empty session location")
+	}
+
+	return sessionURI, nil
+}
+
+func (s *Service) putChunk(
+	ctx context.Context,
+	sessionURI string,
+	chunk []byte,
+	offset int64,
+	isLast bool,
+) (committed int64, done bool, err error) {
+	total := "*"
+	if isLast {
+		total = strconv.FormatInt(offset+int64(len(chunk)), 10)
+	}
+
+	contentRange := fmt.Sprintf("bytes */%s", total)
+	if len(chunk) > 0 {
+		contentRange = fmt.Sprintf("bytes %d-%d/%s", offset, offset+int64(len(chunk))-1, total)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, sessionURI, bytes.NewReader(chunk))
+	if err != nil {
+		return 0, false, err
+	}
+	req.ContentLength = int64(len(chunk))
+	req.Header.Set("Content-Range", contentRange)
+
+	resp, respBody, err := s.do(req)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return offset + int64(len(chunk)), true, nil
+	case statusResumeIncomplete:
+		return parseCommittedRange(resp.Header.Get("Range"))
+	default:
+		return 0, false, s.parseError("failed to upload chunk "+contentRange, resp.StatusCode, respBody)
+	}
+}
+
+func (s *Service) do(req *http.Request) (resp *http.Response, respBody []byte, err error) {
+	err = s.authorize(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cl := &http.Client{
+		// 308 is used by the resumable upload protocol and should not be followed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err = cl.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to call gcs api %s: %v", req.Method, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response body %v", err)
+	}
+
+	return resp, respBody, nil
+}
+
+func (s *Service) authorize(req *http.Request) error {
+	if s.cfg.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.AccessToken)
+		return nil
+	}
+
+	ts, err := s.getTokenSource()
+	if err != nil {
+		return err
+	}
+
+	// no credentials are needed for emulators like fake-gcs-server
+	if ts == nil {
+		return nil
+	}
+
+	token, err := ts.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+func (s *Service) getTokenSource() (*tokenSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tokenSource != nil {
+		return s.tokenSource, nil
+	}
+
+	credentialsJSON := []byte(s.cfg.CredentialsJSON)
+	if s.cfg.CredentialsPath != "" {
+		var err error
+		credentialsJSON, err = os.ReadFile(s.cfg.CredentialsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gcs credentials from %s: %v", s.cfg.CredentialsPath, err)
+		}
+	}
+
+	if len(credentialsJSON) == 0 {
+		return nil, nil
+	}
+
+	ts, err := newTokenSource(credentialsJSON)
+	if err != nil {
+		return nil, err
+	}
+	s.tokenSource = ts
+
+	return ts, nil
+}
+
+func (s *Service) endpoint() string {
+	if s.cfg.Endpoint == "" {
+		return defaultEndpoint
+	}
+
+	return s.cfg.Endpoint
+}
+
+func (s *Service) parseError(msg string, statusCode int, respBody []byte) error {
+	errResp := new(ResponseErr)
+	err := json.Unmarshal(respBody, errResp)
+	if err != nil || errResp.Error.Message == "" {
+		return retry.ClassifyStatus(statusCode, fmt.Errorf("%s: wrong response code %d from gcs: %s", msg, statusCode, string(respBody)))
+	}
+
+	return retry.ClassifyStatus(
+		statusCode,
+		fmt.Errorf("%s: wrong response code %d from gcs, message %s", msg, statusCode, errResp.Error.Message),
+	)
+}
+
+func parseCommittedRange(rangeHeader string) (committed int64, done bool, err error) {
+	if rangeHeader == "" {
+		return 0, false, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(rangeHeader, "bytes="), "-", 2)
+	if len(parts) != 2 {
+		return 0, false, fmt.Errorf("cannot parse range header %q", rangeHeader)
+	}
+
+	last, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("cannot parse range header %q: %v", rangeHeader, err)
+	}
+
+	return last + 1, false, nil
+}
+
+func join(path0, path1 string) string {
+	if path0 == "" {
+		return strings.TrimPrefix(path1, "/")
+	}
+
+	return strings.TrimSuffix(path0, "/") + "/" + strings.TrimPrefix(path1, "/")
+}
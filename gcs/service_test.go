@@ -0,0 +1,230 @@
+package gcs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testBucket = "backups"
+
+// fakeGCS is a resumable upload server which keeps objects in memory
+type fakeGCS struct {
+	t        *testing.T
+	mu       sync.Mutex
+	srv      *httptest.Server
+	bucket   string
+	auth     string
+	objects  map[string][]byte
+	sessions map[string]*fakeSession
+	requests []string
+	// dropBytes are not persisted from the first chunk, so the client has to resend them
+	dropBytes int
+}
+
+type fakeSession struct {
+	name string
+	data []byte
+}
+
+func newFakeGCS(t *testing.T, bucket string) *fakeGCS {
+	f := &fakeGCS{
+		t:        t,
+		bucket:   bucket,
+		objects:  map[string][]byte{},
+		sessions: map[string]*fakeSession{},
+	}
+	f.srv = httptest.NewServer(f)
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if r.Header.Get("Authorization") != f.auth {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, `{"error": {"code": 401, "message": "unexpected authorization %q"}}`, r.Header.Get("Authorization"))
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.EscapedPath() == "/upload/storage/v1/b/"+url.PathEscape(f.bucket)+"/o":
+		f.requests = append(f.requests, "POST")
+		sessionID := strconv.Itoa(len(f.sessions) + 1)
+		f.sessions[sessionID] = &fakeSession{name: r.URL.Query().Get("name")}
+		w.Header().Set("Location", f.srv.URL+"/session/"+sessionID)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/session/"):
+		f.requests = append(f.requests, "PUT "+r.Header.Get("Content-Range"))
+		f.putChunk(w, f.sessions[strings.TrimPrefix(r.URL.Path, "/session/")], r.Header.Get("Content-Range"), body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error": {"code": 404, "message": "unknown request %s %s"}}`, r.Method, r.URL.EscapedPath())
+	}
+}
+
+func (f *fakeGCS) putChunk(w http.ResponseWriter, session *fakeSession, contentRange string, body []byte) {
+	if session == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var first, last int
+	var total string
+	if strings.HasPrefix(contentRange, "bytes */") {
+		first = len(session.data)
+		total = strings.TrimPrefix(contentRange, "bytes */")
+	} else {
+		_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &first, &last, &total)
+		if err != nil || last-first+1 != len(body) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": {"code": 400, "message": "invalid content range %q"}}`, contentRange)
+			return
+		}
+	}
+
+	if first != len(session.data) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"code": 400, "message": "expected offset %d, got %d"}}`, len(session.data), first)
+		return
+	}
+
+	session.data = append(session.data, body...)
+	if f.dropBytes > 0 && len(session.data) > f.dropBytes {
+		session.data = session.data[:len(session.data)-f.dropBytes]
+		f.dropBytes = 0
+	} else if total != "*" && total == strconv.Itoa(len(session.data)) {
+		f.objects[session.name] = session.data
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{}`)
+		return
+	}
+
+	if len(session.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.data)-1))
+	}
+	w.WriteHeader(statusResumeIncomplete)
+}
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	return content
+}
+
+func TestUploadStreamResumable(t *testing.T) {
+	const chunkSize = bytesInMb
+
+	testCases := []struct {
+		name             string
+		bucket           string
+		size             int
+		dropBytes        int
+		expectedRequests []string
+	}{
+		{
+			name:             "empty stream",
+			bucket:           testBucket,
+			size:             0,
+			expectedRequests: []string{"POST", "PUT bytes */0"},
+		},
+		{
+			name:             "single chunk",
+			bucket:           testBucket,
+			size:             1024,
+			expectedRequests: []string{"POST", "PUT bytes 0-1023/1024"},
+		},
+		{
+			name:   "several chunks",
+			bucket: testBucket,
+			size:   2*chunkSize + 10,
+			expectedRequests: []string{
+				"POST",
+				"PUT bytes 0-1048575/*",
+				"PUT bytes 1048576-2097151/*",
+				"PUT bytes 2097152-2097161/2097162",
+			},
+		},
+		{
+			name:      "partially committed chunk is resent",
+			bucket:    testBucket,
+			size:      chunkSize + 10,
+			dropBytes: 100,
+			expectedRequests: []string{
+				"POST",
+				"PUT bytes 0-1048575/*",
+				"PUT bytes 1048476-1048585/1048586",
+			},
+		},
+		{
+			name:             "bucket is escaped",
+			bucket:           "team/backups",
+			size:             10,
+			expectedRequests: []string{"POST", "PUT bytes 0-9/10"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			f := newFakeGCS(t, testCase.bucket)
+			f.auth = "Bearer static-token"
+			f.dropBytes = testCase.dropBytes
+
+			s := NewService(&UploadConfig{
+				Endpoint:     f.srv.URL,
+				Bucket:       testCase.bucket,
+				AccessToken:  "static-token",
+				RemoteFolder: "dumps",
+				ChunkSizeMb:  1,
+			})
+			content := testContent(testCase.size)
+
+			err := s.UploadStream("daily/db.sql.gz", bytes.NewReader(content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if strings.Join(f.requests, ", ") != strings.Join(testCase.expectedRequests, ", ") {
+				t.Errorf("expected requests %v, got %v", testCase.expectedRequests, f.requests)
+			}
+
+			uploaded, ok := f.objects["dumps/daily/db.sql.gz"]
+			if !ok {
+				t.Fatal("object is not found")
+			}
+			if !bytes.Equal(uploaded, content) {
+				t.Errorf("uploaded content of %d bytes differs from the local content of %d bytes", len(uploaded), len(content))
+			}
+		})
+	}
+}
+
+func TestUploadStreamFailsOnRejectedChunk(t *testing.T) {
+	f := newFakeGCS(t, testBucket)
+	f.auth = "Bearer static-token"
+
+	s := NewService(&UploadConfig{Endpoint: f.srv.URL, Bucket: testBucket, AccessToken: "wrong-token"})
+
+	err := s.UploadStream("db.sql.gz", bytes.NewReader(testContent(10)))
+	if err == nil || !strings.Contains(err.Error(), "unexpected authorization") {
+		t.Fatalf("expected an error with the message from gcs, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package localfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/progress"
+	"github.com/breathbath/dumper/retry"
+	"github.com/breathbath/dumper/throttle"
+	"github.com/breathbath/go_utils/v3/pkg/env"
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const FilesystemUploader = "filesystem"
+
+const partSuffix = ".part"
+
+// linkFile creates hardlinks, it's replaced in tests to simulate targets on another device
+var linkFile = os.Link
+
+type UploadConfig struct {
+	TargetFolder string `json:"folder"`
+	Hardlink     bool   `json:"hardlink"`
+	RateLimit    string `json:"rateLimit"`
+}
+
+func (mc *UploadConfig) Validate() error {
+	fields := []*validation.FieldRules{
+		validation.Field(&mc.TargetFolder, validation.Required),
+		validation.Field(&mc.RateLimit, validation.By(func(value interface{}) error {
+			_, err := throttle.ParseSchedule(fmt.Sprint(value))
+			return err
+		})),
+	}
+
+	return validation.ValidateStruct(mc, fields...)
+}
+
+func NewConfigFromEnvs() *UploadConfig {
+	cfg := &UploadConfig{}
+	cfg.TargetFolder = env.ReadEnv("FS_UPLOAD_FOLDER", "")
+	cfg.Hardlink = env.ReadEnvBool("FS_UPLOAD_HARDLINK", false)
+	cfg.RateLimit = env.ReadEnv("FS_UPLOAD_RATE_LIMIT", "")
+
+	return cfg
+}
+
+func NewConfigFromSettings(settings []byte) (*UploadConfig, error) {
+	cfg := &UploadConfig{}
+	err := config.ParseUploaderSettings(FilesystemUploader, settings, cfg, &cfg.TargetFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+type Service struct {
+	cfg     *UploadConfig
+	limiter *throttle.Limiter
+}
+
+func NewService(cfg *UploadConfig) *Service {
+	return &Service{
+		cfg:     cfg,
+		limiter: throttle.NewLimiterFromSpec(FilesystemUploader, cfg.RateLimit),
+	}
+}
+
+// Upload places the file to the target folder under a temp name and renames it when all data is synced to disk,
+// so tools watching the folder (e.g. sync clients) never pick up partially written files
+func (s *Service) Upload(localPath, remotePath string) error {
+	if err := s.cfg.Validate(); err != nil {
+		return retry.Permanent(err)
+	}
+
+	targetPath, partPath, err := s.prepareTargetPaths(remotePath)
+	if err != nil {
+		return err
+	}
+
+	io2.OutputInfo("", "Will upload file %s to %s", localPath, targetPath)
+
+	linked := false
+	if s.cfg.Hardlink {
+		linked, err = s.link(localPath, partPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !linked {
+		err = s.copy(localPath, partPath)
+		if err != nil {
+			fs.RmFile(partPath)
+			return err
+		}
+	}
+
+	err = s.commit(partPath, targetPath)
+	if err != nil {
+		return err
+	}
+
+	io2.OutputInfo("", "successfully uploaded file %s to %s", localPath, targetPath)
+
+	return nil
+}
+
+// UploadStream writes the data to a temp file in the target folder and renames it like Upload does
+func (s *Service) UploadStream(name string, r io.Reader) error {
+	if err := s.cfg.Validate(); err != nil {
+		return retry.Permanent(err)
+	}
+
+	targetPath, partPath, err := s.prepareTargetPaths(name)
+	if err != nil {
+		return err
+	}
+
+	io2.OutputInfo("", "Will upload stream %s to %s", name, targetPath)
+
+	err = s.write(r, partPath, 0600)
+	if err != nil {
+		fs.RmFile(partPath)
+		return err
+	}
+
+	err = s.commit(partPath, targetPath)
+	if err != nil {
+		return err
+	}
+
+	io2.OutputInfo("", "successfully uploaded stream %s to %s", name, targetPath)
+
+	return nil
+}
+
+// prepareTargetPaths creates the folder for the remote path, which is relative to the target folder,
+// and returns the final path and the temp path where data is written first
+func (s *Service) prepareTargetPaths(remotePath string) (targetPath, partPath string, err error) {
+	targetPath = filepath.Join(s.cfg.TargetFolder, filepath.FromSlash(remotePath))
+	targetFolder := filepath.Dir(targetPath)
+
+	err = fs.MkDir(targetFolder)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot create directory %s: %v", targetFolder, err)
+	}
+
+	partPath = filepath.Join(targetFolder, "."+filepath.Base(targetPath)+partSuffix)
+
+	return targetPath, partPath, nil
+}
+
+func (s *Service) commit(partPath, targetPath string) error {
+	err := os.Rename(partPath, targetPath)
+	if err != nil {
+		fs.RmFile(partPath)
+		return err
+	}
+
+	return syncDir(filepath.Dir(targetPath))
+}
+
+func (s *Service) link(srcPath, partPath string) (bool, error) {
+	fs.RmFile(partPath)
+
+	err := linkFile(srcPath, partPath)
+	if err != nil {
+		// most likely the target is on another device, so fallback to copying
+		io2.OutputWarning("", "cannot create hardlink %s for %s, will copy it: %v", partPath, srcPath, err)
+		return false, nil
+	}
+
+	io2.OutputInfo("", "created hardlink %s for %s", partPath, srcPath)
+
+	return true, nil
+}
+
+func (s *Service) copy(srcPath, partPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = s.write(src, partPath, srcInfo.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %v", srcPath, partPath, err)
+	}
+
+	return nil
+}
+
+func (s *Service) write(r io.Reader, partPath string, perm os.FileMode) error {
+	dst, err := os.OpenFile(partPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+
+	pr := progress.NewReader(partPath, r, -1)
+	defer pr.Close()
+
+	_, err = io.Copy(dst, throttle.NewReader(context.Background(), pr, s.limiter))
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	err = dst.Sync()
+	if err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to sync %s: %v", partPath, err)
+	}
+
+	return dst.Close()
+}
+
+func syncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	err = dir.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync directory %s: %v", dirPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package localfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// inspectingReader calls inspect before the data is read, so the state of the target folder can be checked
+// while the upload is in progress
+type inspectingReader struct {
+	r       io.Reader
+	inspect func()
+	once    bool
+	err     error
+}
+
+func (ir *inspectingReader) Read(p []byte) (int, error) {
+	if !ir.once {
+		ir.once = true
+		ir.inspect()
+	}
+
+	n, err := ir.r.Read(p)
+	if err == io.EOF && ir.err != nil {
+		return n, ir.err
+	}
+
+	return n, err
+}
+
+func readDir(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func TestUploadStreamRenamesCompleteFile(t *testing.T) {
+	targetFolder := t.TempDir()
+	targetPath := filepath.Join(targetFolder, "daily", "db.sql.gz")
+	partPath := filepath.Join(targetFolder, "daily", ".db.sql.gz"+partSuffix)
+
+	err := os.MkdirAll(filepath.Dir(targetPath), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(targetPath, []byte("previous backup"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewService(&UploadConfig{TargetFolder: targetFolder})
+	r := &inspectingReader{
+		r: strings.NewReader("new backup"),
+		inspect: func() {
+			content, err := os.ReadFile(targetPath)
+			if err != nil || string(content) != "previous backup" {
+				t.Errorf("expected the previous file to stay in place during the upload, got %q, %v", content, err)
+			}
+			if _, err := os.Stat(partPath); err != nil {
+				t.Errorf("expected data to be written to the temp file first: %v", err)
+			}
+		},
+	}
+
+	err = s.UploadStream("daily/db.sql.gz", r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "new backup" {
+		t.Errorf("expected the new content, got %q", content)
+	}
+	if names := readDir(t, filepath.Dir(targetPath)); len(names) != 1 {
+		t.Errorf("expected only the target file, got %v", names)
+	}
+}
+
+func TestUploadStreamFailureKeepsTarget(t *testing.T) {
+	targetFolder := t.TempDir()
+	s := NewService(&UploadConfig{TargetFolder: targetFolder})
+
+	r := &inspectingReader{r: strings.NewReader("truncated"), inspect: func() {}, err: errors.New("dump failed")}
+	err := s.UploadStream("db.sql.gz", r)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if names := readDir(t, targetFolder); len(names) != 0 {
+		t.Errorf("expected neither the target nor the temp file, got %v", names)
+	}
+}
+
+func TestUploadHardlink(t *testing.T) {
+	content := []byte("backup data")
+
+	testCases := []struct {
+		name         string
+		hardlink     bool
+		linkErr      error
+		expectedLink bool
+	}{
+		{name: "copy", hardlink: false, expectedLink: false},
+		{name: "hardlink", hardlink: true, expectedLink: true},
+		{name: "fallback to copy on another device", hardlink: true, linkErr: syscall.EXDEV, expectedLink: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.linkErr != nil {
+				linkFile = func(oldname, newname string) error {
+					return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: testCase.linkErr}
+				}
+				t.Cleanup(func() {
+					linkFile = os.Link
+				})
+			}
+
+			localPath := filepath.Join(t.TempDir(), "db.sql.gz")
+			err := os.WriteFile(localPath, content, 0640)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			targetFolder := t.TempDir()
+			s := NewService(&UploadConfig{TargetFolder: targetFolder, Hardlink: testCase.hardlink})
+
+			err = s.Upload(localPath, "2022/03/db.sql.gz")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			targetPath := filepath.Join(targetFolder, "2022", "03", "db.sql.gz")
+			uploaded, err := os.ReadFile(targetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(uploaded, content) {
+				t.Errorf("expected content %q, got %q", content, uploaded)
+			}
+
+			localInfo, err := os.Stat(localPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			targetInfo, err := os.Stat(targetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if os.SameFile(localInfo, targetInfo) != testCase.expectedLink {
+				t.Errorf("expected hardlink %v", testCase.expectedLink)
+			}
+			if targetInfo.Mode().Perm() != 0640 {
+				t.Errorf("expected the permissions of the local file, got %v", targetInfo.Mode().Perm())
+			}
+			if names := readDir(t, filepath.Dir(targetPath)); len(names) != 1 {
+				t.Errorf("expected only the target file, got %v", names)
+			}
+		})
+	}
+}
+
+func TestNewConfigFromSettingsResolvesFolder(t *testing.T) {
+	t.Setenv("TEST_BACKUP_FOLDER", "/mnt/backups")
+
+	cfg, err := NewConfigFromSettings([]byte(`{"folder": "${TEST_BACKUP_FOLDER}/db"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.TargetFolder != "/mnt/backups/db" {
+		t.Errorf("expected the resolved folder, got %q", cfg.TargetFolder)
+	}
+}
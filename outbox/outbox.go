@@ -0,0 +1,350 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/breathbath/go_utils/v3/pkg/env"
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const (
+	defaultAlertAfter = 24 * time.Hour
+	spoolFolder       = "spool"
+	stateFileName     = "state.json"
+)
+
+type Config struct {
+	// Path is the folder with the spooled files and the state file, empty path disables the outbox
+	Path          string        `json:"path"`
+	AlertAfterRaw string        `json:"alertAfter"`
+	AlertAfter    time.Duration `json:"-"`
+}
+
+func (c *Config) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.Path, validation.Required),
+		validation.Field(&c.AlertAfterRaw, validation.By(func(value interface{}) error {
+			valStr := fmt.Sprint(value)
+			if valStr != "" {
+				_, err := time.ParseDuration(valStr)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})),
+	)
+}
+
+func NewConfigFromEnvs() *Config {
+	cfg := &Config{
+		AlertAfter: defaultAlertAfter,
+	}
+	cfg.Path = env.ReadEnv("OUTBOX_PATH", "")
+	cfg.AlertAfterRaw = env.ReadEnv("OUTBOX_ALERT_AFTER", "")
+	if cfg.AlertAfterRaw != "" {
+		alertAfter, err := time.ParseDuration(cfg.AlertAfterRaw)
+		if err == nil {
+			cfg.AlertAfter = alertAfter
+		}
+	}
+
+	return cfg
+}
+
+// Item is a pending upload of a spooled file to a single destination
+type Item struct {
+	ID       string `json:"id"`
+	Job      string `json:"job"`
+	Uploader string `json:"uploader"`
+	// Source is the path of the file which failed to upload
+	Source string `json:"source"`
+	// Path is the spooled copy of the source which is uploaded on retries
+	Path          string    `json:"path"`
+	RemotePath    string    `json:"remotePath"`
+	CreatedAt     time.Time `json:"createdAt"`
+	Attempts      int       `json:"attempts"`
+	LastAttemptAt time.Time `json:"lastAttemptAt,omitempty"`
+	LastError     string    `json:"lastError,omitempty"`
+}
+
+type state struct {
+	Items []*Item `json:"items"`
+}
+
+// Outbox keeps failed uploads on disk, so they are retried on later runs even after restarts,
+// a nil outbox is a valid disabled one
+type Outbox struct {
+	cfg      *Config
+	mu       sync.Mutex
+	items    []*Item
+	inFlight map[string]bool
+}
+
+// New loads pending uploads from the state file, items whose spooled files are gone are dropped
+func New(cfg *Config) (*Outbox, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.AlertAfter == 0 {
+		cfg.AlertAfter = defaultAlertAfter
+	}
+
+	spoolPath := filepath.Join(cfg.Path, spoolFolder)
+	err = fs.MkDir(spoolPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create directory %s: %v", spoolPath, err)
+	}
+
+	o := &Outbox{
+		cfg:      cfg,
+		items:    []*Item{},
+		inFlight: map[string]bool{},
+	}
+
+	data, err := os.ReadFile(o.statePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return o, nil
+		}
+		return nil, err
+	}
+
+	st := state{}
+	err = json.Unmarshal(data, &st)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse outbox state %s: %v", o.statePath(), err)
+	}
+
+	for _, item := range st.Items {
+		if !fs.FileExists(item.Path) {
+			io2.OutputError(
+				fmt.Errorf("spooled file %s is missing", item.Path),
+				"",
+				"dropped pending upload of %s to %s",
+				item.RemotePath,
+				item.Uploader,
+			)
+			continue
+		}
+		o.items = append(o.items, item)
+	}
+
+	if len(o.items) > 0 {
+		io2.OutputInfo("", "outbox %s has %d pending uploads", cfg.Path, len(o.items))
+	}
+
+	return o, nil
+}
+
+// Add spools the file and records a pending upload of it, a file which failed to upload to several
+// destinations is spooled once
+func (o *Outbox) Add(job, uploader, localPath, remotePath string, cause error) error {
+	if o == nil {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, item := range o.items {
+		if item.Uploader == uploader && item.RemotePath == remotePath {
+			// the same upload failed again, e.g. a job was retried manually
+			item.LastError = cause.Error()
+			return o.save()
+		}
+	}
+
+	spooledPath := ""
+	for _, item := range o.items {
+		if item.Source == localPath {
+			spooledPath = item.Path
+			break
+		}
+	}
+
+	now := time.Now().UTC()
+	if spooledPath == "" {
+		spooledPath = filepath.Join(o.cfg.Path, spoolFolder, fmt.Sprintf("%d_%s", now.UnixNano(), filepath.Base(localPath)))
+		err := spool(localPath, spooledPath)
+		if err != nil {
+			return fmt.Errorf("cannot spool %s to %s: %v", localPath, spooledPath, err)
+		}
+	}
+
+	o.items = append(o.items, &Item{
+		ID:         fmt.Sprintf("%d_%s", now.UnixNano(), uploader),
+		Job:        job,
+		Uploader:   uploader,
+		Source:     localPath,
+		Path:       spooledPath,
+		RemotePath: remotePath,
+		CreatedAt:  now,
+		Attempts:   1,
+		LastError:  cause.Error(),
+	})
+
+	io2.OutputInfo("", "upload of %s to %s is added to the outbox", localPath, uploader)
+
+	return o.save()
+}
+
+// Flush tries to upload every pending item once, items which fail again stay in the outbox,
+// the ones pending longer than the alert threshold are reported as errors
+func (o *Outbox) Flush(upload func(item *Item) error) {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	pending := make([]*Item, 0, len(o.items))
+	for _, item := range o.items {
+		if !o.inFlight[item.ID] {
+			o.inFlight[item.ID] = true
+			pending = append(pending, item)
+		}
+	}
+	o.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	io2.OutputInfo("", "will retry %d pending uploads from the outbox", len(pending))
+
+	for _, item := range pending {
+		// uploads are slow, so the lock isn't held during them and new items can be added meanwhile
+		err := upload(item)
+		o.finish(item, err)
+	}
+}
+
+// Len returns the number of pending uploads
+func (o *Outbox) Len() int {
+	if o == nil {
+		return 0
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.items)
+}
+
+func (o *Outbox) finish(item *Item, uploadErr error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.inFlight, item.ID)
+	item.Attempts++
+	item.LastAttemptAt = time.Now().UTC()
+
+	if uploadErr == nil {
+		io2.OutputInfo("", "pending upload of %s to %s succeeded after %d attempts", item.Source, item.Uploader, item.Attempts)
+		o.remove(item)
+	} else {
+		item.LastError = uploadErr.Error()
+		pendingFor := time.Since(item.CreatedAt)
+		if pendingFor > o.cfg.AlertAfter {
+			io2.OutputError(
+				uploadErr,
+				"",
+				"upload of %s to %s of job '%s' is pending for %s, %d attempts failed",
+				item.Source,
+				item.Uploader,
+				item.Job,
+				pendingFor.Round(time.Second),
+				item.Attempts,
+			)
+		} else {
+			io2.OutputWarning("", "pending upload of %s to %s failed again: %v", item.Source, item.Uploader, uploadErr)
+		}
+	}
+
+	err := o.save()
+	if err != nil {
+		io2.OutputError(err, "", "failed to save outbox state")
+	}
+}
+
+// remove deletes the item and its spooled file if no other items refer to it
+func (o *Outbox) remove(removed *Item) {
+	items := make([]*Item, 0, len(o.items))
+	fileUsed := false
+	for _, item := range o.items {
+		if item == removed {
+			continue
+		}
+		if item.Path == removed.Path {
+			fileUsed = true
+		}
+		items = append(items, item)
+	}
+	o.items = items
+
+	if !fileUsed {
+		err := os.Remove(removed.Path)
+		if err != nil && !os.IsNotExist(err) {
+			io2.OutputError(err, "", "failed to delete spooled file %s", removed.Path)
+		}
+	}
+}
+
+// save writes the state to a temp file first, so a crash never leaves a truncated state
+func (o *Outbox) save() error {
+	data, err := json.MarshalIndent(state{Items: o.items}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := o.statePath() + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, o.statePath())
+}
+
+func (o *Outbox) statePath() string {
+	return filepath.Join(o.cfg.Path, stateFileName)
+}
+
+// spool creates a hardlink of the file and falls back to copying it, e.g. if the outbox is on another device
+func spool(srcPath, targetPath string) error {
+	err := os.Link(srcPath, targetPath)
+	if err == nil {
+		return nil
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		fs.RmFile(targetPath)
+		return err
+	}
+
+	return dst.Close()
+}
@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var errTest = errors.New("connection reset")
+
+func newTestOutbox(t *testing.T, path string) *Outbox {
+	t.Helper()
+
+	o, err := New(&Config{Path: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return o
+}
+
+func writeTestFile(t *testing.T, name string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(filePath, []byte("dump"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filePath
+}
+
+func spooledFiles(t *testing.T, o *Outbox) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(filepath.Join(o.cfg.Path, spoolFolder))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	path := t.TempDir()
+	dbPath := writeTestFile(t, "db.sql.gz")
+	filesPath := writeTestFile(t, "files.tar.gz")
+
+	o := newTestOutbox(t, path)
+	err := o.Add("daily", "s3", dbPath, "daily/db.sql.gz", errTest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = o.Add("files", "s3", filesPath, "files/files.tar.gz", errTest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded := newTestOutbox(t, path)
+	if loaded.Len() != 2 {
+		t.Fatalf("expected 2 pending uploads, got %d", loaded.Len())
+	}
+	for i, item := range loaded.items {
+		expected := *o.items[i]
+		// times lose the monotonic clock reading in JSON
+		if !item.CreatedAt.Equal(expected.CreatedAt) {
+			t.Errorf("expected creation time %s, got %s", expected.CreatedAt, item.CreatedAt)
+		}
+		item.CreatedAt = expected.CreatedAt
+		if *item != expected {
+			t.Errorf("expected item %+v, got %+v", expected, *item)
+		}
+	}
+
+	// the pending upload of a missing spooled file can't succeed anymore
+	err = os.Remove(o.items[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded = newTestOutbox(t, path)
+	if loaded.Len() != 1 || loaded.items[0].Source != filesPath {
+		t.Errorf("expected only the upload of %s to stay, got %+v", filesPath, loaded.items)
+	}
+}
+
+func TestSharedSpooledFileIsRemovedAfterAllUploads(t *testing.T) {
+	localPath := writeTestFile(t, "db.sql.gz")
+	o := newTestOutbox(t, t.TempDir())
+
+	for _, uploader := range []string{"s3", "sftp"} {
+		err := o.Add("daily", uploader, localPath, "daily/db.sql.gz", errTest)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if files := spooledFiles(t, o); len(files) != 1 {
+		t.Fatalf("expected the file to be spooled once, got %v", files)
+	}
+	if o.items[0].Path != o.items[1].Path {
+		t.Errorf("expected items to share the spooled file, got %s and %s", o.items[0].Path, o.items[1].Path)
+	}
+
+	// the local file can be deleted once it's spooled
+	err := os.Remove(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploads := map[string]int{}
+	o.Flush(func(item *Item) error {
+		uploads[item.Uploader]++
+		if item.Uploader == "sftp" {
+			return errTest
+		}
+
+		return nil
+	})
+
+	if o.Len() != 1 || o.items[0].Uploader != "sftp" {
+		t.Fatalf("expected the failed upload to stay in the outbox, got %+v", o.items)
+	}
+	if o.items[0].Attempts != 2 || o.items[0].LastError != errTest.Error() {
+		t.Errorf("expected the failed attempt to be recorded, got %+v", o.items[0])
+	}
+	if files := spooledFiles(t, o); len(files) != 1 {
+		t.Fatalf("expected the spooled file to be kept for the pending upload, got %v", files)
+	}
+
+	o.Flush(func(item *Item) error {
+		uploads[item.Uploader]++
+		return nil
+	})
+
+	if o.Len() != 0 {
+		t.Errorf("expected no pending uploads, got %+v", o.items)
+	}
+	if files := spooledFiles(t, o); len(files) != 0 {
+		t.Errorf("expected the spooled file to be removed, got %v", files)
+	}
+	if uploads["s3"] != 1 || uploads["sftp"] != 2 {
+		t.Errorf("unexpected uploads %v", uploads)
+	}
+
+	// the state is saved after every upload
+	if loaded := newTestOutbox(t, o.cfg.Path); loaded.Len() != 0 {
+		t.Errorf("expected no pending uploads in the saved state, got %d", loaded.Len())
+	}
+}
+
+func TestAddUpdatesRepeatedlyFailedUpload(t *testing.T) {
+	localPath := writeTestFile(t, "db.sql.gz")
+	o := newTestOutbox(t, t.TempDir())
+
+	err := o.Add("daily", "s3", localPath, "daily/db.sql.gz", errTest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = o.Add("daily", "s3", localPath, "daily/db.sql.gz", errors.New("access denied"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if o.Len() != 1 || o.items[0].LastError != "access denied" {
+		t.Errorf("expected a single item with the last error, got %+v", o.items)
+	}
+	if files := spooledFiles(t, o); len(files) != 1 {
+		t.Errorf("expected the file to be spooled once, got %v", files)
+	}
+}
+
+func TestDisabledOutbox(t *testing.T) {
+	var o *Outbox
+
+	err := o.Add("daily", "s3", "db.sql.gz", "daily/db.sql.gz", errTest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	o.Flush(func(item *Item) error {
+		t.Error("expected no uploads")
+		return nil
+	})
+
+	if o.Len() != 0 {
+		t.Errorf("expected no pending uploads, got %d", o.Len())
+	}
+}
@@ -0,0 +1,140 @@
+package pathtpl
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+// datePlaceholders change with time, so folders with them can't be used as a stable prefix of a job's files
+var datePlaceholders = []string{"{yyyy}", "{mm}", "{dd}", "{hh}"}
+
+// jobPlaceholders are filled from the job, so they're known only when a job uploads its files
+var jobPlaceholders = []string{"{job}", "{kind}", "{db}"}
+
+// placeholderRegex matches anything looking like a placeholder, so misspelled ones can be reported
+var placeholderRegex = regexp.MustCompile(`\{[^{}/]*\}`)
+
+// Validate rejects templates with unknown placeholders, which would be kept in the path as is,
+// and templates with . or .. folders, which could point outside the uploader's folder
+func Validate(tpl string) error {
+	for _, placeholder := range placeholderRegex.FindAllString(tpl, -1) {
+		if !isKnownPlaceholder(placeholder) {
+			return fmt.Errorf("unknown placeholder %s in %q", placeholder, tpl)
+		}
+	}
+
+	for _, folder := range strings.Split(tpl, "/") {
+		if folder == "." || folder == ".." {
+			return fmt.Errorf("relative folder %q is not allowed in %q", folder, tpl)
+		}
+	}
+
+	return nil
+}
+
+func isKnownPlaceholder(placeholder string) bool {
+	for _, placeholders := range [][]string{datePlaceholders, jobPlaceholders, {"{host}"}} {
+		for _, known := range placeholders {
+			if placeholder == known {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// HasJobPlaceholders tells if the template uses values of a job, such templates can't be rendered
+// in settings which don't belong to a job e.g. folders of uploaders
+func HasJobPlaceholders(tpl string) bool {
+	for _, placeholder := range jobPlaceholders {
+		if strings.Contains(tpl, placeholder) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Vars are values for placeholders of a path template
+type Vars struct {
+	Job  string
+	Kind string
+	DB   string
+	Time time.Time
+}
+
+// Render replaces {job}, {kind}, {db}, {host}, {yyyy}, {mm}, {dd} and {hh} placeholders in the template,
+// e.g. backups/{job}/{yyyy}/{mm} gives backups/daily/2021/03
+func Render(tpl string, vars Vars) string {
+	if tpl == "" {
+		return ""
+	}
+
+	now := vars.Time
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+
+	r := strings.NewReplacer(
+		"{job}", sanitize(vars.Job),
+		"{kind}", sanitize(vars.Kind),
+		"{db}", sanitize(vars.DB),
+		"{host}", sanitize(hostName()),
+		"{yyyy}", now.Format("2006"),
+		"{mm}", now.Format("01"),
+		"{dd}", now.Format("02"),
+		"{hh}", now.Format("15"),
+	)
+
+	return r.Replace(tpl)
+}
+
+// StaticPrefix renders the folders of the template which precede the first date placeholder,
+// e.g. backups/{job}/{yyyy}/{mm} gives backups/daily/, so all files of a job can be listed by it
+func StaticPrefix(tpl string, vars Vars) string {
+	cut := len(tpl)
+	for _, placeholder := range datePlaceholders {
+		if i := strings.Index(tpl, placeholder); i >= 0 && i < cut {
+			cut = i
+		}
+	}
+
+	static := tpl[:cut]
+	lastSlash := strings.LastIndex(static, "/")
+	if cut < len(tpl) {
+		// a folder partially consisting of a date placeholder is not static
+		static = static[:lastSlash+1]
+	}
+
+	prefix := Render(static, vars)
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return prefix
+	}
+
+	return prefix + "/"
+}
+
+func hostName() string {
+	name, err := os.Hostname()
+	if err != nil {
+		io2.OutputWarning("", "failed to read host name: %v", err)
+	}
+
+	return name
+}
+
+// sanitize makes sure that a value doesn't add path segments or point to a parent folder
+func sanitize(value string) string {
+	value = strings.NewReplacer("/", "_", `\`, "_").Replace(value)
+	if value == "." || value == ".." {
+		return strings.Repeat("_", len(value))
+	}
+
+	return value
+}
@@ -0,0 +1,92 @@
+package pathtpl
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	vars := Vars{Job: "daily", Kind: "mysql", DB: "shop", Time: time.Date(2021, 3, 7, 9, 30, 0, 0, time.UTC)}
+
+	testCases := []struct {
+		name         string
+		tpl          string
+		vars         Vars
+		expectedPath string
+	}{
+		{name: "empty template", tpl: "", vars: vars, expectedPath: ""},
+		{name: "job and date", tpl: "backups/{job}/{yyyy}/{mm}/{dd}/{hh}", vars: vars, expectedPath: "backups/daily/2021/03/07/09"},
+		{name: "kind and db", tpl: "{kind}/{db}", vars: vars, expectedPath: "mysql/shop"},
+		{
+			name:         "separators in values",
+			tpl:          "{job}/{db}",
+			vars:         Vars{Job: "a/b", DB: `c\d`, Time: vars.Time},
+			expectedPath: "a_b/c_d",
+		},
+		{
+			name:         "parent folder in values",
+			tpl:          "backups/{job}/{db}",
+			vars:         Vars{Job: "..", DB: ".", Time: vars.Time},
+			expectedPath: "backups/__/_",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if actualPath := Render(testCase.tpl, testCase.vars); actualPath != testCase.expectedPath {
+				t.Errorf("expected %q, got %q", testCase.expectedPath, actualPath)
+			}
+		})
+	}
+}
+
+func TestStaticPrefix(t *testing.T) {
+	vars := Vars{Job: "daily", DB: "shop"}
+
+	testCases := []struct {
+		tpl            string
+		expectedPrefix string
+	}{
+		{tpl: "", expectedPrefix: ""},
+		{tpl: "backups/{job}", expectedPrefix: "backups/daily/"},
+		{tpl: "backups/{job}/{yyyy}/{mm}", expectedPrefix: "backups/daily/"},
+		{tpl: "backups/{db}-{yyyy}/{mm}", expectedPrefix: "backups/"},
+		{tpl: "{yyyy}/{job}", expectedPrefix: ""},
+	}
+
+	for _, testCase := range testCases {
+		if actualPrefix := StaticPrefix(testCase.tpl, vars); actualPrefix != testCase.expectedPrefix {
+			t.Errorf("%q: expected %q, got %q", testCase.tpl, testCase.expectedPrefix, actualPrefix)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		tpl         string
+		expectedErr string
+	}{
+		{name: "empty template", tpl: ""},
+		{name: "all placeholders", tpl: "{host}/{job}/{kind}/{db}/{yyyy}/{mm}/{dd}/{hh}"},
+		{name: "folder with dots", tpl: "backups/v1..2/{job}"},
+		{name: "unknown placeholder", tpl: "backups/{jobs}/{yyyy}", expectedErr: "unknown placeholder {jobs}"},
+		{name: "placeholder in upper case", tpl: "backups/{YYYY}", expectedErr: "unknown placeholder {YYYY}"},
+		{name: "parent folder", tpl: "../other/{job}", expectedErr: `relative folder ".." is not allowed`},
+		{name: "parent folder in the middle", tpl: "backups/{job}/../../other", expectedErr: `relative folder ".." is not allowed`},
+		{name: "current folder", tpl: "backups/./{job}", expectedErr: `relative folder "." is not allowed`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := Validate(testCase.tpl)
+			if testCase.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if testCase.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), testCase.expectedErr)) {
+				t.Fatalf("expected error containing %q, got %v", testCase.expectedErr, err)
+			}
+		})
+	}
+}
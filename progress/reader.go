@@ -0,0 +1,164 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/breathbath/go_utils/v3/pkg/env"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+const defaultInterval = 30 * time.Second
+
+// Interval between progress reports, it's configured with PROGRESS_INTERVAL, 0 disables reports
+func Interval() time.Duration {
+	raw := env.ReadEnv("PROGRESS_INTERVAL", "")
+	if raw == "" {
+		return defaultInterval
+	}
+
+	interval, err := time.ParseDuration(raw)
+	if err != nil {
+		io2.OutputWarning("", "invalid PROGRESS_INTERVAL %q, will use %s: %v", raw, defaultInterval, err)
+		return defaultInterval
+	}
+
+	return interval
+}
+
+// Reader periodically logs how much of the wrapped reader is consumed, the rate and the estimated time left,
+// it doesn't close the wrapped reader, but Close must be called to stop reporting if the reader isn't read till the end
+type Reader struct {
+	// read goes first to be aligned for atomic operations on 32 bit platforms
+	read    int64
+	r       io.Reader
+	name    string
+	total   int64
+	started time.Time
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewReader wraps r which provides total bytes, a negative total means an unknown size,
+// in this case the size is taken from r if it's a file
+func NewReader(name string, r io.Reader, total int64) *Reader {
+	if total < 0 {
+		if st, ok := r.(interface{ Stat() (os.FileInfo, error) }); ok {
+			if info, err := st.Stat(); err == nil && info.Mode().IsRegular() {
+				total = info.Size()
+			}
+		}
+	}
+
+	pr := &Reader{
+		r:       r,
+		name:    name,
+		total:   total,
+		started: time.Now(),
+		done:    make(chan struct{}),
+	}
+
+	if interval := Interval(); interval > 0 {
+		go pr.report(interval)
+	}
+
+	return pr
+}
+
+func (pr *Reader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	atomic.AddInt64(&pr.read, int64(n))
+	if err != nil {
+		pr.stop()
+	}
+
+	return n, err
+}
+
+// Close stops reporting
+func (pr *Reader) Close() error {
+	pr.stop()
+	return nil
+}
+
+// BytesRead returns the number of bytes consumed so far
+func (pr *Reader) BytesRead() int64 {
+	return atomic.LoadInt64(&pr.read)
+}
+
+func (pr *Reader) stop() {
+	pr.once.Do(func() {
+		close(pr.done)
+	})
+}
+
+func (pr *Reader) report(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastRead := int64(0)
+	for {
+		select {
+		case <-pr.done:
+			return
+		case <-ticker.C:
+		}
+
+		read := pr.BytesRead()
+		if read == lastRead {
+			io2.OutputWarning("", "upload of %s stalled: no data sent in the last %s, %s sent so far", pr.name, interval, FormatBytes(read))
+			continue
+		}
+
+		rate := float64(read-lastRead) / interval.Seconds()
+		lastRead = read
+
+		if pr.total <= 0 {
+			io2.OutputInfo("", "upload of %s: %s sent, %s/s", pr.name, FormatBytes(read), FormatBytes(int64(rate)))
+			continue
+		}
+
+		io2.OutputInfo(
+			"",
+			"upload of %s: %s of %s (%.1f%%), %s/s, ETA %s",
+			pr.name,
+			FormatBytes(read),
+			FormatBytes(pr.total),
+			float64(read)*100/float64(pr.total),
+			FormatBytes(int64(rate)),
+			pr.eta(read),
+		)
+	}
+}
+
+// eta is based on the average rate since the start, so short slowdowns don't make it jump
+func (pr *Reader) eta(read int64) time.Duration {
+	elapsed := time.Since(pr.started)
+	if read <= 0 || read >= pr.total {
+		return 0
+	}
+
+	left := time.Duration(float64(elapsed) * float64(pr.total-read) / float64(read))
+
+	return left.Round(time.Second)
+}
+
+// FormatBytes gives a human readable size like 1.5 MB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
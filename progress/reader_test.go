@@ -0,0 +1,134 @@
+package progress
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReaderCountsBytesAndStopsAtEOF(t *testing.T) {
+	t.Setenv("PROGRESS_INTERVAL", "0")
+
+	content := bytes.Repeat([]byte("a"), 10000)
+	pr := NewReader("db.sql.gz", bytes.NewReader(content), int64(len(content)))
+
+	copied, err := io.Copy(io.Discard, pr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if copied != int64(len(content)) || pr.BytesRead() != copied {
+		t.Errorf("expected %d bytes read, got %d, reader counted %d", len(content), copied, pr.BytesRead())
+	}
+
+	select {
+	case <-pr.done:
+	default:
+		t.Error("expected reporting to stop at EOF")
+	}
+
+	// closing a stopped reader is allowed
+	err = pr.Close()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewReaderTakesSizeFromFile(t *testing.T) {
+	t.Setenv("PROGRESS_INTERVAL", "0")
+
+	filePath := filepath.Join(t.TempDir(), "db.sql.gz")
+	err := os.WriteFile(filePath, make([]byte, 1234), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	testCases := []struct {
+		name          string
+		r             io.Reader
+		total         int64
+		expectedTotal int64
+	}{
+		{name: "known size", r: f, total: 10, expectedTotal: 10},
+		{name: "file of unknown size", r: f, total: -1, expectedTotal: 1234},
+		{name: "stream of unknown size", r: bytes.NewReader(nil), total: -1, expectedTotal: -1},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			pr := NewReader("db.sql.gz", testCase.r, testCase.total)
+			defer pr.Close()
+
+			if pr.total != testCase.expectedTotal {
+				t.Errorf("expected total %d, got %d", testCase.expectedTotal, pr.total)
+			}
+		})
+	}
+}
+
+func TestInterval(t *testing.T) {
+	testCases := []struct {
+		value            string
+		expectedInterval time.Duration
+	}{
+		{value: "", expectedInterval: defaultInterval},
+		{value: "5s", expectedInterval: 5 * time.Second},
+		{value: "0", expectedInterval: 0},
+		{value: "often", expectedInterval: defaultInterval},
+	}
+
+	for _, testCase := range testCases {
+		t.Setenv("PROGRESS_INTERVAL", testCase.value)
+		if interval := Interval(); interval != testCase.expectedInterval {
+			t.Errorf("%q: expected %s, got %s", testCase.value, testCase.expectedInterval, interval)
+		}
+	}
+}
+
+func TestETA(t *testing.T) {
+	pr := &Reader{total: 1000, started: time.Now().Add(-10 * time.Second)}
+
+	testCases := []struct {
+		read        int64
+		expectedETA time.Duration
+	}{
+		{read: 0, expectedETA: 0},
+		{read: 250, expectedETA: 30 * time.Second},
+		{read: 500, expectedETA: 10 * time.Second},
+		{read: 1000, expectedETA: 0},
+	}
+
+	for _, testCase := range testCases {
+		if eta := pr.eta(testCase.read); eta != testCase.expectedETA {
+			t.Errorf("%d bytes read: expected ETA %s, got %s", testCase.read, testCase.expectedETA, eta)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	testCases := []struct {
+		n        int64
+		expected string
+	}{
+		{n: 0, expected: "0 B"},
+		{n: 1023, expected: "1023 B"},
+		{n: 1024, expected: "1.0 KB"},
+		{n: 1536, expected: "1.5 KB"},
+		{n: 5 * 1024 * 1024, expected: "5.0 MB"},
+		{n: 3 * 1024 * 1024 * 1024 * 1024, expected: "3.0 TB"},
+	}
+
+	for _, testCase := range testCases {
+		if formatted := FormatBytes(testCase.n); formatted != testCase.expected {
+			t.Errorf("%d: expected %q, got %q", testCase.n, testCase.expected, formatted)
+		}
+	}
+}
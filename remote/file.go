@@ -0,0 +1,19 @@
+package remote
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by remote storages when a requested file doesn't exist
+var ErrNotFound = errors.New("remote file not found")
+
+// File describes a file stored remotely, Name is relative to the root folder of the storage
+type File struct {
+	Name     string
+	Path     string
+	Size     int64
+	MD5      string
+	SHA256   string
+	Modified time.Time
+}
@@ -0,0 +1,97 @@
+package retention
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/volume"
+)
+
+// TimestampLayout is used by executors in names of the generated files
+const TimestampLayout = "02.01.2006.15.04.05.000"
+
+const seriesPlaceholder = "*"
+
+var timestampRgx = regexp.MustCompile(`\d{2}\.\d{2}\.\d{4}\.\d{2}\.\d{2}\.\d{2}\.\d{3}`)
+
+// Artifact is a single backup which can consist of several files, e.g. volumes and their manifest
+type Artifact struct {
+	Name string
+	// Series is the artifact file name with the timestamp replaced by a placeholder,
+	// artifacts of the same series are produced by the same job from the same source,
+	// folders are not part of the series since they can contain dates as well
+	Series string
+	Time   time.Time
+	Size   int64
+	Files  []string
+}
+
+type File struct {
+	Name string
+	Size int64
+}
+
+// ParseName extracts the series and the creation time from a file name like 02.01.2006.15.04.05.000_db.sql.gz
+func ParseName(name string) (series string, createdAt time.Time, ok bool) {
+	baseName, _ := volume.BaseName(name)
+	fileName := path.Base(baseName)
+
+	loc := timestampRgx.FindStringIndex(fileName)
+	if loc == nil {
+		return "", time.Time{}, false
+	}
+
+	createdAt, err := time.Parse(TimestampLayout, fileName[loc[0]:loc[1]])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	series = fileName[:loc[0]] + seriesPlaceholder + fileName[loc[1]:]
+
+	return series, createdAt, true
+}
+
+// Group combines files into artifacts of the given series, files without a timestamp in the name are ignored
+func Group(files []File, series string) []*Artifact {
+	artifacts := map[string]*Artifact{}
+	for _, file := range files {
+		fileSeries, createdAt, ok := ParseName(file.Name)
+		if !ok || fileSeries != series {
+			continue
+		}
+
+		baseName, _ := volume.BaseName(file.Name)
+		artifact, ok := artifacts[baseName]
+		if !ok {
+			artifact = &Artifact{
+				Name:   baseName,
+				Series: fileSeries,
+				Time:   createdAt,
+			}
+			artifacts[baseName] = artifact
+		}
+
+		artifact.Size += file.Size
+		artifact.Files = append(artifact.Files, file.Name)
+	}
+
+	res := make([]*Artifact, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		sort.Strings(artifact.Files)
+		// manifest goes first, so an artifact is never left with a manifest but without some of its volumes
+		sort.SliceStable(artifact.Files, func(i, j int) bool {
+			return strings.HasSuffix(artifact.Files[i], volume.ManifestSuffix) &&
+				!strings.HasSuffix(artifact.Files[j], volume.ManifestSuffix)
+		})
+		res = append(res, artifact)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Time.Before(res[j].Time)
+	})
+
+	return res
+}
@@ -0,0 +1,179 @@
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const (
+	hoursInDay = 24
+	bytesInMb  = 1024 * 1024
+)
+
+// Policy decides which artifacts of a series are kept, an artifact is kept if any of the rules keeps it,
+// the newest artifact is never expired, a policy without rules keeps everything within the size limit
+type Policy struct {
+	// KeepLast keeps the N newest artifacts
+	KeepLast int `json:"keepLast"`
+	// MaxAge keeps artifacts which are younger than the given duration, e.g. 72h or 30d
+	MaxAgeRaw string        `json:"maxAge"`
+	MaxAge    time.Duration `json:"-"`
+	// Daily, Weekly and Monthly keep the newest artifact of each of the last N days, weeks and months
+	Daily   int `json:"daily"`
+	Weekly  int `json:"weekly"`
+	Monthly int `json:"monthly"`
+	// MaxTotalSizeMb deletes the oldest of the kept artifacts until their total size fits into the limit
+	MaxTotalSizeMb int `json:"maxTotalSizeMb"`
+	// DryRun only logs artifacts which would be deleted
+	DryRun bool `json:"dryRun"`
+}
+
+func (p *Policy) Validate() error {
+	fields := []*validation.FieldRules{
+		validation.Field(&p.KeepLast, validation.Min(0)),
+		validation.Field(&p.Daily, validation.Min(0)),
+		validation.Field(&p.Weekly, validation.Min(0)),
+		validation.Field(&p.Monthly, validation.Min(0)),
+		validation.Field(&p.MaxTotalSizeMb, validation.Min(0)),
+		validation.Field(&p.MaxAgeRaw, validation.By(func(value interface{}) error {
+			valStr := fmt.Sprint(value)
+			if valStr == "" {
+				return nil
+			}
+
+			_, err := ParseAge(valStr)
+
+			return err
+		})),
+	}
+
+	return validation.ValidateStruct(p, fields...)
+}
+
+// UnmarshalJSON reads the policy and converts MaxAgeRaw to MaxAge, invalid ages are reported by Validate
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	type rawPolicy Policy
+	err := json.Unmarshal(data, (*rawPolicy)(p))
+	if err != nil {
+		return err
+	}
+
+	if p.MaxAgeRaw != "" {
+		p.MaxAge, _ = ParseAge(p.MaxAgeRaw)
+	}
+
+	return nil
+}
+
+func (p *Policy) hasRules() bool {
+	return p.KeepLast > 0 || p.MaxAge > 0 || p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0
+}
+
+// ParseAge extends time.ParseDuration with days, e.g. 30d
+func ParseAge(raw string) (time.Duration, error) {
+	if strings.HasSuffix(raw, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(raw, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q: %v", raw, err)
+		}
+
+		return time.Duration(days) * hoursInDay * time.Hour, nil
+	}
+
+	return time.ParseDuration(raw)
+}
+
+// Expired returns artifacts which are not kept by any rule of the policy, artifacts should belong to the same series
+func (p *Policy) Expired(artifacts []*Artifact, now time.Time) []*Artifact {
+	sorted := make([]*Artifact, len(artifacts))
+	copy(sorted, artifacts)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	daily := newBuckets(p.Daily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	weekly := newBuckets(p.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+	monthly := newBuckets(p.Monthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+
+	expired := []*Artifact{}
+	kept := []*Artifact{}
+	for i, artifact := range sorted {
+		keep := i == 0 || i < p.KeepLast || !p.hasRules()
+		if p.MaxAge > 0 && now.Sub(artifact.Time) <= p.MaxAge {
+			keep = true
+		}
+
+		// all buckets should see each artifact, so no short circuit here
+		keptDaily := daily.keep(artifact.Time)
+		keptWeekly := weekly.keep(artifact.Time)
+		keptMonthly := monthly.keep(artifact.Time)
+
+		if !keep && !keptDaily && !keptWeekly && !keptMonthly {
+			expired = append(expired, artifact)
+			continue
+		}
+		kept = append(kept, artifact)
+	}
+
+	return append(expired, p.exceedingSize(kept)...)
+}
+
+// exceedingSize returns the oldest artifacts which don't fit into the total size limit, kept should be sorted from newest to oldest
+func (p *Policy) exceedingSize(kept []*Artifact) []*Artifact {
+	exceeding := []*Artifact{}
+	if p.MaxTotalSizeMb == 0 {
+		return exceeding
+	}
+
+	var totalSize int64
+	for _, artifact := range kept {
+		totalSize += artifact.Size
+	}
+
+	maxTotalSize := int64(p.MaxTotalSizeMb) * bytesInMb
+	for i := len(kept) - 1; i > 0 && totalSize > maxTotalSize; i-- {
+		exceeding = append(exceeding, kept[i])
+		totalSize -= kept[i].Size
+	}
+
+	return exceeding
+}
+
+// buckets keeps the first (newest) artifact in each of the limit latest time periods
+type buckets struct {
+	limit  int
+	keyFn  func(t time.Time) string
+	filled map[string]bool
+}
+
+func newBuckets(limit int, keyFn func(t time.Time) string) *buckets {
+	return &buckets{
+		limit:  limit,
+		keyFn:  keyFn,
+		filled: map[string]bool{},
+	}
+}
+
+func (b *buckets) keep(t time.Time) bool {
+	key := b.keyFn(t)
+	if b.filled[key] || len(b.filled) >= b.limit {
+		return false
+	}
+
+	b.filled[key] = true
+
+	return true
+}
@@ -0,0 +1,272 @@
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2022, 3, 15, 12, 0, 0, 0, time.UTC)
+
+func newArtifact(name string, createdAt time.Time, size int64) *Artifact {
+	return &Artifact{
+		Name:   name,
+		Series: "*_db.sql.gz",
+		Time:   createdAt,
+		Size:   size,
+		Files:  []string{name},
+	}
+}
+
+func ago(d time.Duration) time.Time {
+	return testNow.Add(-d)
+}
+
+func day(month time.Month, d, hour int) time.Time {
+	return time.Date(2022, month, d, hour, 0, 0, 0, time.UTC)
+}
+
+func names(artifacts []*Artifact) []string {
+	res := make([]string, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		res = append(res, artifact.Name)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+func TestPolicyExpired(t *testing.T) {
+	const kb = 1024
+
+	testCases := []struct {
+		name            string
+		policy          Policy
+		artifacts       []*Artifact
+		expectedExpired []string
+	}{
+		{
+			name:   "no rules keep everything",
+			policy: Policy{},
+			artifacts: []*Artifact{
+				newArtifact("a1", ago(time.Hour), 1),
+				newArtifact("a2", ago(48*time.Hour), 1),
+				newArtifact("a3", ago(300*24*time.Hour), 1),
+			},
+			expectedExpired: []string{},
+		},
+		{
+			name:   "keep last",
+			policy: Policy{KeepLast: 2},
+			artifacts: []*Artifact{
+				newArtifact("a3", ago(3*time.Hour), 1),
+				newArtifact("a1", ago(time.Hour), 1),
+				newArtifact("a4", ago(4*time.Hour), 1),
+				newArtifact("a2", ago(2*time.Hour), 1),
+			},
+			expectedExpired: []string{"a3", "a4"},
+		},
+		{
+			name:   "max age keeps artifacts at the boundary",
+			policy: Policy{MaxAge: 72 * time.Hour},
+			artifacts: []*Artifact{
+				newArtifact("a1", ago(time.Hour), 1),
+				newArtifact("a2", ago(72*time.Hour), 1),
+				newArtifact("a3", ago(73*time.Hour), 1),
+			},
+			expectedExpired: []string{"a3"},
+		},
+		{
+			name:   "keep last extends max age",
+			policy: Policy{KeepLast: 3, MaxAge: 24 * time.Hour},
+			artifacts: []*Artifact{
+				newArtifact("a1", ago(time.Hour), 1),
+				newArtifact("a2", ago(48*time.Hour), 1),
+				newArtifact("a3", ago(72*time.Hour), 1),
+				newArtifact("a4", ago(96*time.Hour), 1),
+			},
+			expectedExpired: []string{"a4"},
+		},
+		{
+			name:   "max age extends keep last",
+			policy: Policy{KeepLast: 1, MaxAge: 50 * time.Hour},
+			artifacts: []*Artifact{
+				newArtifact("a1", ago(time.Hour), 1),
+				newArtifact("a2", ago(2*time.Hour), 1),
+				newArtifact("a3", ago(48*time.Hour), 1),
+				newArtifact("a4", ago(96*time.Hour), 1),
+			},
+			expectedExpired: []string{"a4"},
+		},
+		{
+			name:   "newest is kept even if all rules expire it",
+			policy: Policy{MaxAge: time.Hour},
+			artifacts: []*Artifact{
+				newArtifact("a1", ago(10*24*time.Hour), 1),
+				newArtifact("a2", ago(20*24*time.Hour), 1),
+			},
+			expectedExpired: []string{"a2"},
+		},
+		{
+			// the newest artifact fills the daily, weekly and monthly buckets at once,
+			// the following artifacts of the same periods are kept only if another bucket is free
+			name:   "overlapping gfs buckets",
+			policy: Policy{Daily: 2, Weekly: 2, Monthly: 2},
+			artifacts: []*Artifact{
+				newArtifact("a1-tue-w11-mar", day(time.March, 15, 10), 1),
+				newArtifact("a2-tue-w11-mar", day(time.March, 15, 2), 1),
+				newArtifact("a3-mon-w11-mar", day(time.March, 14, 10), 1),
+				newArtifact("a4-sun-w10-mar", day(time.March, 13, 10), 1),
+				newArtifact("a5-mon-w10-mar", day(time.March, 7, 10), 1),
+				newArtifact("a6-mon-w09-feb", day(time.February, 28, 10), 1),
+				newArtifact("a7-tue-w05-feb", day(time.February, 1, 10), 1),
+				newArtifact("a8-mon-w05-jan", day(time.January, 31, 10), 1),
+			},
+			expectedExpired: []string{"a2-tue-w11-mar", "a5-mon-w10-mar", "a7-tue-w05-feb", "a8-mon-w05-jan"},
+		},
+		{
+			name:   "gfs combined with keep last",
+			policy: Policy{KeepLast: 2, Monthly: 2},
+			artifacts: []*Artifact{
+				newArtifact("a1", day(time.March, 15, 10), 1),
+				newArtifact("a2", day(time.March, 14, 10), 1),
+				newArtifact("a3", day(time.March, 13, 10), 1),
+				newArtifact("a4", day(time.February, 20, 10), 1),
+				newArtifact("a5", day(time.February, 10, 10), 1),
+			},
+			expectedExpired: []string{"a3", "a5"},
+		},
+		{
+			name:   "max total size deletes the oldest kept artifacts",
+			policy: Policy{MaxTotalSizeMb: 1},
+			artifacts: []*Artifact{
+				newArtifact("a1", ago(time.Hour), 600*kb),
+				newArtifact("a2", ago(2*time.Hour), 300*kb),
+				newArtifact("a3", ago(3*time.Hour), 300*kb),
+				newArtifact("a4", ago(4*time.Hour), 100*kb),
+			},
+			expectedExpired: []string{"a3", "a4"},
+		},
+		{
+			name:   "max total size keeps the newest artifact which exceeds the limit",
+			policy: Policy{MaxTotalSizeMb: 1},
+			artifacts: []*Artifact{
+				newArtifact("a1", ago(time.Hour), 2048*kb),
+				newArtifact("a2", ago(2*time.Hour), kb),
+			},
+			expectedExpired: []string{"a2"},
+		},
+		{
+			name:   "max total size applies to artifacts kept by rules",
+			policy: Policy{KeepLast: 3, MaxTotalSizeMb: 1},
+			artifacts: []*Artifact{
+				newArtifact("a1", ago(time.Hour), 400*kb),
+				newArtifact("a2", ago(2*time.Hour), 400*kb),
+				newArtifact("a3", ago(3*time.Hour), 400*kb),
+				newArtifact("a4", ago(4*time.Hour), kb),
+			},
+			expectedExpired: []string{"a3", "a4"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			expired := testCase.policy.Expired(testCase.artifacts, testNow)
+
+			actualExpired := names(expired)
+			if !reflect.DeepEqual(actualExpired, testCase.expectedExpired) {
+				t.Errorf("expected expired %v, got %v", testCase.expectedExpired, actualExpired)
+			}
+		})
+	}
+}
+
+func TestGroupIgnoresNamesWithoutTimestamp(t *testing.T) {
+	files := []File{
+		{Name: "15.03.2022.10.00.00.000_db.sql.gz", Size: 10},
+		{Name: "daily/14.03.2022.10.00.00.000_db.sql.gz", Size: 20},
+		{Name: "13.03.2022.10.00.00.000_db.sql.gz.001", Size: 5},
+		{Name: "13.03.2022.10.00.00.000_db.sql.gz.002", Size: 5},
+		{Name: "13.03.2022.10.00.00.000_db.sql.gz.manifest.json", Size: 1},
+		{Name: "12.03.2022.10.00.00.000_other.sql.gz", Size: 1},
+		{Name: "db.sql.gz", Size: 1},
+		{Name: "2022-03-11_db.sql.gz", Size: 1},
+		{Name: "11.03.2022_db.sql.gz", Size: 1},
+		{Name: "99.99.2022.10.00.00.000_db.sql.gz", Size: 1},
+	}
+
+	artifacts := Group(files, "*_db.sql.gz")
+
+	actual := make([]string, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		actual = append(actual, artifact.Name)
+	}
+	expected := []string{
+		"13.03.2022.10.00.00.000_db.sql.gz",
+		"daily/14.03.2022.10.00.00.000_db.sql.gz",
+		"15.03.2022.10.00.00.000_db.sql.gz",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected artifacts %v, got %v", expected, actual)
+	}
+
+	volumes := artifacts[0]
+	if volumes.Size != 11 {
+		t.Errorf("expected size of all volumes 11, got %d", volumes.Size)
+	}
+	expectedFiles := []string{
+		"13.03.2022.10.00.00.000_db.sql.gz.manifest.json",
+		"13.03.2022.10.00.00.000_db.sql.gz.001",
+		"13.03.2022.10.00.00.000_db.sql.gz.002",
+	}
+	if !reflect.DeepEqual(volumes.Files, expectedFiles) {
+		t.Errorf("expected the manifest before volumes %v, got %v", expectedFiles, volumes.Files)
+	}
+
+	expired := (&Policy{KeepLast: 1}).Expired(artifacts, testNow)
+	expectedExpired := []string{
+		"13.03.2022.10.00.00.000_db.sql.gz",
+		"daily/14.03.2022.10.00.00.000_db.sql.gz",
+	}
+	if actualExpired := names(expired); !reflect.DeepEqual(actualExpired, expectedExpired) {
+		t.Errorf("expected expired %v, got %v", expectedExpired, actualExpired)
+	}
+}
+
+func TestPolicyUnmarshalParsesMaxAge(t *testing.T) {
+	testCases := []struct {
+		raw         string
+		expected    time.Duration
+		expectedErr bool
+	}{
+		{raw: "30d", expected: 30 * 24 * time.Hour},
+		{raw: "72h", expected: 72 * time.Hour},
+		{raw: "", expected: 0},
+		{raw: "d", expectedErr: true},
+		{raw: "1w", expectedErr: true},
+	}
+
+	for _, testCase := range testCases {
+		p := new(Policy)
+		err := json.Unmarshal([]byte(fmt.Sprintf(`{"keepLast": 2, "maxAge": %q}`, testCase.raw)), p)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", testCase.raw, err)
+			continue
+		}
+		if p.KeepLast != 2 || p.MaxAge != testCase.expected {
+			t.Errorf("%q: expected max age %v, got %+v", testCase.raw, testCase.expected, p)
+		}
+
+		parsed := *p
+		err = p.Validate()
+		if (err != nil) != testCase.expectedErr {
+			t.Errorf("%q: unexpected validation error %v", testCase.raw, err)
+		}
+		if *p != parsed {
+			t.Errorf("%q: expected validation to keep the policy unchanged, got %+v", testCase.raw, p)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package retry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const (
+	DefaultAttempts     = 3
+	DefaultInitialDelay = 5 * time.Second
+	DefaultMaxDelay     = time.Minute
+)
+
+type Policy struct {
+	// Attempts is the total number of tries, 1 disables retries
+	Attempts        int           `json:"attempts"`
+	InitialDelayRaw string        `json:"initialDelay"`
+	InitialDelay    time.Duration `json:"-"`
+	MaxDelayRaw     string        `json:"maxDelay"`
+	MaxDelay        time.Duration `json:"-"`
+}
+
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Attempts:     DefaultAttempts,
+		InitialDelay: DefaultInitialDelay,
+		MaxDelay:     DefaultMaxDelay,
+	}
+}
+
+func (p *Policy) Validate() error {
+	fields := []*validation.FieldRules{
+		validation.Field(&p.Attempts, validation.Min(0)),
+		validation.Field(&p.InitialDelayRaw, validation.By(validateDelay)),
+		validation.Field(&p.MaxDelayRaw, validation.By(validateDelay)),
+	}
+
+	return validation.ValidateStruct(p, fields...)
+}
+
+// UnmarshalJSON reads the policy and converts raw delays to durations, invalid delays are reported by Validate
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	type rawPolicy Policy
+	err := json.Unmarshal(data, (*rawPolicy)(p))
+	if err != nil {
+		return err
+	}
+
+	p.InitialDelay = parseDelay(p.InitialDelayRaw)
+	p.MaxDelay = parseDelay(p.MaxDelayRaw)
+
+	return nil
+}
+
+func validateDelay(value interface{}) error {
+	raw := fmt.Sprint(value)
+	if raw == "" {
+		return nil
+	}
+
+	_, err := time.ParseDuration(raw)
+
+	return err
+}
+
+// parseDelay gives zero for empty or invalid delays, so Do falls back to the default one
+func parseDelay(raw string) time.Duration {
+	delay, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0
+	}
+
+	return delay
+}
+
+// Do calls fn until it succeeds, returns a permanent error or the attempts are exhausted,
+// delays between attempts grow exponentially with a random jitter
+func Do(name string, p *Policy, fn func() error) error {
+	if p == nil {
+		p = DefaultPolicy()
+	}
+
+	attempts := p.Attempts
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+
+	initialDelay := p.InitialDelay
+	if initialDelay <= 0 {
+		initialDelay = DefaultInitialDelay
+	}
+
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = fn()
+		if err == nil {
+			if attempt > 1 {
+				io2.OutputInfo("", "%s succeeded at attempt %d of %d", name, attempt, attempts)
+			}
+			return nil
+		}
+
+		if IsPermanent(err) {
+			io2.OutputWarning("", "%s failed at attempt %d of %d with a permanent error, won't retry: %v", name, attempt, attempts, err)
+			return err
+		}
+
+		if attempt == attempts {
+			break
+		}
+
+		delay := backoff(initialDelay, maxDelay, attempt)
+		io2.OutputWarning("", "%s failed at attempt %d of %d, will retry in %s: %v", name, attempt, attempts, delay, err)
+		time.Sleep(delay)
+	}
+
+	return fmt.Errorf("%s failed after %d attempts: %w", name, attempts, err)
+}
+
+// backoff doubles the delay with each attempt and picks a random value in the upper half of it ("equal jitter")
+func backoff(initialDelay, maxDelay time.Duration, attempt int) time.Duration {
+	delay := initialDelay
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	half := delay / 2
+	//nolint:gosec // jitter doesn't need a secure random source
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+type permanentError struct {
+	err error
+}
+
+func (pe *permanentError) Error() string {
+	return pe.err.Error()
+}
+
+func (pe *permanentError) Unwrap() error {
+	return pe.err
+}
+
+// Permanent marks errors which won't disappear if the operation is repeated
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// IsTransientStatus tells if a request with the given response code might succeed when repeated
+func IsTransientStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported, http.StatusInsufficientStorage:
+		return false
+	}
+
+	return statusCode >= http.StatusInternalServerError
+}
+
+// ClassifyStatus marks err as permanent unless the response code is transient
+func ClassifyStatus(statusCode int, err error) error {
+	if err == nil || IsTransientStatus(statusCode) {
+		return err
+	}
+
+	return Permanent(err)
+}
@@ -0,0 +1,181 @@
+package retry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var errTest = errors.New("connection reset")
+
+func testPolicy() *Policy {
+	return &Policy{Attempts: 3, InitialDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+}
+
+func TestDo(t *testing.T) {
+	testCases := []struct {
+		name              string
+		errs              []error
+		expectedCalls     int
+		expectedErr       bool
+		expectedPermanent bool
+	}{
+		{
+			name:          "success",
+			errs:          []error{nil},
+			expectedCalls: 1,
+		},
+		{
+			name:          "transient errors are retried",
+			errs:          []error{errTest, errTest, nil},
+			expectedCalls: 3,
+		},
+		{
+			name:          "attempts are exhausted",
+			errs:          []error{errTest, errTest, errTest, nil},
+			expectedCalls: 3,
+			expectedErr:   true,
+		},
+		{
+			name:              "permanent errors are not retried",
+			errs:              []error{Permanent(errTest), nil},
+			expectedCalls:     1,
+			expectedErr:       true,
+			expectedPermanent: true,
+		},
+		{
+			name:              "permanent error after a transient one",
+			errs:              []error{errTest, Permanent(errTest), nil},
+			expectedCalls:     2,
+			expectedErr:       true,
+			expectedPermanent: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			calls := 0
+			err := Do("upload", testPolicy(), func() error {
+				err := testCase.errs[calls]
+				calls++
+				return err
+			})
+
+			if calls != testCase.expectedCalls {
+				t.Errorf("expected %d calls, got %d", testCase.expectedCalls, calls)
+			}
+			if (err != nil) != testCase.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil {
+				return
+			}
+			if !errors.Is(err, errTest) {
+				t.Errorf("expected the error to wrap the last error, got %v", err)
+			}
+			if IsPermanent(err) != testCase.expectedPermanent {
+				t.Errorf("expected permanent %v, got %v", testCase.expectedPermanent, err)
+			}
+		})
+	}
+}
+
+func TestBackoffBounds(t *testing.T) {
+	const (
+		initialDelay = time.Second
+		maxDelay     = 10 * time.Second
+	)
+
+	expectedDelays := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, maxDelay, maxDelay}
+
+	for i, expectedDelay := range expectedDelays {
+		attempt := i + 1
+		for j := 0; j < 100; j++ {
+			delay := backoff(initialDelay, maxDelay, attempt)
+			if delay < expectedDelay/2 || delay > expectedDelay {
+				t.Fatalf("attempt %d: expected a delay between %s and %s, got %s", attempt, expectedDelay/2, expectedDelay, delay)
+			}
+		}
+	}
+}
+
+func TestClassifyStatus(t *testing.T) {
+	testCases := []struct {
+		statusCode        int
+		expectedPermanent bool
+	}{
+		{statusCode: http.StatusRequestTimeout, expectedPermanent: false},
+		{statusCode: http.StatusTooManyRequests, expectedPermanent: false},
+		{statusCode: http.StatusInternalServerError, expectedPermanent: false},
+		{statusCode: http.StatusServiceUnavailable, expectedPermanent: false},
+		{statusCode: http.StatusBadRequest, expectedPermanent: true},
+		{statusCode: http.StatusForbidden, expectedPermanent: true},
+		{statusCode: http.StatusNotFound, expectedPermanent: true},
+		{statusCode: http.StatusNotImplemented, expectedPermanent: true},
+		{statusCode: http.StatusInsufficientStorage, expectedPermanent: true},
+	}
+
+	for _, testCase := range testCases {
+		err := ClassifyStatus(testCase.statusCode, errTest)
+		if IsPermanent(err) != testCase.expectedPermanent {
+			t.Errorf("%d: expected permanent %v", testCase.statusCode, testCase.expectedPermanent)
+		}
+		if !errors.Is(err, errTest) {
+			t.Errorf("%d: expected the original error to be wrapped, got %v", testCase.statusCode, err)
+		}
+	}
+
+	if ClassifyStatus(http.StatusForbidden, nil) != nil {
+		t.Error("expected no error for a nil error")
+	}
+}
+
+func TestPolicyUnmarshalParsesDelays(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		settings             string
+		expectedInitialDelay time.Duration
+		expectedMaxDelay     time.Duration
+		expectedErr          bool
+	}{
+		{
+			name:                 "delays",
+			settings:             `{"attempts": 5, "initialDelay": "10s", "maxDelay": "5m"}`,
+			expectedInitialDelay: 10 * time.Second,
+			expectedMaxDelay:     5 * time.Minute,
+		},
+		{
+			name:     "defaults",
+			settings: `{"attempts": 5}`,
+		},
+		{
+			name:        "invalid delay",
+			settings:    `{"attempts": 5, "initialDelay": "10 seconds"}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := new(Policy)
+			err := json.Unmarshal([]byte(testCase.settings), p)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.Attempts != 5 || p.InitialDelay != testCase.expectedInitialDelay || p.MaxDelay != testCase.expectedMaxDelay {
+				t.Errorf("unexpected policy %+v", p)
+			}
+
+			parsed := *p
+			err = p.Validate()
+			if (err != nil) != testCase.expectedErr {
+				t.Errorf("unexpected validation error %v", err)
+			}
+			if *p != parsed {
+				t.Errorf("expected validation to keep the policy unchanged, got %+v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/progress"
+	"github.com/breathbath/dumper/retry"
+	"github.com/breathbath/dumper/throttle"
+	"github.com/breathbath/go_utils/v3/pkg/env"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const S3Uploader = "s3"
+
+const (
+	defaultRegion     = "us-east-1"
+	defaultPartSizeMb = 16
+	minPartSizeMb     = 5
+	bytesInMb         = 1024 * 1024
+)
+
+type UploadConfig struct {
+	Endpoint         string        `json:"endpoint"`
+	Region           string        `json:"region"`
+	Bucket           string        `json:"bucket"`
+	AccessKey        string        `json:"accessKey"`
+	SecretKey        string        `json:"secretKey"`
+	SessionToken     string        `json:"sessionToken"`
+	RemoteFolder     string        `json:"folder"`
+	PathStyle        bool          `json:"pathStyle"`
+	StorageClass     string        `json:"storageClass"`
+	SSE              string        `json:"sse"`
+	SSEKMSKeyID      string        `json:"sseKmsKeyId"`
+	PartSizeMb       int           `json:"partSizeMb"`
+	UploadTimeoutRaw string        `json:"timeout"`
+	UploadTimeout    time.Duration `json:"-"`
+	RateLimit        string        `json:"rateLimit"`
+}
+
+func (mc *UploadConfig) Validate() error {
+	fields := []*validation.FieldRules{
+		validation.Field(&mc.Bucket, validation.Required),
+		validation.Field(&mc.AccessKey, validation.Required),
+		validation.Field(&mc.SecretKey, validation.Required),
+		validation.Field(&mc.Endpoint, validation.By(func(value interface{}) error {
+			valStr := fmt.Sprint(value)
+			if valStr == "" {
+				return nil
+			}
+			u, err := url.Parse(valStr)
+			if err != nil {
+				return err
+			}
+			if u.Scheme == "" || u.Host == "" {
+				return errors.New("should be an absolute URL like https://s3.example.com")
+			}
+
+			return nil
+		})),
+		validation.Field(&mc.SSE, validation.In(sseAES256, "aws:kms")),
+		validation.Field(&mc.StorageClass, validation.In(
+			"STANDARD",
+			"REDUCED_REDUNDANCY",
+			"STANDARD_IA",
+			"ONEZONE_IA",
+			"INTELLIGENT_TIERING",
+			"GLACIER",
+			"GLACIER_IR",
+			"DEEP_ARCHIVE",
+		)),
+		validation.Field(&mc.PartSizeMb, validation.Min(minPartSizeMb)),
+		validation.Field(&mc.RateLimit, validation.By(func(value interface{}) error {
+			_, err := throttle.ParseSchedule(fmt.Sprint(value))
+			return err
+		})),
+		validation.Field(&mc.UploadTimeoutRaw, config.DurationRule),
+	}
+
+	return validation.ValidateStruct(mc, fields...)
+}
+
+func NewConfigFromEnvs() *UploadConfig {
+	cfg := &UploadConfig{}
+	cfg.Endpoint = env.ReadEnv("S3_ENDPOINT", "")
+	cfg.Region = env.ReadEnv("S3_REGION", defaultRegion)
+	cfg.Bucket = env.ReadEnv("S3_BUCKET", "")
+	cfg.AccessKey = env.ReadEnv("S3_ACCESS_KEY", "")
+	cfg.SecretKey = env.ReadEnv("S3_SECRET_KEY", "")
+	cfg.SessionToken = env.ReadEnv("S3_SESSION_TOKEN", "")
+	cfg.RemoteFolder = env.ReadEnv("S3_FOLDER", "")
+	cfg.PathStyle = env.ReadEnvBool("S3_PATH_STYLE", false)
+	cfg.StorageClass = env.ReadEnv("S3_STORAGE_CLASS", "")
+	cfg.SSE = env.ReadEnv("S3_SSE", "")
+	cfg.SSEKMSKeyID = env.ReadEnv("S3_SSE_KMS_KEY_ID", "")
+
+	cfg.PartSizeMb = defaultPartSizeMb
+	partSizeRaw := env.ReadEnv("S3_PART_SIZE_MB", "")
+	if partSizeRaw != "" {
+		partSize, err := strconv.Atoi(partSizeRaw)
+		if err == nil {
+			cfg.PartSizeMb = partSize
+		}
+	}
+
+	cfg.RateLimit = env.ReadEnv("S3_RATE_LIMIT", "")
+	cfg.UploadTimeoutRaw, cfg.UploadTimeout = config.ReadDurationEnv("S3_UPLOADER_TIMEOUT", 0)
+
+	return cfg
+}
+
+func NewConfigFromSettings(settings []byte) (*UploadConfig, error) {
+	cfg := &UploadConfig{
+		Region:     defaultRegion,
+		PartSizeMb: defaultPartSizeMb,
+	}
+	err := config.ParseUploaderSettings(
+		S3Uploader, settings, cfg,
+		&cfg.Endpoint, &cfg.Bucket, &cfg.AccessKey, &cfg.SecretKey, &cfg.SessionToken, &cfg.RemoteFolder, &cfg.SSEKMSKeyID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.UploadTimeout = config.ParseDuration(cfg.UploadTimeoutRaw, 0)
+
+	return cfg, nil
+}
+
+type ResponseErr struct {
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+	RequestID string `xml:"RequestId"`
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type Service struct {
+	cfg     *UploadConfig
+	limiter *throttle.Limiter
+	signer  signer
+}
+
+func NewService(cfg *UploadConfig) *Service {
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	if cfg.PartSizeMb == 0 {
+		cfg.PartSizeMb = defaultPartSizeMb
+	}
+
+	return &Service{
+		cfg:     cfg,
+		limiter: throttle.NewLimiterFromSpec(S3Uploader, cfg.RateLimit),
+		signer: signer{
+			accessKey:    cfg.AccessKey,
+			secretKey:    cfg.SecretKey,
+			sessionToken: cfg.SessionToken,
+			region:       cfg.Region,
+		},
+	}
+}
+
+// see https://docs.aws.amazon.com/AmazonS3/latest/userguide/mpuoverview.html for details
+func (s *Service) Upload(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return s.UploadStream(remotePath, file)
+}
+
+// UploadStream reads data of unknown size in parts, small data is uploaded with a single request
+func (s *Service) UploadStream(name string, r io.Reader) error {
+	if err := s.cfg.Validate(); err != nil {
+		return retry.Permanent(err)
+	}
+
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	if s.cfg.UploadTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), s.cfg.UploadTimeout)
+		defer cancel()
+	}
+
+	key := join(s.cfg.RemoteFolder, name)
+
+	io2.OutputInfo("", "Will upload %s to s3 bucket %q, key %q", name, s.cfg.Bucket, key)
+
+	pr := progress.NewReader(name, r, -1)
+	defer pr.Close()
+
+	err := s.upload(ctx, key, throttle.NewReader(ctx, pr, s.limiter))
+	if err != nil {
+		return err
+	}
+
+	io2.OutputInfo("", "successfully uploaded %s to s3 bucket %q, key %q", name, s.cfg.Bucket, key)
+
+	return nil
+}
+
+func (s *Service) upload(ctx context.Context, key string, r io.Reader) error {
+	partSize := s.cfg.PartSizeMb * bytesInMb
+
+	firstPart, err := readPart(r, partSize)
+	if err != nil {
+		return err
+	}
+
+	if len(firstPart) < partSize {
+		return s.putObject(ctx, key, firstPart)
+	}
+
+	uploadID, err := s.createMultipartUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	parts, err := s.uploadParts(ctx, key, uploadID, firstPart, r, partSize)
+	if err != nil {
+		abortErr := s.abortMultipartUpload(context.Background(), key, uploadID)
+		if abortErr != nil {
+			io2.OutputWarning("", "failed to abort multipart upload %s for %q: %v", uploadID, key, abortErr)
+		}
+		return err
+	}
+
+	return s.completeMultipartUpload(ctx, key, uploadID, parts)
+}
+
+func (s *Service) uploadParts(
+	ctx context.Context,
+	key, uploadID string,
+	firstPart []byte,
+	r io.Reader,
+	partSize int,
+) ([]completedPart, error) {
+	parts := []completedPart{}
+	part := firstPart
+	for partNumber := 1; len(part) > 0; partNumber++ {
+		etag, err := s.uploadPart(ctx, key, uploadID, partNumber, part)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: etag})
+		io2.OutputInfo("", "uploaded part %d (%d bytes) of %q", partNumber, len(part), key)
+
+		if len(part) < partSize {
+			break
+		}
+
+		part, err = readPart(r, partSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return parts, nil
+}
+
+func (s *Service) putObject(ctx context.Context, key string, body []byte) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	s.setObjectHeaders(req)
+
+	_, _, err = s.do(req, body)
+
+	return err
+}
+
+func (s *Service) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	req, err := s.newRequest(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	s.setObjectHeaders(req)
+
+	_, respBody, err := s.do(req, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res := new(initiateMultipartUploadResult)
+	err = xml.Unmarshal(respBody, res)
+	if err != nil {
+		return "", fmt.Errorf("failed to read upload id from %q: %v", string(respBody), err)
+	}
+
+	return res.UploadID, nil
+}
+
+func (s *Service) uploadPart(ctx context.Context, key, uploadID string, partNumber int, body []byte) (string, error) {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(partNumber)},
+		"uploadId":   {uploadID},
+	}
+	req, err := s.newRequest(ctx, http.MethodPut, key, query, body)
+	if err != nil {
+		return "", err
+	}
+
+	resp, _, err := s.do(req, body)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Header.Get("ETag"), nil
+}
+
+func (s *Service) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	req, err := s.newRequest(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	_, respBody, err := s.do(req, body)
+	if err != nil {
+		return err
+	}
+
+	// S3 might report a failed completion with 200 OK, so the body should be checked as well
+	if bytes.Contains(respBody, []byte("<Error>")) {
+		return s.parseError(http.StatusOK, respBody)
+	}
+
+	return nil
+}
+
+func (s *Service) abortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = s.do(req, nil)
+
+	return err
+}
+
+func (s *Service) setObjectHeaders(req *http.Request) {
+	if s.cfg.StorageClass != "" {
+		req.Header.Set("X-Amz-Storage-Class", s.cfg.StorageClass)
+	}
+	if s.cfg.SSE != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", s.cfg.SSE)
+	}
+	if s.cfg.SSEKMSKeyID != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.cfg.SSEKMSKeyID)
+	}
+}
+
+func (s *Service) buildURL(key string, query url.Values) (*url.URL, error) {
+	endpoint := s.cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.cfg.Region)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	objectPath := key
+	if s.cfg.PathStyle {
+		objectPath = join(u.Path, join(s.cfg.Bucket, key))
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+
+	u.Path = "/" + strings.TrimPrefix(objectPath, "/")
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = query.Encode()
+
+	return u, nil
+}
+
+func (s *Service) newRequest(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Request, error) {
+	u, err := s.buildURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var bodyReader io.Reader = http.NoBody
+	if len(body) > 0 {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (s *Service) do(req *http.Request, body []byte) (resp *http.Response, respBody []byte, err error) {
+	s.signer.sign(req, hashHex(body), time.Now())
+
+	cl := &http.Client{}
+	resp, err = cl.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to call s3 api %s %q: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response body %v", err)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, nil, s.parseError(resp.StatusCode, respBody)
+	}
+
+	return resp, respBody, nil
+}
+
+func (s *Service) parseError(statusCode int, respBody []byte) error {
+	errResp := new(ResponseErr)
+	err := xml.Unmarshal(respBody, errResp)
+	if err != nil || errResp.Code == "" {
+		return retry.ClassifyStatus(statusCode, fmt.Errorf("wrong response code %d from s3: %s", statusCode, string(respBody)))
+	}
+
+	return retry.ClassifyStatus(
+		statusCode,
+		fmt.Errorf(
+			"wrong response code %d from s3, message %s[%s], request id %s",
+			statusCode,
+			errResp.Message,
+			errResp.Code,
+			errResp.RequestID,
+		),
+	)
+}
+
+func readPart(r io.Reader, partSize int) ([]byte, error) {
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
+
+func join(path0, path1 string) string {
+	if path0 == "" {
+		return strings.TrimPrefix(path1, "/")
+	}
+
+	return strings.TrimSuffix(path0, "/") + "/" + strings.TrimPrefix(path1, "/")
+}
@@ -0,0 +1,163 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signAlgorithm   = "AWS4-HMAC-SHA256"
+	signService     = "s3"
+	amzDateFormat   = "20060102T150405Z"
+	shortDateFormat = "20060102"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// see https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html for details
+type signer struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	region       string
+}
+
+func (s signer) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	shortDate := now.Format(shortDateFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signedHeaders, canonicalHeaders := s.canonicalHeaders(req)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req.URL),
+		s.canonicalQuery(req.URL),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{shortDate, s.region, signService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		signAlgorithm,
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), []byte(shortDate))
+	key = hmacSHA256(key, []byte(s.region))
+	key = hmacSHA256(key, []byte(signService))
+	key = hmacSHA256(key, []byte("aws4_request"))
+	signature := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+
+	req.Header.Set(
+		"Authorization",
+		fmt.Sprintf(
+			"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			signAlgorithm,
+			s.accessKey,
+			scope,
+			signedHeaders,
+			signature,
+		),
+	)
+}
+
+func (s signer) canonicalHeaders(req *http.Request) (signedHeaders, canonicalHeaders string) {
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for name, values := range req.Header {
+		lowerName := strings.ToLower(name)
+		if lowerName != "content-type" && lowerName != "content-md5" && !strings.HasPrefix(lowerName, "x-amz-") {
+			continue
+		}
+		trimmedValues := make([]string, 0, len(values))
+		for _, v := range values {
+			trimmedValues = append(trimmedValues, strings.Join(strings.Fields(v), " "))
+		}
+		headers[lowerName] = strings.Join(trimmedValues, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, name+":"+headers[name]+"\n")
+	}
+
+	return strings.Join(names, ";"), strings.Join(lines, "")
+}
+
+func (s signer) canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+
+	return uriEncode(u.Path, false)
+}
+
+func (s signer) canonicalQuery(u *url.URL) string {
+	values := u.Query()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vals := values[k]
+		sort.Strings(vals)
+		for _, v := range vals {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+func uriEncode(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hashHex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,243 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/remote"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+const (
+	listPageSize = 1000
+	sseAES256    = "AES256"
+	// sseCustomerKey marks objects encrypted with SSE-C, which are reported with a separate header
+	sseCustomerKey = "SSE-C"
+)
+
+type listBucketResult struct {
+	Contents              []object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+type object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+}
+
+func (s *Service) newContext() (context.Context, context.CancelFunc) {
+	if s.cfg.UploadTimeout > 0 {
+		return context.WithTimeout(context.Background(), s.cfg.UploadTimeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// List returns objects which keys relative to the remote folder start with prefix,
+// see https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html for details
+func (s *Service) List(prefix string) ([]*remote.File, error) {
+	if err := s.cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.newContext()
+	defer cancel()
+
+	keyPrefix := join(s.cfg.RemoteFolder, prefix)
+	files := []*remote.File{}
+	continuationToken := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {keyPrefix},
+			"max-keys":  {strconv.Itoa(listPageSize)},
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		req, err := s.newRequest(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		_, respBody, err := s.do(req, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects with prefix %q: %v", keyPrefix, err)
+		}
+
+		res := new(listBucketResult)
+		err = xml.Unmarshal(respBody, res)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse objects list %q: %v", string(respBody), err)
+		}
+
+		for i := range res.Contents {
+			obj := res.Contents[i]
+			if strings.HasSuffix(obj.Key, "/") {
+				// folder placeholders created by some clients
+				continue
+			}
+			// the list doesn't tell how each object is encrypted, e.g. by the default encryption of the bucket,
+			// so its etags aren't trusted as md5, Stat reports them
+			files = append(files, s.toRemoteFile(obj.Key, obj.Size, "", "", obj.LastModified))
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		continuationToken = res.NextContinuationToken
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	return files, nil
+}
+
+// Stat reads metadata of the object, see https://docs.aws.amazon.com/AmazonS3/latest/API/API_HeadObject.html for details
+func (s *Service) Stat(name string) (*remote.File, error) {
+	if err := s.cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.newContext()
+	defer cancel()
+
+	key := join(s.cfg.RemoteFolder, name)
+	resp, err := s.send(ctx, http.MethodHead, key)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", key, remote.ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to stat %s: wrong response code %d", key, resp.StatusCode)
+	}
+
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		modified = time.Time{}
+	}
+
+	sse := resp.Header.Get("X-Amz-Server-Side-Encryption")
+	if resp.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "" {
+		sse = sseCustomerKey
+	}
+
+	return s.toRemoteFile(key, resp.ContentLength, resp.Header.Get("ETag"), sse, modified), nil
+}
+
+// Download writes content of the object to w, see https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetObject.html for details
+func (s *Service) Download(name string, w io.Writer) error {
+	if err := s.cfg.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.newContext()
+	defer cancel()
+
+	key := join(s.cfg.RemoteFolder, name)
+
+	io2.OutputInfo("", "Will download %s from s3 bucket %q", key, s.cfg.Bucket)
+
+	resp, err := s.send(ctx, http.MethodGet, key)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", key, remote.ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return s.parseError(resp.StatusCode, respBody)
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", key, err)
+	}
+
+	io2.OutputInfo("", "downloaded %s, %d bytes", key, n)
+
+	return nil
+}
+
+// Delete removes the object, see https://docs.aws.amazon.com/AmazonS3/latest/API/API_DeleteObject.html for details
+func (s *Service) Delete(name string) error {
+	if err := s.cfg.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.newContext()
+	defer cancel()
+
+	key := join(s.cfg.RemoteFolder, name)
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = s.do(req, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %v", key, err)
+	}
+
+	io2.OutputInfo("", "deleted %s from s3 bucket %q", key, s.cfg.Bucket)
+
+	return nil
+}
+
+// send returns the response as is, so the caller can stream the body and handle response codes
+func (s *Service) send(ctx context.Context, method, key string) (*http.Response, error) {
+	req, err := s.newRequest(ctx, method, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.signer.sign(req, hashHex(nil), time.Now())
+
+	cl := &http.Client{}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call s3 api %s %q: %v", method, req.URL.Path, err)
+	}
+
+	return resp, nil
+}
+
+// toRemoteFile converts the key to a name relative to the remote folder, the etag is used as md5 only for single part
+// objects which are not encrypted or encrypted with AES256, for multipart uploads (their etags contain a dash)
+// and for objects encrypted with kms or customer keys it's not a digest of the content
+func (s *Service) toRemoteFile(key string, size int64, etag, sse string, modified time.Time) *remote.File {
+	name := strings.TrimPrefix(strings.TrimPrefix(key, strings.Trim(s.cfg.RemoteFolder, "/")), "/")
+
+	md5 := strings.Trim(etag, `"`)
+	if strings.Contains(md5, "-") || (sse != "" && sse != sseAES256) {
+		md5 = ""
+	}
+
+	return &remote.File{
+		Name:     name,
+		Path:     key,
+		Size:     size,
+		MD5:      md5,
+		Modified: modified,
+	}
+}
@@ -0,0 +1,285 @@
+package sftp
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/cli"
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/pathtpl"
+	"github.com/breathbath/dumper/retry"
+	"github.com/breathbath/dumper/throttle"
+	"github.com/breathbath/go_utils/v3/pkg/env"
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const SftpUploader = "sftp"
+
+const (
+	defaultPort         = "22"
+	partSuffix          = ".part"
+	hostKeyCheckingYes  = "yes"
+	hostKeyCheckingNew  = "accept-new"
+	hostKeyCheckingNone = "no"
+	bitsInByte          = 8
+	bitsInKbit          = 1000
+)
+
+type UploadConfig struct {
+	Host             string        `json:"host"`
+	Port             string        `json:"port"`
+	User             string        `json:"user"`
+	Password         string        `json:"password"`
+	KeyPath          string        `json:"keyPath"`
+	KnownHostsPath   string        `json:"knownHostsPath"`
+	HostKeyChecking  string        `json:"hostKeyChecking"`
+	RemoteFolder     string        `json:"folder"`
+	UploadTimeoutRaw string        `json:"timeout"`
+	UploadTimeout    time.Duration `json:"-"`
+	RateLimit        string        `json:"rateLimit"`
+}
+
+func (mc *UploadConfig) Validate() error {
+	fields := []*validation.FieldRules{
+		validation.Field(&mc.Host, validation.Required),
+		validation.Field(&mc.User, validation.Required),
+		validation.Field(&mc.Password, validation.By(func(value interface{}) error {
+			if fmt.Sprint(value) == "" && mc.KeyPath == "" {
+				return fmt.Errorf("either password or key path should be provided")
+			}
+
+			return nil
+		})),
+		validation.Field(&mc.KeyPath, validation.By(func(value interface{}) error {
+			valStr := fmt.Sprint(value)
+			if valStr != "" && !fs.FileExists(valStr) {
+				return fmt.Errorf("key file doesn't exist under '%s'", valStr)
+			}
+
+			return nil
+		})),
+		validation.Field(&mc.RemoteFolder, validation.By(func(value interface{}) error {
+			if pathtpl.HasJobPlaceholders(fmt.Sprint(value)) {
+				return fmt.Errorf("{job}, {kind} and {db} are not supported in the folder, use the path of the upload config")
+			}
+
+			return nil
+		})),
+		validation.Field(&mc.HostKeyChecking, validation.In(hostKeyCheckingYes, hostKeyCheckingNew, hostKeyCheckingNone)),
+		validation.Field(&mc.RateLimit, validation.By(func(value interface{}) error {
+			_, err := throttle.ParseSchedule(fmt.Sprint(value))
+			return err
+		})),
+		validation.Field(&mc.UploadTimeoutRaw, config.DurationRule),
+	}
+
+	return validation.ValidateStruct(mc, fields...)
+}
+
+func NewConfigFromEnvs() *UploadConfig {
+	cfg := &UploadConfig{}
+	cfg.Host = env.ReadEnv("SFTP_HOST", "")
+	cfg.Port = env.ReadEnv("SFTP_PORT", defaultPort)
+	cfg.User = env.ReadEnv("SFTP_USER", "")
+	cfg.Password = env.ReadEnv("SFTP_PASSWORD", "")
+	cfg.KeyPath = env.ReadEnv("SFTP_KEY_PATH", "")
+	cfg.KnownHostsPath = env.ReadEnv("SFTP_KNOWN_HOSTS_PATH", "")
+	cfg.HostKeyChecking = env.ReadEnv("SFTP_HOST_KEY_CHECKING", hostKeyCheckingYes)
+	cfg.RemoteFolder = env.ReadEnv("SFTP_FOLDER", "")
+	cfg.RateLimit = env.ReadEnv("SFTP_RATE_LIMIT", "")
+	cfg.UploadTimeoutRaw, cfg.UploadTimeout = config.ReadDurationEnv("SFTP_UPLOADER_TIMEOUT", 0)
+
+	return cfg
+}
+
+func NewConfigFromSettings(settings []byte) (*UploadConfig, error) {
+	cfg := &UploadConfig{
+		Port:            defaultPort,
+		HostKeyChecking: hostKeyCheckingYes,
+	}
+	err := config.ParseUploaderSettings(
+		SftpUploader, settings, cfg,
+		&cfg.Host, &cfg.Port, &cfg.User, &cfg.Password, &cfg.KeyPath, &cfg.KnownHostsPath,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.UploadTimeout = config.ParseDuration(cfg.UploadTimeoutRaw, 0)
+
+	return cfg, nil
+}
+
+type Service struct {
+	cfg     *UploadConfig
+	limiter *throttle.Limiter
+}
+
+func NewService(cfg *UploadConfig) *Service {
+	if cfg.Port == "" {
+		cfg.Port = defaultPort
+	}
+	if cfg.HostKeyChecking == "" {
+		cfg.HostKeyChecking = hostKeyCheckingYes
+	}
+
+	return &Service{
+		cfg:     cfg,
+		limiter: throttle.NewLimiterFromSpec(SftpUploader, cfg.RateLimit),
+	}
+}
+
+// Upload puts the file under a temp name first and renames it after the transfer is finished,
+// so readers on the remote host never see partially written files
+func (s *Service) Upload(localPath, remotePath string) error {
+	if err := s.cfg.Validate(); err != nil {
+		return retry.Permanent(err)
+	}
+
+	remotePath = join(pathtpl.Render(s.cfg.RemoteFolder, pathtpl.Vars{}), remotePath)
+	remoteFolder := path.Dir(remotePath)
+	remotePartPath := join(remoteFolder, "."+path.Base(remotePath)+partSuffix)
+
+	io2.OutputInfo("", "Will upload file %s to %s@%s:%s", localPath, s.cfg.User, s.cfg.Host, remotePath)
+
+	commands := make([]string, 0, 4)
+	commands = append(commands, s.mkdirCommands(remoteFolder)...)
+	commands = append(
+		commands,
+		fmt.Sprintf("put %s %s", quoteBatchArg(localPath), quoteBatchArg(remotePartPath)),
+		fmt.Sprintf("rename %s %s", quoteBatchArg(remotePartPath), quoteBatchArg(remotePath)),
+	)
+
+	err := s.runBatch(commands)
+	if err != nil {
+		cleanupErr := s.runBatch([]string{"-rm " + quoteBatchArg(remotePartPath)})
+		if cleanupErr != nil {
+			io2.OutputWarning("", "failed to remove partially uploaded file %s: %v", remotePartPath, cleanupErr)
+		}
+		return err
+	}
+
+	io2.OutputInfo("", "successfully uploaded file %s to %s@%s:%s", localPath, s.cfg.User, s.cfg.Host, remotePath)
+
+	return nil
+}
+
+// currentRate returns the lowest of the own and the global upload limits in bytes per second, 0 means no limit
+func (s *Service) currentRate() int64 {
+	now := time.Now()
+	rate := s.limiter.RateAt(now)
+	globalRate := throttle.UploadLimiter().RateAt(now)
+	if rate == 0 || (globalRate > 0 && globalRate < rate) {
+		rate = globalRate
+	}
+
+	return rate
+}
+
+func (s *Service) mkdirCommands(remoteFolder string) []string {
+	if remoteFolder == "" || remoteFolder == "." {
+		return nil
+	}
+
+	commands := []string{}
+	current := ""
+	if strings.HasPrefix(remoteFolder, "/") {
+		current = "/"
+	}
+	for _, segment := range strings.Split(strings.Trim(remoteFolder, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		current = path.Join(current, segment)
+		// the leading dash tells sftp to ignore errors for already existing folders
+		commands = append(commands, "-mkdir "+quoteBatchArg(current))
+	}
+
+	return commands
+}
+
+func (s *Service) runBatch(commands []string) error {
+	batchFile, err := os.CreateTemp("", "dumper-sftp-*.batch")
+	if err != nil {
+		return err
+	}
+	defer fs.RmFile(batchFile.Name())
+
+	_, err = batchFile.WriteString(strings.Join(commands, "\n") + "\n")
+	if err != nil {
+		batchFile.Close()
+		return err
+	}
+
+	err = batchFile.Close()
+	if err != nil {
+		return err
+	}
+
+	cmdExec := cli.CmdExec{
+		SuccessWriter: cli.NewStdSuccessWriter(),
+		ErrorWriter:   cli.NewStdErrorWriter(),
+	}
+
+	prefix := ""
+	if s.cfg.UploadTimeout > 0 {
+		prefix = fmt.Sprintf("timeout %ds ", int(s.cfg.UploadTimeout.Seconds()))
+	}
+
+	// ssh uses the first value of an option and sftp adds BatchMode=yes when it parses -b,
+	// so all -o options go before -b
+	args := []string{}
+	if s.cfg.Password != "" && s.cfg.KeyPath == "" {
+		// batch mode disables password prompts, so it's re-enabled for sshpass
+		args = append(args, "-o", "BatchMode=no", "-o", "PubkeyAuthentication=no")
+		prefix += "sshpass -e "
+		cmdExec.Envs = []string{"SSHPASS=" + s.cfg.Password}
+	}
+
+	args = append(args, "-o", shellQuote("StrictHostKeyChecking="+s.cfg.HostKeyChecking))
+
+	if s.cfg.KnownHostsPath != "" {
+		args = append(args, "-o", shellQuote("UserKnownHostsFile="+s.cfg.KnownHostsPath))
+	}
+
+	if s.cfg.KeyPath != "" {
+		args = append(args, "-i", shellQuote(s.cfg.KeyPath), "-o", "IdentitiesOnly=yes")
+	}
+
+	args = append(args, "-P", shellQuote(s.cfg.Port))
+
+	// sftp transfers the file itself, so the limit which is active at the start is passed to it in Kbit/s
+	if rate := s.currentRate(); rate > 0 {
+		args = append(args, "-l", strconv.FormatInt(rate*bitsInByte/bitsInKbit, 10))
+	}
+
+	args = append(args, "-b", shellQuote(batchFile.Name()))
+	args = append(args, shellQuote(s.cfg.User+"@"+s.cfg.Host))
+
+	return cmdExec.Execute("%ssftp %s", prefix, strings.Join(args, " "))
+}
+
+func quoteBatchArg(arg string) string {
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+
+	return `"` + arg + `"`
+}
+
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+func join(path0, path1 string) string {
+	if path0 == "" {
+		return path1
+	}
+
+	return strings.TrimSuffix(path0, "/") + "/" + strings.TrimPrefix(path1, "/")
+}
@@ -0,0 +1,188 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/breathbath/go_utils/v3/pkg/env"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+// maxChunk limits a single read, so limiters don't need to wait for big chunks at once
+const maxChunk = 32 * 1024
+
+var (
+	uploadLimiter     *Limiter
+	uploadLimiterOnce sync.Once
+)
+
+// UploadLimiter is shared by all uploaders, it's configured with UPLOAD_RATE_LIMIT
+func UploadLimiter() *Limiter {
+	uploadLimiterOnce.Do(func() {
+		uploadLimiter = NewLimiterFromSpec("UPLOAD_RATE_LIMIT", env.ReadEnv("UPLOAD_RATE_LIMIT", ""))
+	})
+
+	return uploadLimiter
+}
+
+// IORate returns the limit for writing dumps and archives in bytes per second, it's configured with IO_RATE_LIMIT,
+// an invalid value is reported and means no limit
+func IORate() int64 {
+	rate, err := parseIORate()
+	if err != nil {
+		io2.OutputError(err, "", "will ignore the write rate limit")
+		return 0
+	}
+
+	return rate
+}
+
+// ValidateIORate checks IO_RATE_LIMIT, only a fixed rate is supported, since pv keeps the rate it's started with
+// for the whole dump, so a time of day schedule wouldn't apply to long running dumps
+func ValidateIORate() error {
+	_, err := parseIORate()
+
+	return err
+}
+
+func parseIORate() (int64, error) {
+	raw := strings.TrimSpace(env.ReadEnv("IO_RATE_LIMIT", ""))
+	if raw == "" {
+		return 0, nil
+	}
+
+	if strings.ContainsAny(raw, "=,") {
+		return 0, fmt.Errorf("schedule %q is not supported in IO_RATE_LIMIT, use a fixed rate like 10M", raw)
+	}
+
+	rate, err := ParseRate(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid IO_RATE_LIMIT: %v", err)
+	}
+
+	return rate, nil
+}
+
+// Limiter is a token bucket which allows bursts up to the rate of one second
+type Limiter struct {
+	schedule *Schedule
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+}
+
+func NewLimiter(schedule *Schedule) *Limiter {
+	return &Limiter{
+		schedule: schedule,
+	}
+}
+
+// NewLimiterFromSpec parses the schedule spec, an invalid spec is reported and means no limit
+func NewLimiterFromSpec(name, spec string) *Limiter {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		io2.OutputError(err, "", "invalid rate limit %s, will ignore it", name)
+		schedule = &Schedule{}
+	}
+
+	return NewLimiter(schedule)
+}
+
+func (l *Limiter) RateAt(t time.Time) int64 {
+	if l == nil {
+		return 0
+	}
+
+	return l.schedule.RateAt(t)
+}
+
+// WaitN blocks until n bytes can be passed according to the current rate
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	for n > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		rate := l.schedule.RateAt(now)
+		if rate <= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+
+		l.refill(now, rate)
+
+		take := int64(n)
+		if take > rate {
+			take = rate
+		}
+
+		if l.tokens >= float64(take) {
+			l.tokens -= float64(take)
+			n -= int(take)
+			l.mu.Unlock()
+			continue
+		}
+
+		wait := time.Duration((float64(take) - l.tokens) / float64(rate) * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return nil
+}
+
+func (l *Limiter) refill(now time.Time, rate int64) {
+	if l.last.IsZero() {
+		l.tokens = float64(rate)
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	}
+	if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+	l.last = now
+}
+
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+}
+
+// NewReader limits reading from r by the given limiters and the global upload limiter
+func NewReader(ctx context.Context, r io.Reader, limiters ...*Limiter) io.Reader {
+	return &reader{
+		ctx:      ctx,
+		r:        r,
+		limiters: append([]*Limiter{UploadLimiter()}, limiters...),
+	}
+}
+
+func (tr *reader) Read(p []byte) (int, error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+
+	n, err := tr.r.Read(p)
+	for _, l := range tr.limiters {
+		waitErr := l.WaitN(tr.ctx, n)
+		if waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,43 @@
+package throttle
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIORate(t *testing.T) {
+	testCases := []struct {
+		raw          string
+		expectedRate int64
+		expectedErr  string
+	}{
+		{raw: "", expectedRate: 0},
+		{raw: "10M", expectedRate: 10 * 1024 * 1024},
+		{raw: "08:00-20:00=512K,4M", expectedErr: "is not supported in IO_RATE_LIMIT"},
+		{raw: "fast", expectedErr: "invalid IO_RATE_LIMIT"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.raw, func(t *testing.T) {
+			t.Setenv("IO_RATE_LIMIT", testCase.raw)
+
+			err := ValidateIORate()
+			if testCase.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.expectedErr) {
+					t.Errorf("expected error containing %q, got %v", testCase.expectedErr, err)
+				}
+				if rate := IORate(); rate != 0 {
+					t.Errorf("expected no limit for an invalid value, got %d", rate)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rate := IORate(); rate != testCase.expectedRate {
+				t.Errorf("expected rate %d, got %d", testCase.expectedRate, rate)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package throttle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minutesInDay = 24 * 60
+
+type window struct {
+	// start and end are minutes since midnight, a window with end before start spans midnight
+	start int
+	end   int
+	rate  int64
+}
+
+func (w window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+
+	return minute >= w.start || minute < w.end
+}
+
+// Schedule defines a rate limit in bytes per second which can depend on the time of day
+type Schedule struct {
+	windows     []window
+	defaultRate int64
+}
+
+// ParseSchedule reads a limit like "10M" or a schedule like "08:00-20:00=512K,22:00-06:00=0,4M",
+// which means 512K per second during office hours, no limit at night and 4M per second otherwise,
+// rates support K, M and G suffixes, 0 or an empty value mean no limit
+func ParseSchedule(raw string) (*Schedule, error) {
+	s := &Schedule{}
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return s, nil
+	}
+
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+
+		windowRaw, rateRaw, hasWindow := cut(entry, "=")
+		if !hasWindow {
+			rate, err := ParseRate(entry)
+			if err != nil {
+				return nil, err
+			}
+			s.defaultRate = rate
+			continue
+		}
+
+		w, err := parseWindow(windowRaw)
+		if err != nil {
+			return nil, err
+		}
+
+		w.rate, err = ParseRate(rateRaw)
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+
+	return s, nil
+}
+
+// RateAt returns the limit for the given time, the first matching window wins, 0 means no limit
+func (s *Schedule) RateAt(t time.Time) int64 {
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return w.rate
+		}
+	}
+
+	return s.defaultRate
+}
+
+func (s *Schedule) IsEmpty() bool {
+	return len(s.windows) == 0 && s.defaultRate == 0
+}
+
+// ParseRate reads bytes per second like 512K, 10M or 1G
+func ParseRate(raw string) (int64, error) {
+	raw = strings.ToUpper(strings.TrimSpace(raw))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(raw, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(raw, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(raw, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		raw = raw[:len(raw)-1]
+	}
+
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid rate %q, expected a value like 512K, 10M or 1G", raw)
+	}
+
+	return value * multiplier, nil
+}
+
+func parseWindow(raw string) (window, error) {
+	startRaw, endRaw, ok := cut(raw, "-")
+	if !ok {
+		return window{}, fmt.Errorf("invalid time window %q, expected a value like 08:00-20:00", raw)
+	}
+
+	start, err := parseClock(startRaw)
+	if err != nil {
+		return window{}, err
+	}
+
+	end, err := parseClock(endRaw)
+	if err != nil {
+		return window{}, err
+	}
+
+	return window{start: start, end: end}, nil
+}
+
+func parseClock(raw string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected a value like 08:00", raw)
+	}
+
+	return (t.Hour()*60 + t.Minute()) % minutesInDay, nil
+}
+
+// cut is strings.Cut which is not available in go 1.17
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
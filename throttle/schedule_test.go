@@ -0,0 +1,111 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func at(clock string) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic(err)
+	}
+
+	return time.Date(2022, 3, 15, t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func TestParseSchedule(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		expectedRates map[string]int64
+	}{
+		{
+			name:          "empty",
+			raw:           "",
+			expectedRates: map[string]int64{"00:00": 0, "12:00": 0},
+		},
+		{
+			name:          "fixed rate",
+			raw:           "10M",
+			expectedRates: map[string]int64{"00:00": 10 * 1024 * 1024, "12:00": 10 * 1024 * 1024},
+		},
+		{
+			name: "windows with a default rate",
+			raw:  "08:00-20:00=512K, 22:00-06:00=0, 4M",
+			expectedRates: map[string]int64{
+				"07:59": 4 * 1024 * 1024,
+				"08:00": 512 * 1024,
+				"19:59": 512 * 1024,
+				"20:00": 4 * 1024 * 1024,
+				"22:00": 0,
+				"23:59": 0,
+				"00:00": 0,
+				"05:59": 0,
+				"06:00": 4 * 1024 * 1024,
+			},
+		},
+		{
+			name:          "windows without a default rate",
+			raw:           "01:30-02:00=1G",
+			expectedRates: map[string]int64{"01:29": 0, "01:30": 1024 * 1024 * 1024, "02:00": 0},
+		},
+		{
+			name:          "the first matching window wins",
+			raw:           "00:00-12:00=1K,06:00-18:00=2K",
+			expectedRates: map[string]int64{"07:00": 1024, "13:00": 2048},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			s, err := ParseSchedule(testCase.raw)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for clock, expectedRate := range testCase.expectedRates {
+				if rate := s.RateAt(at(clock)); rate != expectedRate {
+					t.Errorf("%s: expected rate %d, got %d", clock, expectedRate, rate)
+				}
+			}
+		})
+	}
+}
+
+func TestParseScheduleRejectsInvalidValues(t *testing.T) {
+	for _, raw := range []string{
+		"10 MB",
+		"-1K",
+		"08:00=1M",
+		"08:00-25:00=1M",
+		"8am-8pm=1M",
+		"08:00-20:00=fast",
+	} {
+		if _, err := ParseSchedule(raw); err == nil {
+			t.Errorf("%q: expected an error", raw)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	testCases := map[string]int64{
+		"0":     0,
+		"100":   100,
+		"512K":  512 * 1024,
+		"512k":  512 * 1024,
+		" 10M ": 10 * 1024 * 1024,
+		"2G":    2 * 1024 * 1024 * 1024,
+	}
+
+	for raw, expected := range testCases {
+		rate, err := ParseRate(raw)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", raw, err)
+			continue
+		}
+		if rate != expected {
+			t.Errorf("%q: expected %d, got %d", raw, expected, rate)
+		}
+	}
+}
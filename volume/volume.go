@@ -0,0 +1,203 @@
+package volume
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/breathbath/go_utils/v3/pkg/fs"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+const ManifestSuffix = ".manifest.json"
+
+var partNameRgx = regexp.MustCompile(`\.\d{3}$`)
+
+type Part struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes a file which is split into numbered volumes e.g. dump.sql.gz.001, dump.sql.gz.002,
+// it's stored next to the volumes as dump.sql.gz.manifest.json
+type Manifest struct {
+	FileName string  `json:"fileName"`
+	Size     int64   `json:"size"`
+	SHA256   string  `json:"sha256"`
+	Parts    []*Part `json:"parts"`
+}
+
+func PartName(fileName string, partNumber int) string {
+	return fmt.Sprintf("%s.%03d", fileName, partNumber)
+}
+
+func ManifestName(fileName string) string {
+	return fileName + ManifestSuffix
+}
+
+// BaseName returns the name of the original file for a volume or a manifest name
+func BaseName(name string) (baseName string, isVolume bool) {
+	if strings.HasSuffix(name, ManifestSuffix) {
+		return strings.TrimSuffix(name, ManifestSuffix), true
+	}
+
+	if partNameRgx.MatchString(name) {
+		return partNameRgx.ReplaceAllString(name, ""), true
+	}
+
+	return name, false
+}
+
+// Split reads r in volumes of volumeSize bytes and passes each of them to handle, checksums are calculated
+// while handle reads the volume, so the data is read only once, an empty reader gives no volumes
+func Split(fileName string, r io.Reader, volumeSize int64, handle func(part *Part, r io.Reader) error) (*Manifest, error) {
+	if volumeSize <= 0 {
+		return nil, fmt.Errorf("invalid volume size %d", volumeSize)
+	}
+
+	br := bufio.NewReader(r)
+	m := &Manifest{
+		FileName: fileName,
+		Parts:    []*Part{},
+	}
+
+	totalHash := sha256.New()
+	for partNumber := 1; ; partNumber++ {
+		_, err := br.Peek(1)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", fileName, err)
+		}
+
+		part := &Part{
+			Name:   PartName(fileName, partNumber),
+			Offset: m.Size,
+		}
+		partHash := sha256.New()
+		limited := &io.LimitedReader{R: br, N: volumeSize}
+		partReader := io.TeeReader(limited, io.MultiWriter(totalHash, partHash))
+
+		err = handle(part, partReader)
+		if err != nil {
+			return nil, err
+		}
+
+		rest, err := io.Copy(io.Discard, partReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", fileName, err)
+		}
+		if rest > 0 {
+			return nil, fmt.Errorf("volume %s is read partially, %d bytes are left", part.Name, rest)
+		}
+
+		part.Size = volumeSize - limited.N
+		part.SHA256 = hex.EncodeToString(partHash.Sum(nil))
+		m.Parts = append(m.Parts, part)
+		m.Size += part.Size
+	}
+	m.SHA256 = hex.EncodeToString(totalHash.Sum(nil))
+
+	return m, nil
+}
+
+func (m *Manifest) Marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+func (m *Manifest) WriteFile(path string) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(Manifest)
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse volume manifest %s: %v", path, err)
+	}
+
+	return m, nil
+}
+
+// Join concatenates volumes listed in the manifest, which should be located in the manifest's folder,
+// to targetPath and verifies checksums of each volume and of the whole file
+func Join(manifestPath, targetPath string) error {
+	m, err := ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	io2.OutputInfo("", "Will join %d volumes of %s to %s", len(m.Parts), m.FileName, targetPath)
+
+	target, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+
+	err = m.joinParts(filepath.Dir(manifestPath), target)
+	closeErr := target.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		fs.RmFile(targetPath)
+		return err
+	}
+
+	io2.OutputInfo("", "Joined %d volumes of %s to %s", len(m.Parts), m.FileName, targetPath)
+
+	return nil
+}
+
+func (m *Manifest) joinParts(partsDir string, target io.Writer) error {
+	totalHash := sha256.New()
+	var totalSize int64
+	for _, part := range m.Parts {
+		partPath := filepath.Join(partsDir, part.Name)
+		partHash := sha256.New()
+
+		partFile, err := os.Open(partPath)
+		if err != nil {
+			return fmt.Errorf("volume %s of %s is missing: %v", part.Name, m.FileName, err)
+		}
+
+		n, err := io.Copy(io.MultiWriter(target, totalHash, partHash), partFile)
+		partFile.Close()
+		if err != nil {
+			return fmt.Errorf("failed to copy volume %s: %v", partPath, err)
+		}
+
+		if n != part.Size || hex.EncodeToString(partHash.Sum(nil)) != part.SHA256 {
+			return fmt.Errorf("volume %s is corrupted: size %d, expected %d", partPath, n, part.Size)
+		}
+		totalSize += n
+	}
+
+	if totalSize != m.Size || hex.EncodeToString(totalHash.Sum(nil)) != m.SHA256 {
+		return fmt.Errorf("joined file %s doesn't match the manifest checksum", m.FileName)
+	}
+
+	return nil
+}
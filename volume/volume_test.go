@@ -0,0 +1,182 @@
+package volume
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testVolumeSize = 1000
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	return content
+}
+
+// splitToDir writes volumes of content and their manifest to dir and returns the manifest path
+func splitToDir(t *testing.T, dir string, content []byte) (*Manifest, string) {
+	t.Helper()
+
+	m, err := Split("db.sql.gz", bytes.NewReader(content), testVolumeSize, func(part *Part, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(filepath.Join(dir, part.Name), data, 0600)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	manifestPath := filepath.Join(dir, ManifestName(m.FileName))
+	err = m.WriteFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m, manifestPath
+}
+
+func TestSplitJoinRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name          string
+		size          int
+		expectedParts []string
+	}{
+		{name: "empty file", size: 0, expectedParts: []string{}},
+		{name: "single volume", size: 10, expectedParts: []string{"db.sql.gz.001"}},
+		{name: "whole volumes", size: 2 * testVolumeSize, expectedParts: []string{"db.sql.gz.001", "db.sql.gz.002"}},
+		{
+			name:          "last volume is smaller",
+			size:          2*testVolumeSize + 1,
+			expectedParts: []string{"db.sql.gz.001", "db.sql.gz.002", "db.sql.gz.003"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir := t.TempDir()
+			content := testContent(testCase.size)
+
+			m, manifestPath := splitToDir(t, dir, content)
+
+			partNames := []string{}
+			var offset int64
+			for _, part := range m.Parts {
+				partNames = append(partNames, part.Name)
+				if part.Offset != offset {
+					t.Errorf("expected offset %d of %s, got %d", offset, part.Name, part.Offset)
+				}
+				offset += part.Size
+			}
+			if strings.Join(partNames, ",") != strings.Join(testCase.expectedParts, ",") {
+				t.Errorf("expected volumes %v, got %v", testCase.expectedParts, partNames)
+			}
+
+			totalHash := sha256.Sum256(content)
+			if m.Size != int64(len(content)) || m.SHA256 != hex.EncodeToString(totalHash[:]) {
+				t.Errorf("manifest doesn't describe the content: size %d, sha256 %s", m.Size, m.SHA256)
+			}
+
+			targetPath := filepath.Join(t.TempDir(), "db.sql.gz")
+			err := Join(manifestPath, targetPath)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			joined, err := os.ReadFile(targetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(joined, content) {
+				t.Errorf("joined content of %d bytes differs from the original content of %d bytes", len(joined), len(content))
+			}
+		})
+	}
+}
+
+func TestJoinRejectsCorruptedVolumes(t *testing.T) {
+	testCases := []struct {
+		name        string
+		corrupt     func(t *testing.T, dir string)
+		expectedErr string
+	}{
+		{
+			name: "changed volume",
+			corrupt: func(t *testing.T, dir string) {
+				err := os.WriteFile(filepath.Join(dir, "db.sql.gz.002"), testContent(testVolumeSize-1), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			expectedErr: "is corrupted",
+		},
+		{
+			name: "missing volume",
+			corrupt: func(t *testing.T, dir string) {
+				err := os.Remove(filepath.Join(dir, "db.sql.gz.001"))
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			expectedErr: "volume db.sql.gz.001 of db.sql.gz is missing",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, manifestPath := splitToDir(t, dir, testContent(2*testVolumeSize))
+			testCase.corrupt(t, dir)
+
+			targetPath := filepath.Join(t.TempDir(), "db.sql.gz")
+			err := Join(manifestPath, targetPath)
+			if err == nil || !strings.Contains(err.Error(), testCase.expectedErr) {
+				t.Fatalf("expected error containing %q, got %v", testCase.expectedErr, err)
+			}
+
+			if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
+				t.Errorf("expected the joined file to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSplitRejectsPartiallyReadVolume(t *testing.T) {
+	_, err := Split("db.sql.gz", bytes.NewReader(testContent(10)), testVolumeSize, func(part *Part, r io.Reader) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "volume db.sql.gz.001 is read partially") {
+		t.Fatalf("expected an error about the partially read volume, got %v", err)
+	}
+}
+
+func TestBaseName(t *testing.T) {
+	testCases := []struct {
+		name             string
+		expectedBaseName string
+		expectedIsVolume bool
+	}{
+		{name: "db.sql.gz.001", expectedBaseName: "db.sql.gz", expectedIsVolume: true},
+		{name: "db.sql.gz.manifest.json", expectedBaseName: "db.sql.gz", expectedIsVolume: true},
+		{name: "db.sql.gz", expectedBaseName: "db.sql.gz", expectedIsVolume: false},
+		{name: "db.sql.gz.1", expectedBaseName: "db.sql.gz.1", expectedIsVolume: false},
+	}
+
+	for _, testCase := range testCases {
+		baseName, isVolume := BaseName(testCase.name)
+		if baseName != testCase.expectedBaseName || isVolume != testCase.expectedIsVolume {
+			t.Errorf("%s: expected %q, %v, got %q, %v", testCase.name, testCase.expectedBaseName, testCase.expectedIsVolume, baseName, isVolume)
+		}
+	}
+}
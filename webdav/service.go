@@ -0,0 +1,353 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/progress"
+	"github.com/breathbath/dumper/retry"
+	"github.com/breathbath/dumper/throttle"
+	"github.com/breathbath/go_utils/v3/pkg/env"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const WebdavUploader = "webdav"
+
+const (
+	methodMkcol    = "MKCOL"
+	methodPropfind = "PROPFIND"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/><d:resourcetype/></d:prop></d:propfind>`
+
+type UploadConfig struct {
+	URL              string        `json:"url"`
+	User             string        `json:"user"`
+	Password         string        `json:"password"`
+	Token            string        `json:"token"`
+	RemoteFolder     string        `json:"folder"`
+	UploadTimeoutRaw string        `json:"timeout"`
+	UploadTimeout    time.Duration `json:"-"`
+	RateLimit        string        `json:"rateLimit"`
+}
+
+func (mc *UploadConfig) Validate() error {
+	fields := []*validation.FieldRules{
+		validation.Field(&mc.URL, validation.Required, validation.By(func(value interface{}) error {
+			u, err := url.Parse(fmt.Sprint(value))
+			if err != nil {
+				return err
+			}
+			if u.Scheme == "" || u.Host == "" {
+				return errors.New("should be an absolute URL like https://cloud.example.com/remote.php/dav/files/user")
+			}
+
+			return nil
+		})),
+		validation.Field(&mc.Token, validation.By(func(value interface{}) error {
+			if fmt.Sprint(value) != "" && mc.User != "" {
+				return errors.New("either token or user/password should be provided, not both")
+			}
+
+			return nil
+		})),
+		validation.Field(&mc.RateLimit, validation.By(func(value interface{}) error {
+			_, err := throttle.ParseSchedule(fmt.Sprint(value))
+			return err
+		})),
+		validation.Field(&mc.UploadTimeoutRaw, config.DurationRule),
+	}
+
+	return validation.ValidateStruct(mc, fields...)
+}
+
+func NewConfigFromEnvs() *UploadConfig {
+	cfg := &UploadConfig{}
+	cfg.URL = env.ReadEnv("WEBDAV_URL", "")
+	cfg.User = env.ReadEnv("WEBDAV_USER", "")
+	cfg.Password = env.ReadEnv("WEBDAV_PASSWORD", "")
+	cfg.Token = env.ReadEnv("WEBDAV_TOKEN", "")
+	cfg.RemoteFolder = env.ReadEnv("WEBDAV_FOLDER", "")
+	cfg.RateLimit = env.ReadEnv("WEBDAV_RATE_LIMIT", "")
+	cfg.UploadTimeoutRaw, cfg.UploadTimeout = config.ReadDurationEnv("WEBDAV_UPLOADER_TIMEOUT", 0)
+
+	return cfg
+}
+
+func NewConfigFromSettings(settings []byte) (*UploadConfig, error) {
+	cfg := &UploadConfig{}
+	err := config.ParseUploaderSettings(
+		WebdavUploader, settings, cfg,
+		&cfg.URL, &cfg.User, &cfg.Password, &cfg.Token, &cfg.RemoteFolder,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.UploadTimeout = config.ParseDuration(cfg.UploadTimeoutRaw, 0)
+
+	return cfg, nil
+}
+
+type multiStatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		PropStats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ContentLength string `xml:"DAV: getcontentlength"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+type Service struct {
+	cfg     *UploadConfig
+	limiter *throttle.Limiter
+}
+
+func NewService(cfg *UploadConfig) *Service {
+	return &Service{
+		cfg:     cfg,
+		limiter: throttle.NewLimiterFromSpec(WebdavUploader, cfg.RateLimit),
+	}
+}
+
+// see http://www.webdav.org/specs/rfc4918.html for details
+func (s *Service) Upload(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return s.upload(remotePath, file, fileInfo.Size())
+}
+
+// UploadStream sends data of unknown size with chunked transfer encoding, which most servers support
+func (s *Service) UploadStream(name string, r io.Reader) error {
+	return s.upload(name, r, -1)
+}
+
+func (s *Service) upload(name string, r io.Reader, size int64) error {
+	if err := s.cfg.Validate(); err != nil {
+		return retry.Permanent(err)
+	}
+
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	if s.cfg.UploadTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), s.cfg.UploadTimeout)
+		defer cancel()
+	}
+
+	remotePath := join(s.cfg.RemoteFolder, name)
+
+	err := s.makeCollections(ctx, path.Dir(remotePath))
+	if err != nil {
+		return err
+	}
+
+	counter := &countingReader{r: r}
+	pr := progress.NewReader(name, counter, size)
+	defer pr.Close()
+
+	err = s.put(ctx, remotePath, throttle.NewReader(ctx, pr, s.limiter), size)
+	if err != nil {
+		return err
+	}
+
+	remoteSize, err := s.remoteSize(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+
+	if remoteSize != counter.n {
+		return fmt.Errorf(
+			"uploaded file %s has size %d on the server, but the local size is %d",
+			remotePath,
+			remoteSize,
+			counter.n,
+		)
+	}
+
+	io2.OutputInfo("", "successfully uploaded %s to %s, verified size %d", name, remotePath, remoteSize)
+
+	return nil
+}
+
+func (s *Service) makeCollections(ctx context.Context, folder string) error {
+	current := ""
+	for _, segment := range strings.Split(strings.Trim(folder, "/"), "/") {
+		if segment == "" || segment == "." {
+			continue
+		}
+		current = join(current, segment)
+
+		resp, respBody, err := s.do(ctx, methodMkcol, current+"/", http.NoBody, nil)
+		if err != nil {
+			return err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusCreated:
+			io2.OutputInfo("", "created collection %s", current)
+		case http.StatusMethodNotAllowed:
+			// the collection already exists
+		default:
+			return fmt.Errorf(
+				"failed to create collection %s: wrong response code %d, body %q",
+				current,
+				resp.StatusCode,
+				string(respBody),
+			)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) put(ctx context.Context, remotePath string, body io.Reader, size int64) error {
+	io2.OutputInfo("", "Will upload %d bytes to %s", size, remotePath)
+
+	if size == 0 {
+		// a zero content length with a body other than http.NoBody means an unknown length, which is sent chunked
+		body = http.NoBody
+	}
+
+	resp, respBody, err := s.do(ctx, http.MethodPut, remotePath, body, func(req *http.Request) {
+		req.ContentLength = size
+	})
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return retry.Permanent(fmt.Errorf("access denied to %s: %q", remotePath, string(respBody)))
+	case http.StatusConflict:
+		return retry.Permanent(fmt.Errorf("parent collection of %s doesn't exist", remotePath))
+	case http.StatusInsufficientStorage:
+		return retry.Permanent(fmt.Errorf("not enough space on the server for %s", remotePath))
+	default:
+		return retry.ClassifyStatus(
+			resp.StatusCode,
+			fmt.Errorf("failed to upload %s: wrong response code %d, body %q", remotePath, resp.StatusCode, string(respBody)),
+		)
+	}
+}
+
+func (s *Service) remoteSize(ctx context.Context, remotePath string) (int64, error) {
+	resp, respBody, err := s.do(ctx, methodPropfind, remotePath, strings.NewReader(propfindBody), func(req *http.Request) {
+		req.Header.Set("Depth", "0")
+		req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return 0, fmt.Errorf("failed to check %s: wrong response code %d, body %q", remotePath, resp.StatusCode, string(respBody))
+	}
+
+	ms := new(multiStatus)
+	err = xml.NewDecoder(bytes.NewBuffer(respBody)).Decode(ms)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode PROPFIND response %q: %v", string(respBody), err)
+	}
+
+	for _, r := range ms.Responses {
+		for _, ps := range r.PropStats {
+			if !strings.Contains(ps.Status, " 200 ") || ps.Prop.ContentLength == "" {
+				continue
+			}
+
+			return strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+		}
+	}
+
+	return 0, fmt.Errorf("no content length for %s in PROPFIND response %q", remotePath, string(respBody))
+}
+
+func (s *Service) do(
+	ctx context.Context,
+	method, remotePath string,
+	body io.Reader,
+	prepare func(req *http.Request),
+) (resp *http.Response, respBody []byte, err error) {
+	u, err := url.Parse(s.cfg.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	u.Path = join(u.Path, remotePath)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	} else if s.cfg.User != "" {
+		req.SetBasicAuth(s.cfg.User, s.cfg.Password)
+	}
+
+	if prepare != nil {
+		prepare(req)
+	}
+
+	cl := &http.Client{}
+	resp, err = cl.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to call webdav server %s %q: %v", method, u.String(), err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		io2.OutputWarning("", "failed to read response body from %s: %v", u.String(), err)
+	}
+
+	return resp, respBody, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+
+	return n, err
+}
+
+func join(path0, path1 string) string {
+	if path0 == "" {
+		return path1
+	}
+
+	return strings.TrimSuffix(path0, "/") + "/" + strings.TrimPrefix(path1, "/")
+}
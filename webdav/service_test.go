@@ -0,0 +1,227 @@
+package webdav
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/breathbath/dumper/retry"
+)
+
+const multiStatusTpl = `<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:">
+  <d:response>
+    <d:href>/dav/backups/dump.sql</d:href>
+    <d:propstat>
+      <d:prop><d:getcontentlength>%s</d:getcontentlength><d:resourcetype/></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`
+
+// fakeServer is a minimal webdav server which keeps collections and files in memory
+type fakeServer struct {
+	mu          sync.Mutex
+	collections map[string]bool
+	files       map[string][]byte
+	requests    []string
+	// putStatus overrides the response code of PUT requests
+	putStatus int
+	// reportedSize overrides the size which is returned by PROPFIND
+	reportedSize string
+	// chunked tells if the last PUT was sent with chunked transfer encoding
+	chunked bool
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		collections: map[string]bool{"/dav": true},
+		files:       map[string][]byte{},
+	}
+}
+
+func (fs *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.requests = append(fs.requests, r.Method+" "+r.URL.Path)
+
+	switch r.Method {
+	case methodMkcol:
+		name := strings.TrimSuffix(r.URL.Path, "/")
+		if fs.collections[name] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		fs.collections[name] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		fs.chunked = len(r.TransferEncoding) > 0
+		if fs.putStatus != 0 {
+			w.WriteHeader(fs.putStatus)
+			return
+		}
+		if !fs.collections[path.Dir(r.URL.Path)] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fs.files[r.URL.Path] = body
+		w.WriteHeader(http.StatusCreated)
+	case methodPropfind:
+		body, ok := fs.files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		size := fmt.Sprint(len(body))
+		if fs.reportedSize != "" {
+			size = fs.reportedSize
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, multiStatusTpl, size)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestService(t *testing.T, fs *fakeServer) *Service {
+	t.Helper()
+
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+
+	return NewService(&UploadConfig{URL: srv.URL + "/dav", RemoteFolder: "backups"})
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), "dump.sql")
+	err := os.WriteFile(filePath, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filePath
+}
+
+func TestUploadCreatesMissingCollections(t *testing.T) {
+	fs := newFakeServer()
+	fs.collections["/dav/backups"] = true
+	s := newTestService(t, fs)
+
+	err := s.Upload(writeTempFile(t, "select 1;"), "daily/dump.sql")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedRequests := []string{
+		"MKCOL /dav/backups/",
+		"MKCOL /dav/backups/daily/",
+		"PUT /dav/backups/daily/dump.sql",
+		"PROPFIND /dav/backups/daily/dump.sql",
+	}
+	if strings.Join(fs.requests, "\n") != strings.Join(expectedRequests, "\n") {
+		t.Errorf("expected requests %v, got %v", expectedRequests, fs.requests)
+	}
+
+	if string(fs.files["/dav/backups/daily/dump.sql"]) != "select 1;" {
+		t.Errorf("unexpected uploaded content %q", fs.files["/dav/backups/daily/dump.sql"])
+	}
+}
+
+func TestUploadPutStatuses(t *testing.T) {
+	testCases := []struct {
+		status      int
+		isPermanent bool
+	}{
+		{status: http.StatusUnauthorized, isPermanent: true},
+		{status: http.StatusForbidden, isPermanent: true},
+		{status: http.StatusConflict, isPermanent: true},
+		{status: http.StatusInsufficientStorage, isPermanent: true},
+		{status: http.StatusBadRequest, isPermanent: true},
+		{status: http.StatusInternalServerError, isPermanent: false},
+		{status: http.StatusBadGateway, isPermanent: false},
+		{status: http.StatusServiceUnavailable, isPermanent: false},
+		{status: http.StatusTooManyRequests, isPermanent: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(http.StatusText(testCase.status), func(t *testing.T) {
+			fs := newFakeServer()
+			fs.putStatus = testCase.status
+			s := newTestService(t, fs)
+
+			err := s.Upload(writeTempFile(t, "select 1;"), "dump.sql")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if retry.IsPermanent(err) != testCase.isPermanent {
+				t.Errorf("expected permanent %v for status %d, got error %v", testCase.isPermanent, testCase.status, err)
+			}
+		})
+	}
+}
+
+func TestUploadVerifiesRemoteSize(t *testing.T) {
+	testCases := []struct {
+		name         string
+		reportedSize string
+		expectedErr  string
+	}{
+		{name: "matching size", reportedSize: "", expectedErr: ""},
+		{name: "truncated file", reportedSize: "3", expectedErr: "has size 3 on the server, but the local size is 9"},
+		{name: "invalid content length", reportedSize: "n/a", expectedErr: "invalid syntax"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			fs := newFakeServer()
+			fs.reportedSize = testCase.reportedSize
+			s := newTestService(t, fs)
+
+			err := s.Upload(writeTempFile(t, "select 1;"), "dump.sql")
+			if testCase.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), testCase.expectedErr) {
+				t.Errorf("expected error containing %q, got %v", testCase.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestUploadEmptyFileIsNotChunked(t *testing.T) {
+	fs := newFakeServer()
+	s := newTestService(t, fs)
+
+	err := s.Upload(writeTempFile(t, ""), "dump.sql")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fs.chunked {
+		t.Error("empty file should be sent with zero content length, not chunked")
+	}
+
+	if content, ok := fs.files["/dav/backups/dump.sql"]; !ok || len(content) != 0 {
+		t.Errorf("expected an empty file on the server, got %q", content)
+	}
+}
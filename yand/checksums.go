@@ -0,0 +1,53 @@
+package yand
+
+import (
+	"crypto/md5" //nolint:gosec // md5 is required to compare with the checksums of the disk
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+// checksums calculates hashes of the uploaded data in the same formats as the disk api reports them
+type checksums struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+	w      io.Writer
+}
+
+func newChecksums() *checksums {
+	c := &checksums{
+		md5:    md5.New(), //nolint:gosec // see the import
+		sha256: sha256.New(),
+	}
+	c.w = io.MultiWriter(c.md5, c.sha256)
+
+	return c
+}
+
+func (c *checksums) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+func (c *checksums) verify(res *Resource) error {
+	localMD5 := hex.EncodeToString(c.md5.Sum(nil))
+	localSHA256 := hex.EncodeToString(c.sha256.Sum(nil))
+
+	if res.MD5 == "" && res.SHA256 == "" {
+		io2.OutputWarning("", "no checksums for %s on the disk, only its size is verified", res.Path)
+		return nil
+	}
+
+	if res.MD5 != "" && res.MD5 != localMD5 {
+		return fmt.Errorf("md5 %s on the disk doesn't match the uploaded md5 %s", res.MD5, localMD5)
+	}
+
+	if res.SHA256 != "" && res.SHA256 != localSHA256 {
+		return fmt.Errorf("sha256 %s on the disk doesn't match the uploaded sha256 %s", res.SHA256, localSHA256)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package yand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+const (
+	operationStatusSuccess = "success"
+	operationStatusFailed  = "failed"
+)
+
+type Operation struct {
+	Status string `json:"status"`
+}
+
+// waitForOperation polls the status of an async operation until it succeeds, fails, the operation timeout is reached
+// or ctx is done, see https://yandex.ru/dev/disk/api/reference/operations.html for details
+func (s *Service) waitForOperation(ctx context.Context, operationID string) error {
+	if operationID == "" {
+		return errors.New("yandex accepted the upload but returned no operation id to track it")
+	}
+
+	opCtx := ctx
+	var cancel context.CancelFunc
+	if s.cfg.OperationTimeout > 0 {
+		opCtx, cancel = context.WithTimeout(ctx, s.cfg.OperationTimeout)
+		defer cancel()
+	}
+
+	io2.OutputInfo("", "Will wait for operation %s", operationID)
+
+	ticker := time.NewTicker(operationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		op := new(Operation)
+		err := s.callAPI(opCtx, http.MethodGet, "/operations/"+url.PathEscape(operationID), url.Values{}, op)
+		if err != nil {
+			return fmt.Errorf("failed to read status of operation %s: %w", operationID, err)
+		}
+
+		switch op.Status {
+		case operationStatusSuccess:
+			io2.OutputInfo("", "operation %s succeeded", operationID)
+			return nil
+		case operationStatusFailed:
+			return fmt.Errorf("operation %s failed", operationID)
+		}
+
+		select {
+		case <-opCtx.Done():
+			// the upload timeout bounds the whole upload including waiting for its operations
+			if ctx.Err() != nil {
+				return fmt.Errorf("operation %s is still %q: %v", operationID, op.Status, ctx.Err())
+			}
+			return fmt.Errorf("operation %s is still %q after %s", operationID, op.Status, s.cfg.OperationTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForLinkedOperation waits for the operation if the api responded with a link to it,
+// which happens when the disk decides to copy, move or delete resources asynchronously
+func (s *Service) waitForLinkedOperation(ctx context.Context, link *Link) error {
+	if link.Href == "" {
+		return nil
+	}
+
+	operationURL, err := url.Parse(link.Href)
+	if err != nil {
+		return fmt.Errorf("invalid operation link %q: %v", link.Href, err)
+	}
+
+	if !strings.Contains(operationURL.Path, "/operations/") {
+		return nil
+	}
+
+	return s.waitForOperation(ctx, path.Base(operationURL.Path))
+}
+
+// move renames a resource inside the remote folder,
+// see https://yandex.ru/dev/disk/api/reference/move.html for details
+func (s *Service) move(ctx context.Context, fromName, toName string) error {
+	query := url.Values{}
+	query.Set("from", join(s.cfg.RemoteFolder, fromName))
+	query.Set("path", join(s.cfg.RemoteFolder, toName))
+	query.Set("overwrite", "true")
+
+	link := new(Link)
+	err := s.callAPI(ctx, http.MethodPost, "/resources/move", query, link)
+	if err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", fromName, toName, err)
+	}
+
+	return s.waitForLinkedOperation(ctx, link)
+}
+
+// verifyUploaded makes sure that the uploaded file exists on the disk and has the expected size and checksums
+func (s *Service) verifyUploaded(ctx context.Context, fileName string, size int64, sums *checksums) error {
+	remotePath := join(s.cfg.RemoteFolder, fileName)
+
+	res, err := s.getResource(ctx, remotePath, 0)
+	if err != nil {
+		if isNotFound(err) {
+			// the upload got lost, so it's worth repeating it
+			return fmt.Errorf("uploaded file %s is not found on the disk", remotePath)
+		}
+		return fmt.Errorf("failed to verify uploaded file %s: %w", remotePath, err)
+	}
+
+	if res.Size != size {
+		return fmt.Errorf("uploaded file %s has size %d on the disk, but the local size is %d", remotePath, res.Size, size)
+	}
+
+	err = sums.verify(res)
+	if err != nil {
+		return fmt.Errorf("uploaded file %s is corrupted: %v", remotePath, err)
+	}
+
+	io2.OutputInfo("", "verified uploaded file %s, size %d, md5 %s, sha256 %s", remotePath, res.Size, res.MD5, res.SHA256)
+
+	return nil
+}
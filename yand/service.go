@@ -10,10 +10,16 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/breathbath/dumper/config"
+	"github.com/breathbath/dumper/progress"
+	"github.com/breathbath/dumper/retry"
+	"github.com/breathbath/dumper/throttle"
+	"github.com/breathbath/dumper/volume"
 	"github.com/breathbath/go_utils/v3/pkg/env"
 	io2 "github.com/breathbath/go_utils/v3/pkg/io"
 	validation "github.com/go-ozzo/ozzo-validation"
@@ -21,7 +27,19 @@ import (
 
 const YandexUploader = "yandex"
 
-const uploadURL = "https://cloud-api.yandex.net/v1/disk/resources/upload"
+const (
+	apiURL    = "https://cloud-api.yandex.net/v1/disk"
+	uploadURL = apiURL + "/resources/upload"
+)
+
+const (
+	// yandex disk rejects files bigger than 10gb
+	defaultVolumeSizeMb = 8192
+	bytesInMb           = 1024 * 1024
+
+	defaultOperationTimeout = 30 * time.Minute
+	operationPollInterval   = 3 * time.Second
+)
 
 type UploadTarget struct {
 	OperationID string `json:"operation_id"`
@@ -34,27 +52,40 @@ type ResponseErr struct {
 	Error       string `json:"error"`
 }
 
+// APIError is returned for non successful responses of the disk REST API
+type APIError struct {
+	StatusCode  int
+	Description string
+	Err         string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("wrong response code %d from yandex, message %s[%s]", e.StatusCode, e.Description, e.Err)
+}
+
 type UploadConfig struct {
-	Token            string
-	RemoteFolder     string
-	UploadTimeoutRaw string
-	UploadTimeout    time.Duration
+	Token            string        `json:"token"`
+	RemoteFolder     string        `json:"folder"`
+	UploadTimeoutRaw string        `json:"timeout"`
+	UploadTimeout    time.Duration `json:"-"`
+	RateLimit        string        `json:"rateLimit"`
+	VolumeSizeMb     int           `json:"volumeSizeMb"`
+	// OperationTimeout limits waiting for async operations, when yandex accepts a file but moves it later,
+	// the wait is also bounded by UploadTimeout
+	OperationTimeoutRaw string        `json:"operationTimeout"`
+	OperationTimeout    time.Duration `json:"-"`
 }
 
 func (mc *UploadConfig) Validate() error {
 	fields := []*validation.FieldRules{
 		validation.Field(&mc.Token, validation.Required),
-		validation.Field(&mc.UploadTimeoutRaw, validation.By(func(value interface{}) error {
-			valStr := fmt.Sprint(value)
-			if valStr != "" {
-				_, err := time.ParseDuration(valStr)
-				if err != nil {
-					return err
-				}
-			}
-
-			return nil
+		validation.Field(&mc.VolumeSizeMb, validation.Min(0)),
+		validation.Field(&mc.OperationTimeoutRaw, config.DurationRule),
+		validation.Field(&mc.RateLimit, validation.By(func(value interface{}) error {
+			_, err := throttle.ParseSchedule(fmt.Sprint(value))
+			return err
 		})),
+		validation.Field(&mc.UploadTimeoutRaw, config.DurationRule),
 	}
 
 	return validation.ValidateStruct(mc, fields...)
@@ -64,35 +95,113 @@ func NewConfigFromEnvs() *UploadConfig {
 	cfg := &UploadConfig{}
 	cfg.Token = env.ReadEnv("YAND_TOKEN", "")
 	cfg.RemoteFolder = env.ReadEnv("YAND_FOLDER", "")
-	cfg.UploadTimeoutRaw = env.ReadEnv("YAND_UPLOADER_TIMEOUT", "")
-	if cfg.UploadTimeoutRaw != "" {
-		timeout, err := time.ParseDuration(cfg.UploadTimeoutRaw)
+	cfg.RateLimit = env.ReadEnv("YAND_RATE_LIMIT", "")
+	cfg.UploadTimeoutRaw, cfg.UploadTimeout = config.ReadDurationEnv("YAND_UPLOADER_TIMEOUT", 0)
+
+	cfg.OperationTimeoutRaw, cfg.OperationTimeout = config.ReadDurationEnv("YAND_OPERATION_TIMEOUT", defaultOperationTimeout)
+
+	cfg.VolumeSizeMb = defaultVolumeSizeMb
+	volumeSizeRaw := env.ReadEnv("YAND_VOLUME_SIZE_MB", "")
+	if volumeSizeRaw != "" {
+		volumeSize, err := strconv.Atoi(volumeSizeRaw)
 		if err == nil {
-			cfg.UploadTimeout = timeout
+			cfg.VolumeSizeMb = volumeSize
 		}
 	}
 
 	return cfg
 }
 
+func NewConfigFromSettings(settings []byte) (*UploadConfig, error) {
+	cfg := &UploadConfig{
+		VolumeSizeMb: defaultVolumeSizeMb,
+	}
+	err := config.ParseUploaderSettings(YandexUploader, settings, cfg, &cfg.Token, &cfg.RemoteFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.UploadTimeout = config.ParseDuration(cfg.UploadTimeoutRaw, 0)
+	cfg.OperationTimeout = config.ParseDuration(cfg.OperationTimeoutRaw, defaultOperationTimeout)
+
+	return cfg, nil
+}
+
 type Service struct {
-	cfg *UploadConfig
+	cfg     *UploadConfig
+	limiter *throttle.Limiter
 }
 
 func NewService(cfg *UploadConfig) *Service {
 	return &Service{
-		cfg: cfg,
+		cfg:     cfg,
+		limiter: throttle.NewLimiterFromSpec(YandexUploader, cfg.RateLimit),
 	}
 }
 
-func (s *Service) buildAuthURL(fileName string) (*url.URL, error) {
+// callAPI executes an authorized request to the disk REST API and decodes the json response into target if it's not nil
+func (s *Service) callAPI(ctx context.Context, method, apiPath string, query url.Values, target interface{}) error {
+	u, err := url.Parse(apiURL + apiPath)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), http.NoBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("OAuth %s", s.cfg.Token))
+
+	cl := &http.Client{}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call yandex disk api: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body %v", err)
+	}
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		errResp := new(ResponseErr)
+		err = json.Unmarshal(bodyBytes, errResp)
+		if err != nil {
+			return retry.ClassifyStatus(
+				resp.StatusCode,
+				fmt.Errorf("wrong response code %d from yandex: %s", resp.StatusCode, string(bodyBytes)),
+			)
+		}
+
+		return retry.ClassifyStatus(resp.StatusCode, &APIError{
+			StatusCode:  resp.StatusCode,
+			Description: errResp.Description,
+			Err:         errResp.Error,
+		})
+	}
+
+	if target == nil || len(bodyBytes) == 0 {
+		return nil
+	}
+
+	err = json.Unmarshal(bodyBytes, target)
+	if err != nil {
+		return fmt.Errorf("failed to decode resp %q: %v", string(bodyBytes), err)
+	}
+
+	return nil
+}
+
+func (s *Service) buildAuthURL(remotePath string) (*url.URL, error) {
 	u, err := url.Parse(uploadURL)
 	if err != nil {
 		return nil, err
 	}
 
 	values := u.Query()
-	values.Add("path", join(s.cfg.RemoteFolder, fileName))
+	values.Add("path", join(s.cfg.RemoteFolder, remotePath))
 	values.Add("overwrite", "true")
 
 	u.RawQuery = values.Encode()
@@ -100,31 +209,31 @@ func (s *Service) buildAuthURL(fileName string) (*url.URL, error) {
 	return u, nil
 }
 
-func (s *Service) fetchUploadURL(ctx context.Context, fileName string) (uploadURL, method string, err error) {
+func (s *Service) fetchUploadURL(ctx context.Context, fileName string) (*UploadTarget, error) {
 	authURL, err := s.buildAuthURL(fileName)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	io2.OutputInfo("", "Will read upload URL from %s", authURL.String())
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL.String(), http.NoBody)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("OAuth %s", s.cfg.Token))
 
 	cl := &http.Client{}
 	resp, err := cl.Do(req)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to call yandex disk api: %v", err)
+		return nil, fmt.Errorf("failed to call yandex disk api: %v", err)
 	}
 
 	defer resp.Body.Close()
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to read response body %v", err)
+		return nil, fmt.Errorf("failed to read response body %v", err)
 	}
 	jsonDec := json.NewDecoder(bytes.NewBuffer(bodyBytes))
 
@@ -134,23 +243,21 @@ func (s *Service) fetchUploadURL(ctx context.Context, fileName string) (uploadUR
 		err = jsonDec.Decode(errResp)
 		if err != nil {
 			io2.OutputError(err, "", "failed to decode resp %q to ResponseErr", string(bodyBytes))
-			return "",
-				"",
-				fmt.Errorf(
+			return nil,
+				retry.ClassifyStatus(resp.StatusCode, fmt.Errorf(
 					"failed retrieve upload link: wrong response code %d from yandex: %s, %v",
 					resp.StatusCode,
 					string(bodyBytes),
 					err,
-				)
+				))
 		}
-		return "",
-			"",
-			fmt.Errorf(
+		return nil,
+			retry.ClassifyStatus(resp.StatusCode, fmt.Errorf(
 				"failed retrieve upload link: wrong response code %d from yandex, message %s[%s]",
 				resp.StatusCode,
 				errResp.Description,
 				errResp.Error,
-			)
+			))
 	}
 
 	uploadTarget := new(UploadTarget)
@@ -158,28 +265,31 @@ func (s *Service) fetchUploadURL(ctx context.Context, fileName string) (uploadUR
 
 	if err != nil {
 		io2.OutputError(err, "", "failed to decode resp %q to UploadTarget", string(bodyBytes))
-		return "", "", fmt.Errorf("failed to read upload URL from %q: %v", string(bodyBytes), err)
+		return nil, fmt.Errorf("failed to read upload URL from %q: %v", string(bodyBytes), err)
 	}
 
 	io2.OutputInfo("", "got upload url: %s", string(bodyBytes))
 
-	return uploadTarget.Href, uploadTarget.Method, nil
+	return uploadTarget, nil
 }
 
 // see https://yandex.ru/dev/disk/doc/dg/reference/put.html for details
-func (s *Service) Upload(path string) error {
+func (s *Service) Upload(localPath, remotePath string) error {
 	if err := s.cfg.Validate(); err != nil {
-		return err
+		return retry.Permanent(err)
 	}
 
-	fileName := filepath.Base(path)
-
-	file, err := os.Open(path)
+	file, err := os.Open(localPath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
 	ctx := context.Background()
 	var cancel context.CancelFunc
 	if s.cfg.UploadTimeout > 0 {
@@ -187,31 +297,140 @@ func (s *Service) Upload(path string) error {
 		defer cancel()
 	}
 
-	tempUploadURL, method, err := s.fetchUploadURL(ctx, fileName)
+	err = s.makeFolders(ctx, remotePath)
 	if err != nil {
 		return err
 	}
 
-	err = s.uploadToTempUploadURL(ctx, tempUploadURL, method, file)
+	volumeSize := int64(s.cfg.VolumeSizeMb) * bytesInMb
+	if volumeSize > 0 && fileInfo.Size() > volumeSize {
+		return s.uploadVolumes(ctx, localPath, remotePath, file, fileInfo.Size(), volumeSize)
+	}
+
+	return s.uploadFile(ctx, remotePath, file, fileInfo.Size())
+}
+
+// uploadVolumes uploads the file as numbered volumes followed by a manifest, which is uploaded last,
+// so a manifest on the disk always means that all volumes are in place
+func (s *Service) uploadVolumes(
+	ctx context.Context,
+	localPath, remotePath string,
+	file *os.File,
+	fileSize, volumeSize int64,
+) error {
+	volumesCount := (fileSize + volumeSize - 1) / volumeSize
+	io2.OutputInfo("", "File %s is bigger than %d bytes, will upload it as %d volumes", localPath, volumeSize, volumesCount)
+
+	// volumes are named after the remote file, which might differ from the local one
+	m, err := volume.Split(path.Base(remotePath), file, volumeSize, func(part *volume.Part, r io.Reader) error {
+		partSize := volumeSize
+		if fileSize-part.Offset < partSize {
+			partSize = fileSize - part.Offset
+		}
+
+		err := s.uploadFile(ctx, siblingPath(remotePath, part.Name), r, partSize)
+		if err != nil {
+			return fmt.Errorf("failed to upload volume %s: %w", part.Name, err)
+		}
+
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
+	return s.uploadManifest(ctx, remotePath, m)
+}
+
+func (s *Service) uploadManifest(ctx context.Context, remotePath string, m *volume.Manifest) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+
+	return s.uploadFile(ctx, siblingPath(remotePath, volume.ManifestName(m.FileName)), bytes.NewReader(data), int64(len(data)))
+}
+
+// makeFolders creates missing parent folders of the remote path, since the disk rejects uploads into them,
+// see https://yandex.ru/dev/disk/api/reference/create-folder.html for details
+func (s *Service) makeFolders(ctx context.Context, remotePath string) error {
+	dir := path.Dir("/" + strings.Trim(strings.TrimPrefix(join(s.cfg.RemoteFolder, remotePath), diskPathPrefix), "/"))
+
+	current := ""
+	for _, segment := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		current += "/" + segment
+
+		err := s.callAPI(ctx, http.MethodPut, "/resources", url.Values{"path": []string{current}}, nil)
+		if err == nil {
+			io2.OutputInfo("", "created folder %s", current)
+			continue
+		}
+
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+			// the folder already exists
+			continue
+		}
+
+		return fmt.Errorf("failed to create folder %s: %w", current, err)
+	}
+
 	return nil
 }
 
-func (s *Service) uploadToTempUploadURL(ctx context.Context, tempUploadURL, method string, file *os.File) error {
-	io2.OutputInfo("", "Will upload file %s to %q, method %q", file.Name(), tempUploadURL, method)
+// siblingPath returns the path of a file named name in the same folder as remotePath
+func siblingPath(remotePath, name string) string {
+	return path.Join(path.Dir(remotePath), name)
+}
+
+func (s *Service) uploadFile(ctx context.Context, fileName string, body io.Reader, size int64) error {
+	uploadTarget, err := s.fetchUploadURL(ctx, fileName)
+	if err != nil {
+		return err
+	}
 
-	req, err := http.NewRequestWithContext(ctx, method, tempUploadURL, file)
+	// size is unknown (-1) for streams, so it's counted while uploading, hashes are compared with the remote ones afterwards
+	checksums := newChecksums()
+	counter := &countingReader{r: io.TeeReader(body, checksums)}
+	accepted, err := s.uploadToTempUploadURL(ctx, uploadTarget.Href, uploadTarget.Method, fileName, counter, size)
 	if err != nil {
 		return err
 	}
 
+	if accepted {
+		err = s.waitForOperation(ctx, uploadTarget.OperationID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.verifyUploaded(ctx, fileName, counter.n, checksums)
+}
+
+func (s *Service) uploadToTempUploadURL(
+	ctx context.Context,
+	tempUploadURL, method, fileName string,
+	body io.Reader,
+	size int64,
+) (accepted bool, err error) {
+	io2.OutputInfo("", "Will upload file %s (%d bytes) to %q, method %q", fileName, size, tempUploadURL, method)
+
+	pr := progress.NewReader(fileName, throttle.NewReader(ctx, body, s.limiter), size)
+	defer pr.Close()
+
+	req, err := http.NewRequestWithContext(ctx, method, tempUploadURL, pr)
+	if err != nil {
+		return false, err
+	}
+	req.ContentLength = size
+
 	cl := &http.Client{}
 	resp, err := cl.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to call upload URL %q, method %q: %v", tempUploadURL, method, err)
+		return false, fmt.Errorf("failed to call upload URL %q, method %q: %v", tempUploadURL, method, err)
 	}
 	defer resp.Body.Close()
 
@@ -223,13 +442,13 @@ func (s *Service) uploadToTempUploadURL(ctx context.Context, tempUploadURL, meth
 	io2.OutputInfo("", "response code %d, body %q", resp.StatusCode, string(bodyBytes))
 
 	if resp.StatusCode == http.StatusCreated {
-		io2.OutputInfo("", "successfully uploaded file %s to %s", file.Name(), tempUploadURL)
-		return nil
+		io2.OutputInfo("", "successfully uploaded file %s to %s", fileName, tempUploadURL)
+		return false, nil
 	}
 
 	if resp.StatusCode == http.StatusAccepted {
-		io2.OutputInfo("", "successfully uploaded file %s to %s, but it's not yet moved to the target localtion", file.Name(), tempUploadURL)
-		return nil
+		io2.OutputInfo("", "successfully uploaded file %s to %s, but it's not yet moved to the target localtion", fileName, tempUploadURL)
+		return true, nil
 	}
 
 	msg := ""
@@ -237,7 +456,7 @@ func (s *Service) uploadToTempUploadURL(ctx context.Context, tempUploadURL, meth
 	case http.StatusPreconditionFailed:
 		msg = "invalid range in Content-Range.header"
 	case http.StatusRequestEntityTooLarge:
-		msg = "file is too big (> 10gb)"
+		msg = "file is too big (> 10gb), decrease the volume size"
 	case http.StatusInternalServerError:
 		msg = "internal server error"
 	case http.StatusServiceUnavailable:
@@ -248,7 +467,8 @@ func (s *Service) uploadToTempUploadURL(ctx context.Context, tempUploadURL, meth
 		msg = "unknown error"
 	}
 
-	return errors.New(msg)
+	// server errors and throttling might go away, so only they are retried
+	return false, retry.ClassifyStatus(resp.StatusCode, errors.New(msg))
 }
 
 func join(path0, path1 string) string {
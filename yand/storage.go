@@ -0,0 +1,270 @@
+package yand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/breathbath/dumper/remote"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+const (
+	resourceTypeDir = "dir"
+	listPageSize    = 100
+	diskPathPrefix  = "disk:"
+)
+
+type Resource struct {
+	Path     string        `json:"path"`
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Size     int64         `json:"size"`
+	MD5      string        `json:"md5"`
+	SHA256   string        `json:"sha256"`
+	Modified time.Time     `json:"modified"`
+	Embedded *ResourceList `json:"_embedded"`
+}
+
+type ResourceList struct {
+	Items  []*Resource `json:"items"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+type Link struct {
+	Href   string `json:"href"`
+	Method string `json:"method"`
+}
+
+func (s *Service) newContext() (context.Context, context.CancelFunc) {
+	if s.cfg.UploadTimeout > 0 {
+		return context.WithTimeout(context.Background(), s.cfg.UploadTimeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+func (s *Service) getResource(ctx context.Context, remotePath string, offset int) (*Resource, error) {
+	query := url.Values{}
+	query.Set("path", remotePath)
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	query.Set("limit", strconv.Itoa(listPageSize))
+
+	res := new(Resource)
+	err := s.callAPI(ctx, http.MethodGet, "/resources", query, res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// List returns files which names relative to the remote folder start with prefix,
+// see https://yandex.ru/dev/disk/api/reference/meta.html for details
+func (s *Service) List(prefix string) ([]*remote.File, error) {
+	if err := s.cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.newContext()
+	defer cancel()
+
+	// no need to walk folders which can't contain files with the prefix
+	startDir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		startDir = prefix[:i]
+	}
+
+	files := []*remote.File{}
+	err := s.walk(ctx, join(s.cfg.RemoteFolder, startDir), func(res *Resource) {
+		file := s.toRemoteFile(res)
+		if strings.HasPrefix(file.Name, prefix) {
+			files = append(files, file)
+		}
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return files, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	return files, nil
+}
+
+func (s *Service) walk(ctx context.Context, dirPath string, fn func(res *Resource)) error {
+	for offset := 0; ; {
+		dir, err := s.getResource(ctx, dirPath, offset)
+		if err != nil {
+			return err
+		}
+
+		if dir.Embedded == nil || len(dir.Embedded.Items) == 0 {
+			return nil
+		}
+
+		for _, item := range dir.Embedded.Items {
+			if item.Type != resourceTypeDir {
+				fn(item)
+				continue
+			}
+
+			err = s.walk(ctx, item.Path, fn)
+			if err != nil {
+				return err
+			}
+		}
+
+		offset += len(dir.Embedded.Items)
+		if offset >= dir.Embedded.Total {
+			return nil
+		}
+	}
+}
+
+func (s *Service) Stat(name string) (*remote.File, error) {
+	if err := s.cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.newContext()
+	defer cancel()
+
+	return s.stat(ctx, name)
+}
+
+func (s *Service) stat(ctx context.Context, name string) (*remote.File, error) {
+	remotePath := join(s.cfg.RemoteFolder, name)
+	res, err := s.getResource(ctx, remotePath, 0)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%s: %w", remotePath, remote.ErrNotFound)
+		}
+		return nil, err
+	}
+
+	if res.Type == resourceTypeDir {
+		return nil, fmt.Errorf("%s is a folder", remotePath)
+	}
+
+	return s.toRemoteFile(res), nil
+}
+
+// Download writes content of the remote file to w,
+// see https://yandex.ru/dev/disk/api/reference/content.html for details
+func (s *Service) Download(name string, w io.Writer) error {
+	if err := s.cfg.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.newContext()
+	defer cancel()
+
+	remotePath := join(s.cfg.RemoteFolder, name)
+	link := new(Link)
+	err := s.callAPI(ctx, http.MethodGet, "/resources/download", url.Values{"path": []string{remotePath}}, link)
+	if err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("%s: %w", remotePath, remote.ErrNotFound)
+		}
+		return fmt.Errorf("failed to retrieve download link for %s: %v", remotePath, err)
+	}
+
+	io2.OutputInfo("", "Will download %s", remotePath)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.Href, http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	cl := &http.Client{}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", remotePath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: wrong response code %d", remotePath, resp.StatusCode)
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", remotePath, err)
+	}
+
+	io2.OutputInfo("", "downloaded %s, %d bytes", remotePath, n)
+
+	return nil
+}
+
+// Delete removes the remote file permanently bypassing the trash,
+// see https://yandex.ru/dev/disk/api/reference/delete.html for details
+func (s *Service) Delete(name string) error {
+	if err := s.cfg.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.newContext()
+	defer cancel()
+
+	remotePath := join(s.cfg.RemoteFolder, name)
+	query := url.Values{}
+	query.Set("path", remotePath)
+	query.Set("permanently", "true")
+
+	// the api responds with a link to the operation when deletion is done asynchronously
+	link := new(Link)
+	err := s.callAPI(ctx, http.MethodDelete, "/resources", query, link)
+	if err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("%s: %w", remotePath, remote.ErrNotFound)
+		}
+		return fmt.Errorf("failed to delete %s: %v", remotePath, err)
+	}
+
+	err = s.waitForLinkedOperation(ctx, link)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %v", remotePath, err)
+	}
+
+	io2.OutputInfo("", "deleted %s", remotePath)
+
+	return nil
+}
+
+// toRemoteFile converts a resource path like disk:/folder/db/dump.sql.gz to a name relative to the remote folder
+func (s *Service) toRemoteFile(res *Resource) *remote.File {
+	resPath := strings.TrimPrefix(res.Path, diskPathPrefix)
+	root := "/" + strings.Trim(strings.TrimPrefix(s.cfg.RemoteFolder, diskPathPrefix), "/")
+	name := strings.TrimPrefix(strings.TrimPrefix(resPath, root), "/")
+
+	return &remote.File{
+		Name:     name,
+		Path:     resPath,
+		Size:     res.Size,
+		MD5:      res.MD5,
+		SHA256:   res.SHA256,
+		Modified: res.Modified,
+	}
+}
+
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,71 @@
+package yand
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/breathbath/dumper/retry"
+	"github.com/breathbath/dumper/volume"
+	io2 "github.com/breathbath/go_utils/v3/pkg/io"
+)
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+
+	return n, err
+}
+
+// UploadStream uploads data of unknown size with chunked transfer encoding,
+// streams bigger than the volume size are split into volumes on the fly
+func (s *Service) UploadStream(name string, r io.Reader) error {
+	if err := s.cfg.Validate(); err != nil {
+		return retry.Permanent(err)
+	}
+
+	ctx, cancel := s.newContext()
+	defer cancel()
+
+	io2.OutputInfo("", "Will upload stream %s", name)
+
+	err := s.makeFolders(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	volumeSize := int64(s.cfg.VolumeSizeMb) * bytesInMb
+	if volumeSize <= 0 {
+		return s.uploadFile(ctx, name, r, -1)
+	}
+
+	return s.uploadStreamVolumes(ctx, name, r, volumeSize)
+}
+
+// uploadStreamVolumes uploads the stream as volumes, since the total size is not known in advance,
+// the first volume is renamed to the target name if the stream fits into it
+func (s *Service) uploadStreamVolumes(ctx context.Context, name string, r io.Reader, volumeSize int64) error {
+	m, err := volume.Split(path.Base(name), r, volumeSize, func(part *volume.Part, partReader io.Reader) error {
+		return s.uploadFile(ctx, siblingPath(name, part.Name), partReader, -1)
+	})
+	if err != nil {
+		return err
+	}
+
+	switch len(m.Parts) {
+	case 0:
+		return s.uploadFile(ctx, name, strings.NewReader(""), -1)
+	case 1:
+		return s.move(ctx, volume.PartName(name, 1), name)
+	}
+
+	io2.OutputInfo("", "Stream %s is bigger than %d bytes, uploaded it as %d volumes", name, volumeSize, len(m.Parts))
+
+	return s.uploadManifest(ctx, name, m)
+}